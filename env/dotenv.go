@@ -0,0 +1,397 @@
+package env
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// DotenvSyntaxError is returned when parsing an invalid dotenv file.
+type DotenvSyntaxError struct {
+	// Line is the 1-based line on which the error occurred.
+	Line    int
+	Message string
+}
+
+func (e *DotenvSyntaxError) Error() string {
+	return fmt.Sprintf("dotenv line %d: %s", e.Line, e.Message)
+}
+
+// ParseDotenv parses a dotenv file into an environment with static values, in
+// the order they are declared. Interpolation is enabled on the returned
+// environment, so ${NAME} references are expanded when it is resolved.
+//
+// Each variable is declared as NAME=VALUE, optionally prefixed by export.
+// Blank lines and lines starting with # are ignored. Values may be:
+//
+//   - unquoted, in which case they end at the end of the line or at a # that
+//     follows whitespace, and surrounding whitespace is removed;
+//   - single quoted, in which case they are used literally, without any
+//     escapes or references, and may span multiple lines;
+//   - double quoted, in which case they may span multiple lines and may
+//     contain the escapes \n, \r, \t, \", \\ and \$.
+//
+// A $ that doesn't start a ${NAME} reference is used literally, and $$ can be
+// used to include a literal $ outside single quotes. Names must be valid POSIX
+// names, as when unmarshalling an Environment, and may only be declared once.
+func ParseDotenv(r io.Reader) (Environment, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Environment{}, err
+	}
+
+	p := dotenvParser{
+		data: strings.ReplaceAll(string(data), "\r\n", "\n"),
+		line: 1,
+	}
+
+	var vars []variable
+	lines := map[string]int{}
+	for {
+		name, value, ok, err := p.next()
+		if err != nil {
+			return Environment{}, err
+		}
+		if !ok {
+			break
+		}
+
+		if first, ok := lines[name]; ok {
+			return Environment{}, &DotenvSyntaxError{Line: p.nameLine, Message: fmt.Sprintf("duplicate variable %s, first declared on line %d", name, first)}
+		}
+		lines[name] = p.nameLine
+		vars = append(vars, variable{name: name, value: &value})
+	}
+
+	return Environment{vars: vars}.WithInterpolation(), nil
+}
+
+// dotenvParser parses dotenv variables. The values it returns are escaped for
+// interpolation.
+type dotenvParser struct {
+	data string
+	pos  int
+	line int
+
+	// nameLine is the line on which the last variable was declared.
+	nameLine int
+}
+
+// next parses the next variable, returning false once the end of the data has
+// been reached.
+func (p *dotenvParser) next() (name, value string, ok bool, err error) {
+	// Skip any blank and comment lines.
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return "", "", false, nil
+		}
+		if c := p.peek(); c == '\n' {
+			p.advance()
+		} else if c == '#' {
+			p.skipLine()
+		} else {
+			break
+		}
+	}
+
+	p.nameLine = p.line
+
+	if strings.HasPrefix(p.data[p.pos:], "export") && p.pos+6 < len(p.data) && isDotenvSpace(p.data[p.pos+6]) {
+		p.pos += 6
+		p.skipSpace()
+	}
+
+	start := p.pos
+	for !p.eof() && !isDotenvSpace(p.peek()) && p.peek() != '=' && p.peek() != '\n' {
+		p.advance()
+	}
+	name = p.data[start:p.pos]
+	if name == "" {
+		return "", "", false, p.errorf("expected variable name")
+	}
+	if isPattern(name) {
+		return "", "", false, p.errorf("invalid variable name %q: patterns can't have values", name)
+	}
+	if reason := checkName(name, false); reason != "" {
+		return "", "", false, p.errorf("invalid variable name %q: %s", name, reason)
+	}
+
+	p.skipSpace()
+	if p.eof() || p.peek() != '=' {
+		return "", "", false, p.errorf("expected = after %s", name)
+	}
+	p.advance()
+	p.skipSpace()
+
+	if p.eof() {
+		return name, "", true, nil
+	}
+	switch p.peek() {
+	case '\'':
+		value, err = p.singleQuoted()
+	case '"':
+		value, err = p.doubleQuoted()
+	default:
+		return name, p.unquoted(), true, nil
+	}
+	if err != nil {
+		return "", "", false, err
+	}
+
+	// Only whitespace and comments may follow a quoted value.
+	p.skipSpace()
+	if !p.eof() && p.peek() == '#' {
+		p.skipLine()
+	}
+	if !p.eof() && p.peek() != '\n' {
+		return "", "", false, p.errorf("unexpected %q after quoted value of %s", p.peek(), name)
+	}
+
+	return name, value, true, nil
+}
+
+func (p *dotenvParser) unquoted() string {
+	start := p.pos
+	for !p.eof() && p.peek() != '\n' {
+		if p.peek() == '#' && p.pos > start && isDotenvSpace(p.data[p.pos-1]) {
+			value := p.data[start:p.pos]
+			p.skipLine()
+			return strings.TrimRight(value, " \t")
+		}
+		p.advance()
+	}
+	return strings.TrimRight(p.data[start:p.pos], " \t")
+}
+
+func (p *dotenvParser) singleQuoted() (string, error) {
+	line := p.line
+	p.advance()
+
+	start := p.pos
+	for !p.eof() && p.peek() != '\'' {
+		p.advance()
+	}
+	if p.eof() {
+		return "", &DotenvSyntaxError{Line: line, Message: "unterminated single-quoted value"}
+	}
+	value := p.data[start:p.pos]
+	p.advance()
+
+	// Single-quoted values are literal, so must not be interpolated.
+	return strings.ReplaceAll(value, "$", "$$"), nil
+}
+
+func (p *dotenvParser) doubleQuoted() (string, error) {
+	line := p.line
+	p.advance()
+
+	var b strings.Builder
+	for !p.eof() {
+		c := p.peek()
+		p.advance()
+
+		switch c {
+		case '"':
+			return b.String(), nil
+
+		case '\\':
+			if p.eof() {
+				break
+			}
+			switch e := p.peek(); e {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case '"', '\\':
+				b.WriteByte(e)
+			case '$':
+				b.WriteString("$$")
+			default:
+				// Unknown escapes are kept as is.
+				b.WriteByte('\\')
+				continue
+			}
+			p.advance()
+
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return "", &DotenvSyntaxError{Line: line, Message: "unterminated double-quoted value"}
+}
+
+func (p *dotenvParser) eof() bool { return p.pos >= len(p.data) }
+
+func (p *dotenvParser) peek() byte { return p.data[p.pos] }
+
+func (p *dotenvParser) advance() {
+	if p.data[p.pos] == '\n' {
+		p.line++
+	}
+	p.pos++
+}
+
+func (p *dotenvParser) skipSpace() {
+	for !p.eof() && isDotenvSpace(p.peek()) {
+		p.advance()
+	}
+}
+
+// skipLine skips to the end of the current line, leaving the newline to be
+// consumed.
+func (p *dotenvParser) skipLine() {
+	for !p.eof() && p.peek() != '\n' {
+		p.advance()
+	}
+}
+
+func (p *dotenvParser) errorf(format string, args ...interface{}) error {
+	return &DotenvSyntaxError{Line: p.line, Message: fmt.Sprintf(format, args...)}
+}
+
+func isDotenvSpace(c byte) bool { return c == ' ' || c == '\t' }
+
+// errUnrepresentableVariable is returned when a resolved environment cannot be
+// written in a format, since a variable's name or value cannot be represented
+// in it.
+type errUnrepresentableVariable struct {
+	name   string
+	format string
+	reason string
+}
+
+func (e errUnrepresentableVariable) Error() string {
+	return fmt.Sprintf("environment variable %q cannot be written as %s: %s", e.name, e.format, e.reason)
+}
+
+// WriteDotenv writes the environment in the dotenv format understood by
+// ParseDotenv, in declaration order. Values are quoted and escaped as needed,
+// and are never interpolated when parsed. Secret values are included.
+func (r *ResolvedEnvironment) WriteDotenv(w io.Writer) error {
+	return r.write(w, "dotenv", func(b *bytes.Buffer, name, value string) error {
+		if name == "" || name[0] == '#' || strings.ContainsAny(name, "= \t\n\r") {
+			return errUnrepresentableVariable{name: name, format: "dotenv", reason: "invalid name"}
+		}
+
+		b.WriteString(name)
+		b.WriteByte('=')
+		if isDotenvSafe(value) {
+			b.WriteString(value)
+		} else {
+			b.WriteByte('"')
+			b.WriteString(dotenvQuoter.Replace(value))
+			b.WriteByte('"')
+		}
+		return nil
+	})
+}
+
+var dotenvQuoter = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"$", `\$`,
+)
+
+// isDotenvSafe returns true if the value can be written without quotes.
+func isDotenvSafe(value string) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("_-./:@%+,=", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// WriteDockerEnvFile writes the environment in the format expected by docker
+// run --env-file, in declaration order. This format has no quoting or
+// escaping, so values cannot contain newlines. Secret values are included.
+func (r *ResolvedEnvironment) WriteDockerEnvFile(w io.Writer) error {
+	return r.write(w, "a docker env-file", func(b *bytes.Buffer, name, value string) error {
+		if name == "" || name[0] == '#' || strings.ContainsAny(name, "= \t\n\r") {
+			return errUnrepresentableVariable{name: name, format: "a docker env-file", reason: "invalid name"}
+		}
+		if strings.ContainsAny(value, "\n\r") {
+			return errUnrepresentableVariable{name: name, format: "a docker env-file", reason: "value contains a line break"}
+		}
+
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(value)
+		return nil
+	})
+}
+
+// WriteShellExports writes the environment as a POSIX shell script that
+// exports each variable, in declaration order. Values are single quoted.
+// Secret values are included.
+func (r *ResolvedEnvironment) WriteShellExports(w io.Writer) error {
+	return r.write(w, "a shell export", func(b *bytes.Buffer, name, value string) error {
+		if !isShellName(name) {
+			return errUnrepresentableVariable{name: name, format: "a shell export", reason: "invalid name"}
+		}
+
+		b.WriteString("export ")
+		b.WriteString(name)
+		b.WriteString("='")
+		b.WriteString(strings.ReplaceAll(value, "'", `'\''`))
+		b.WriteByte('\'')
+		return nil
+	})
+}
+
+// write writes a line for each variable using the given function. Nothing is
+// written if any variable cannot be.
+func (r *ResolvedEnvironment) write(w io.Writer, format string, line func(b *bytes.Buffer, name, value string) error) error {
+	var b bytes.Buffer
+	for _, name := range r.names {
+		if err := line(&b, name, r.values[name]); err != nil {
+			return err
+		}
+		b.WriteByte('\n')
+	}
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// isShellName returns true if name is a valid POSIX shell variable name.
+func isShellName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c == '_', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case '0' <= c && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// resolveDotenv parses a dotenv file and resolves it on its own: references to
+// variables that aren't declared earlier in the file are undefined, rather
+// than being read from an outer environment that no Policy would apply to.
+func resolveDotenv(ctx context.Context, r io.Reader) (map[string]string, error) {
+	env, err := ParseDotenv(r)
+	if err != nil {
+		return nil, err
+	}
+	return env.ResolveFrom(ctx, MapSource{})
+}
@@ -0,0 +1,350 @@
+package env
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseDotenv(t *testing.T) {
+	outer := []string{"HOME=/home/me", "PATH=/bin"}
+
+	for name, tc := range map[string]struct {
+		in   string
+		want map[string]string
+	}{
+		"empty": {
+			in:   "",
+			want: map[string]string{},
+		},
+		"blank lines and comments": {
+			in:   "\n  \n# A=1\n\t# B=2\n",
+			want: map[string]string{},
+		},
+		"unquoted": {
+			in:   "A=1\nB = two words  \nC=\nD=a=b\nE=#not a comment\nF=value # comment\nG=value\t# comment\nH=a#b",
+			want: map[string]string{"A": "1", "B": "two words", "C": "", "D": "a=b", "E": "#not a comment", "F": "value", "G": "value", "H": "a#b"},
+		},
+		"export": {
+			in:   "export A=1\nexport\tB=2\nexport=3\nexports=4",
+			want: map[string]string{"A": "1", "B": "2", "export": "3", "exports": "4"},
+		},
+		"single quoted": {
+			in:   `A='  spaced  '` + "\n" + `B='a "b" \n ${HOME} $$ #c' # comment` + "\nC=''",
+			want: map[string]string{"A": "  spaced  ", "B": `a "b" \n ${HOME} $$ #c`, "C": ""},
+		},
+		"double quoted": {
+			in:   `A="  spaced  "` + "\n" + `B="a \"b\" \\ \n\t\r \$HOME \${HOME} \x #c" # comment` + "\n" + `C=""`,
+			want: map[string]string{"A": "  spaced  ", "B": "a \"b\" \\ \n\t\r $HOME ${HOME} \\x #c", "C": ""},
+		},
+		"multi-line": {
+			in:   "A=\"line one\nline two\"\nB='line one\n\nline three'\nC=after",
+			want: map[string]string{"A": "line one\nline two", "B": "line one\n\nline three", "C": "after"},
+		},
+		"CRLF": {
+			in:   "A=1\r\nB=\"x\r\ny\"\r\n",
+			want: map[string]string{"A": "1", "B": "x\ny"},
+		},
+		"references": {
+			in:   "A=${HOME}/a\nB=\"${A}/b\"\nPATH=${PATH}:/usr/bin\nC=$HOME\nD=$$HOME\nE=\"$${HOME}\"",
+			want: map[string]string{"A": "/home/me/a", "B": "/home/me/a/b", "PATH": "/bin:/usr/bin", "C": "$HOME", "D": "$HOME", "E": "${HOME}"},
+		},
+		"unicode": {
+			in:   "A=héllo\nB=\"日本語 ✓\"",
+			want: map[string]string{"A": "héllo", "B": "日本語 ✓"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			env, err := ParseDotenv(strings.NewReader(tc.in))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			have, err := env.Resolve(outer)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(have, tc.want); diff != "" {
+				t.Errorf("unexpected environment:\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("order", func(t *testing.T) {
+		env, err := ParseDotenv(strings.NewReader("Z=1\nexport A=2\n\nM='3'"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if diff := cmp.Diff(env.Names(), []string{"Z", "A", "M"}); diff != "" {
+			t.Errorf("unexpected names:\n%s", diff)
+		}
+		if !env.IsStatic() {
+			t.Error("unexpected non-static environment")
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			in   string
+			line int
+		}{
+			"no equals":                   {in: "A=1\nB\n", line: 2},
+			"space in name":               {in: "A B=1", line: 1},
+			"no name":                     {in: "\n\n=1", line: 3},
+			"export without name":         {in: "export =1", line: 1},
+			"unterminated single quote":   {in: "A=1\nB='abc\n\ndef", line: 2},
+			"unterminated double quote":   {in: "A=\"abc\\\"", line: 1},
+			"text after quoted value":     {in: "A='abc'def", line: 1},
+			"text after multi-line value": {in: "A=\"a\nb\" c", line: 2},
+			"name starting with a digit":  {in: "A=1\n1BAD=2", line: 2},
+			"name with a dash":            {in: "A-B=1", line: 1},
+			"pattern":                     {in: "export AWS_*=1", line: 1},
+			"duplicate":                   {in: "A=1\nB=2\n\n# again\nexport A=\"3\"", line: 5},
+		} {
+			t.Run(name, func(t *testing.T) {
+				_, err := ParseDotenv(strings.NewReader(tc.in))
+
+				var e *DotenvSyntaxError
+				if !errors.As(err, &e) {
+					t.Fatalf("unexpected error of type %T: %v", err, err)
+				}
+				if e.Line != tc.line {
+					t.Errorf("unexpected line: have=%d want=%d (%v)", e.Line, tc.line, e)
+				}
+			})
+		}
+	})
+}
+
+// awkwardEnvironment contains values that need quoting or escaping in at least
+// one of the output formats.
+var awkwardEnvironment = Environment{vars: []variable{
+	{name: "PLAIN", value: stringPtr("plain-value_1.2/3:4@5%6+7,8=9")},
+	{name: "EMPTY", value: stringPtr("")},
+	{name: "SPACES", value: stringPtr("  leading and trailing  ")},
+	{name: "QUOTES", value: stringPtr(`it's "quoted"`)},
+	{name: "BACKSLASHES", value: stringPtr(`C:\path\to\n\file\\`)},
+	{name: "DOLLARS", value: stringPtr("$HOME ${HOME} $$ $")},
+	{name: "HASH", value: stringPtr("# not a comment #")},
+	{name: "TAB", value: stringPtr("a\tb")},
+	{name: "UNICODE", value: stringPtr("日本語 ✓ é")},
+	{name: "SECRET", value: stringPtr("'s3cr3t'"), secret: true},
+}}
+
+// multiLineEnvironment contains values that can't be written to docker
+// env-files.
+var multiLineEnvironment = Environment{vars: []variable{
+	{name: "NEWLINES", value: stringPtr("line one\nline two\n")},
+	{name: "CARRIAGE", value: stringPtr("a\r\nb\r")},
+}}
+
+func resolveForTest(t *testing.T, env Environment) *ResolvedEnvironment {
+	t.Helper()
+
+	r, err := env.ResolveEnvironment(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return r
+}
+
+func TestResolvedEnvironment_WriteDotenv(t *testing.T) {
+	t.Run("output", func(t *testing.T) {
+		r := resolveForTest(t, Environment{vars: []variable{
+			{name: "A", value: stringPtr("plain")},
+			{name: "B", value: stringPtr("")},
+			{name: "C", value: stringPtr("it's \"${X}\"\\\n")},
+			{name: "D", value: stringPtr("s3cr3t"), secret: true},
+		}})
+
+		var buf bytes.Buffer
+		if err := r.WriteDotenv(&buf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := "A=plain\nB=\nC=\"it's \\\"\\${X}\\\"\\\\\\n\"\nD=s3cr3t\n"
+		if have := buf.String(); have != want {
+			t.Errorf("unexpected output:\nhave=%q\nwant=%q", have, want)
+		}
+	})
+
+	for name, env := range map[string]Environment{
+		"awkward":    awkwardEnvironment,
+		"multi-line": multiLineEnvironment,
+	} {
+		t.Run("round trip "+name, func(t *testing.T) {
+			r := resolveForTest(t, env)
+
+			var buf bytes.Buffer
+			if err := r.WriteDotenv(&buf); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			parsed, err := ParseDotenv(&buf)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(parsed.Names(), r.Names()); diff != "" {
+				t.Errorf("unexpected names:\n%s", diff)
+			}
+
+			have, err := parsed.Resolve(nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(have, r.Map()); diff != "" {
+				t.Errorf("unexpected values:\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("invalid name", func(t *testing.T) {
+		r := resolveForTest(t, Environment{vars: []variable{
+			{name: "A", value: stringPtr("a")},
+			{name: "B C", value: stringPtr("b")},
+		}})
+
+		var buf bytes.Buffer
+		if err := r.WriteDotenv(&buf); err == nil {
+			t.Error("unexpected nil error")
+		}
+		if buf.Len() != 0 {
+			t.Errorf("unexpected partial output: %q", buf.String())
+		}
+	})
+}
+
+// parseDockerEnvFile parses an env-file in the same way as docker run.
+func parseDockerEnvFile(t *testing.T, data string) map[string]string {
+	t.Helper()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimLeft(scanner.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			t.Fatalf("unexpected line without value: %q", line)
+		}
+		values[kv[0]] = kv[1]
+	}
+	return values
+}
+
+func TestResolvedEnvironment_WriteDockerEnvFile(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		r := resolveForTest(t, awkwardEnvironment)
+
+		var buf bytes.Buffer
+		if err := r.WriteDockerEnvFile(&buf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		have := parseDockerEnvFile(t, buf.String())
+		if diff := cmp.Diff(have, r.Map()); diff != "" {
+			t.Errorf("unexpected values:\n%s", diff)
+		}
+	})
+
+	for name, env := range map[string]Environment{
+		"newline":          {vars: []variable{{name: "A", value: stringPtr("a\nb")}}},
+		"carriage return":  {vars: []variable{{name: "A", value: stringPtr("a\r")}}},
+		"invalid name":     {vars: []variable{{name: "A=B", value: stringPtr("a")}}},
+		"comment name":     {vars: []variable{{name: "#A", value: stringPtr("a")}}},
+		"whitespace name":  {vars: []variable{{name: " A", value: stringPtr("a")}}},
+		"multi-line value": multiLineEnvironment,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := resolveForTest(t, env).WriteDockerEnvFile(&buf); err == nil {
+				t.Error("unexpected nil error")
+			}
+			if buf.Len() != 0 {
+				t.Errorf("unexpected partial output: %q", buf.String())
+			}
+		})
+	}
+}
+
+func TestResolvedEnvironment_WriteShellExports(t *testing.T) {
+	t.Run("output", func(t *testing.T) {
+		r := resolveForTest(t, Environment{vars: []variable{
+			{name: "A", value: stringPtr("plain")},
+			{name: "B", value: stringPtr("")},
+			{name: "C", value: stringPtr(`it's "$HOME"`)},
+		}})
+
+		var buf bytes.Buffer
+		if err := r.WriteShellExports(&buf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := "export A='plain'\nexport B=''\nexport C='it'\\''s \"$HOME\"'\n"
+		if have := buf.String(); have != want {
+			t.Errorf("unexpected output:\nhave=%q\nwant=%q", have, want)
+		}
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		sh, err := exec.LookPath("sh")
+		if err != nil {
+			t.Skip("sh is not available")
+		}
+
+		for name, env := range map[string]Environment{
+			"awkward":    awkwardEnvironment,
+			"multi-line": multiLineEnvironment,
+		} {
+			t.Run(name, func(t *testing.T) {
+				r := resolveForTest(t, env)
+
+				var script bytes.Buffer
+				if err := r.WriteShellExports(&script); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				// Print each variable NUL-terminated, so that values
+				// containing newlines can be compared exactly.
+				for _, name := range r.Names() {
+					script.WriteString(`printf '%s\0' "$` + name + "\"\n")
+				}
+
+				cmd := exec.Command(sh)
+				cmd.Stdin = &script
+				cmd.Env = []string{}
+				out, err := cmd.Output()
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				values := strings.Split(string(out), "\x00")
+				have := make(map[string]string, len(r.Names()))
+				for i, name := range r.Names() {
+					have[name] = values[i]
+				}
+				if diff := cmp.Diff(have, r.Map()); diff != "" {
+					t.Errorf("unexpected values:\n%s", diff)
+				}
+			})
+		}
+	})
+
+	for name, varName := range map[string]string{
+		"empty":         "",
+		"leading digit": "1A",
+		"dash":          "A-B",
+		"dot":           "A.B",
+	} {
+		t.Run("invalid name "+name, func(t *testing.T) {
+			r := resolveForTest(t, Environment{vars: []variable{{name: varName, value: stringPtr("a")}}})
+			if err := r.WriteShellExports(&bytes.Buffer{}); err == nil {
+				t.Error("unexpected nil error")
+			}
+		})
+	}
+}
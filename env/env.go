@@ -2,11 +2,19 @@
 package env
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/pkg/errors"
+	yamlv2 "gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+
+	"github.com/sourcegraph/batch-change-utils/internal/jsonwalk"
 )
 
 // Environment represents an environment used for a campaign step, which may
@@ -14,6 +22,160 @@ import (
 // running within.
 type Environment struct {
 	vars []variable
+
+	// interpolate enables the expansion of ${NAME} references within static
+	// values.
+	interpolate bool
+	// strict causes Resolve to fail if required variables are missing.
+	strict bool
+	// policy restricts the outer variables that may be read by Resolve.
+	policy *Policy
+	// relaxedNames allows any variable name that most platforms accept to be
+	// unmarshalled, rather than only POSIX names.
+	relaxedNames bool
+}
+
+// New returns an empty environment, to which variables can be added with
+// WithStatic and WithPassthrough.
+func New() Environment {
+	return Environment{}
+}
+
+// WithStatic returns a copy of the environment in which the variable with the
+// given name has the given static value. If the variable is already declared,
+// it's replaced in place; otherwise, it's appended. An error is returned if
+// name is invalid, as when unmarshalling, or a pattern, since patterns can't
+// have static values.
+func (e Environment) WithStatic(name, value string) (Environment, error) {
+	if err := e.checkNewName(name); err != nil {
+		return e, err
+	}
+	var v variable
+	if err := v.fromValue(name, value); err != nil {
+		return e, err
+	}
+	return e.withVariable(v), nil
+}
+
+// MustWithStatic is like WithStatic, but panics if name is invalid. It's
+// intended for names that are known to be valid, such as constants.
+func (e Environment) MustWithStatic(name, value string) Environment {
+	e, err := e.WithStatic(name, value)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+// WithPassthrough returns a copy of the environment in which the variable
+// with the given name, or the variables matching the given pattern, such as
+// AWS_*, are passed through from the outer environment. If the variable is
+// already declared, it's replaced in place; otherwise, it's appended. An error
+// is returned if name is invalid, as when unmarshalling, or an invalid pattern.
+func (e Environment) WithPassthrough(name string) (Environment, error) {
+	if err := e.checkNewName(name); err != nil {
+		return e, err
+	}
+	var v variable
+	if err := v.fromName(name); err != nil {
+		return e, err
+	}
+	return e.withVariable(v), nil
+}
+
+// MustWithPassthrough is like WithPassthrough, but panics if name is invalid.
+// It's intended for names that are known to be valid, such as constants.
+func (e Environment) MustWithPassthrough(name string) Environment {
+	e, err := e.WithPassthrough(name)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+// checkNewName checks a name being added by a builder, which takes the index
+// of the variable it replaces, or the next index. Names can't be duplicated,
+// since existing variables are replaced.
+func (e Environment) checkNewName(name string) error {
+	index := len(e.vars)
+	for i, v := range e.vars {
+		if v.name == name {
+			index = i
+			break
+		}
+	}
+
+	if reason := checkName(name, e.relaxedNames); reason != "" {
+		return errInvalidVariableName{name: name, index: index, reason: reason}
+	}
+	return nil
+}
+
+// withVariable returns a copy of the environment with v set, without sharing
+// the variables of the original.
+func (e Environment) withVariable(v variable) Environment {
+	vars := make([]variable, len(e.vars), len(e.vars)+1)
+	copy(vars, e.vars)
+	e.vars = setVariable(vars, v)
+	return e
+}
+
+// WithInterpolation returns a copy of the environment with interpolation
+// enabled: ${NAME} references within static values will be expanded by
+// Resolve, using the values of the variables declared before them in the
+// environment, or the outer environment if NAME isn't declared before them. A
+// variable referencing itself, such as PATH: ${PATH}:/usr/local/bin, uses the
+// outer value. $$ can be used to include a literal $.
+func (e Environment) WithInterpolation() Environment {
+	e.interpolate = true
+	return e
+}
+
+// WithStrictResolution returns a copy of the environment that will be resolved
+// strictly: Resolve will return a *MissingVariablesError if any required
+// variables aren't set in the outer environment, rather than using empty
+// strings.
+func (e Environment) WithStrictResolution() Environment {
+	e.strict = true
+	return e
+}
+
+// WithPolicy returns a copy of the environment that will be resolved
+// according to the given policy: Resolve will return a *PolicyError if the
+// environment would read any outer variable blocked by the policy, and
+// pattern variables won't match any blocked outer variables. See Policy.Check.
+func (e Environment) WithPolicy(p *Policy) Environment {
+	e.policy = p
+	return e
+}
+
+// WithRelaxedNames returns a copy of the environment that accepts variable
+// names other than POSIX names when unmarshalled into, such as
+// ProgramFiles(x86) or my-var: names only need to be non-empty, and not contain
+// = or NUL. For example:
+//
+//	e := env.New().WithRelaxedNames()
+//	err := json.Unmarshal(data, &e)
+//
+// Duplicate names are rejected regardless.
+func (e Environment) WithRelaxedNames() Environment {
+	e.relaxedNames = true
+	return e
+}
+
+// MissingVariablesError is returned when strictly resolving an environment in
+// which required variables aren't set in the outer environment.
+type MissingVariablesError struct {
+	// Names contains the names of the missing variables, in the order they
+	// were declared.
+	Names []string
+}
+
+func (e *MissingVariablesError) Error() string {
+	if len(e.Names) == 1 {
+		return fmt.Sprintf("required environment variable %s is not set", e.Names[0])
+	}
+	return fmt.Sprintf("required environment variables %s are not set", strings.Join(e.Names, ", "))
 }
 
 // MarshalJSON marshals the environment.
@@ -24,13 +186,33 @@ func (e Environment) MarshalJSON() ([]byte, error) {
 
 	// For compatibility with older versions of Sourcegraph, if all environment
 	// variables have static values defined, we'll encode to the object variant.
-	if e.IsStatic() {
-		vars := make(map[string]string, len(e.vars))
-		for _, v := range e.vars {
-			vars[v.name] = *v.value
+	// We build the object by hand, since encoding a map would lose the order
+	// of the variables. Secret variables can't be represented in the object
+	// variant.
+	if e.hasOnlyStaticValues() && !e.hasSecrets() {
+		var buf bytes.Buffer
+		buf.WriteByte('{')
+		for i, v := range e.vars {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+
+			name, err := json.Marshal(v.name)
+			if err != nil {
+				return nil, err
+			}
+			value, err := json.Marshal(*v.value)
+			if err != nil {
+				return nil, err
+			}
+
+			buf.Write(name)
+			buf.WriteByte(':')
+			buf.Write(value)
 		}
+		buf.WriteByte('}')
 
-		return json.Marshal(vars)
+		return buf.Bytes(), nil
 	}
 
 	// Otherwise, we have to return the array variant.
@@ -39,65 +221,299 @@ func (e Environment) MarshalJSON() ([]byte, error) {
 
 // UnmarshalJSON unmarshals an environment from one of the two supported JSON
 // forms: an array, or a string→string object.
+//
+// Values may be any scalar, rather than only strings: numbers and booleans are
+// converted to strings using their original text, so 1.10 stays 1.10, and
+// null is converted to an empty string. Maps and lists are invalid values.
+//
+// Variable names must be unique, and must be POSIX names or patterns unless
+// the environment was created with WithRelaxedNames.
 func (e *Environment) UnmarshalJSON(data []byte) error {
 	// data is either an array or object. (Or invalid.) Let's start by trying to
 	// unmarshal it as an array.
 	if err := json.Unmarshal(data, &e.vars); err == nil {
-		return nil
+		return e.validateNames()
+	} else if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		// It is an array, so the error is about one of its variables.
+		return err
 	}
 
 	// It's an object, then. We need to put it into a map, then convert it into
-	// an array of variables.
-	kv := make(map[string]string)
-	if err := json.Unmarshal(data, &kv); err != nil {
+	// an array of variables in the order the keys appear in the object.
+	kv, err := unmarshalStaticValues(func(out interface{}) error { return json.Unmarshal(data, out) })
+	if err != nil {
 		return err
 	}
 
-	e.vars = make([]variable, len(kv))
-	i := 0
-	for k, v := range kv {
-		copy := v
-		e.vars[i].name = k
-		e.vars[i].value = &copy
-		i++
+	members, err := jsonwalk.Members(data)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, len(members))
+	for i, m := range members {
+		keys[i] = m.Key
+	}
+	if err := checkNames(keys, e.relaxedNames); err != nil {
+		return err
 	}
 
+	e.vars = staticVariables(keys, kv)
 	return nil
 }
 
 // UnmarshalYAML unmarshals an environment from one of the two supported YAML
 // forms: an array, or a string→string object.
+//
+// As with JSON, values may be any scalar, and keep their original text: for
+// example, PORT: 8080 and VERSION: 1.10 are the strings 8080 and 1.10. Null
+// values, such as FOO: ~, are empty strings.
 func (e *Environment) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	// data is either an array or object. (Or invalid.) Let's start by trying to
 	// unmarshal it as an array.
-	if err := unmarshal(&e.vars); err == nil {
-		return nil
+	err := unmarshal(&e.vars)
+	if err == nil {
+		return e.validateNames()
+	}
+	var seq []interface{}
+	if unmarshal(&seq) == nil {
+		// It is a sequence, so the error is about one of its variables.
+		return err
 	}
 
-	// It's an object, then. As above, we need to convert this via a map.
-	kv := make(map[string]string)
-	if err := unmarshal(&kv); err != nil {
+	// It's an object, then. As above, we need to convert this via a map. We
+	// check the keys first, since yaml.v3 would otherwise reject duplicate keys
+	// with a less specific error.
+	keys := yamlKeys(unmarshal)
+	if err := checkNames(keys, e.relaxedNames); err != nil {
+		return err
+	}
+	kv, err := unmarshalStaticValues(unmarshal)
+	if err != nil {
 		return err
 	}
 
-	e.vars = make([]variable, len(kv))
-	i := 0
-	for k, v := range kv {
-		copy := v
-		e.vars[i].name = k
-		e.vars[i].value = &copy
-		i++
+	e.vars = staticVariables(keys, kv)
+	return nil
+}
+
+// validateNames checks the names of the variables in the environment: see
+// checkNames.
+func (e Environment) validateNames() error {
+	names := make([]string, len(e.vars))
+	for i, v := range e.vars {
+		names[i] = v.name
+	}
+	return checkNames(names, e.relaxedNames)
+}
+
+// checkNames checks that each of the given names, in the order they were
+// declared, is valid and unique. Unset markers count as declarations, since
+// an environment can't both declare and unset a variable.
+func checkNames(names []string, relaxed bool) error {
+	indexes := make(map[string][]int, len(names))
+	for i, name := range names {
+		if reason := checkName(name, relaxed); reason != "" {
+			return errInvalidVariableName{name: name, index: i, reason: reason}
+		}
+		indexes[name] = append(indexes[name], i)
+	}
+
+	for _, name := range names {
+		if len(indexes[name]) > 1 {
+			return errDuplicateVariable{name: name, indexes: indexes[name]}
+		}
+	}
+	return nil
+}
+
+// unmarshalStaticValues unmarshals an object of static variables, ensuring
+// that every value is a scalar.
+func unmarshalStaticValues(unmarshal func(interface{}) error) (map[string]*scalar, error) {
+	var raw map[string]interface{}
+	if err := unmarshal(&raw); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(raw))
+	for name := range raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := checkScalar(name, "value", raw[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	// A nil value is null, which we still need to keep in the map.
+	kv := make(map[string]*scalar, len(raw))
+	if err := unmarshal(&kv); err != nil {
+		return nil, err
+	}
+	return kv, nil
+}
+
+// staticVariables converts an object of static variables into a slice of
+// variables, ordered by the given keys: see orderKeys.
+func staticVariables(keys []string, kv map[string]*scalar) []variable {
+	all := make([]string, 0, len(kv))
+	for k := range kv {
+		all = append(all, k)
+	}
+
+	vars := make([]variable, 0, len(kv))
+	for _, k := range orderKeys(keys, all) {
+		v := kv[k].value()
+		vars = append(vars, variable{name: k, value: &v})
+	}
+	return vars
+}
+
+// orderKeys orders the keys of an object by the order they were declared in.
+// Declared keys that aren't in the object are ignored, and any keys in the
+// object that weren't declared are appended in lexicographical order.
+func orderKeys(declared, keys []string) []string {
+	present := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		present[k] = true
+	}
+
+	ordered := make([]string, 0, len(keys))
+	seen := make(map[string]bool, len(keys))
+	for _, k := range declared {
+		if present[k] && !seen[k] {
+			ordered = append(ordered, k)
+			seen[k] = true
+		}
+	}
+
+	var rest []string
+	for _, k := range keys {
+		if !seen[k] {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+
+	return append(ordered, rest...)
+}
+
+// yamlKeys returns the keys of the YAML mapping being unmarshalled, in the
+// order they are declared, or nil if they cannot be determined.
+//
+// Environment implements the yaml.v2 unmarshaller interface, which yaml.v3
+// also supports, so we have to handle both: yaml.v2 can unmarshal a mapping
+// into a MapSlice, whereas yaml.v3 will invoke yamlNodeKeys with the mapping
+// node. yaml.v2 resolves some unquoted keys, such as Y, to non-string values
+// whose original text is lost: staticVariables will append these at the end.
+func yamlKeys(unmarshal func(interface{}) error) []string {
+	var nk yamlNodeKeys
+	if err := unmarshal(&nk); err == nil {
+		return nk
+	}
+
+	var ms yamlv2.MapSlice
+	if err := unmarshal(&ms); err == nil {
+		keys := make([]string, len(ms))
+		for i, item := range ms {
+			keys[i] = fmt.Sprint(item.Key)
+		}
+		return keys
+	}
+
+	return nil
+}
+
+// yamlNodeKeys implements the yaml.v3 unmarshaller interface to collect the
+// keys of a mapping node.
+type yamlNodeKeys []string
+
+func (nk *yamlNodeKeys) UnmarshalYAML(node *yamlv3.Node) error {
+	if node.Kind != yamlv3.MappingNode {
+		return errors.New("not a mapping")
 	}
 
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		*nk = append(*nk, node.Content[i].Value)
+	}
 	return nil
 }
 
+// Names returns the names of the variables in the environment, in the order
+// they were declared. Pattern variables, such as AWS_*, are returned as
+// patterns, and unset markers are omitted.
+func (e Environment) Names() []string {
+	names := make([]string, 0, len(e.vars))
+	for _, v := range e.vars {
+		if !v.unset {
+			names = append(names, v.name)
+		}
+	}
+	return names
+}
+
+// Len returns the number of variables in the environment, counting each
+// pattern variable once. Unset markers aren't counted.
+func (e Environment) Len() int {
+	n := 0
+	for _, v := range e.vars {
+		if !v.unset {
+			n++
+		}
+	}
+	return n
+}
+
+// Lookup returns the variable with the given name or pattern. ok is false if
+// the variable isn't declared in the environment.
+func (e Environment) Lookup(name string) (v Variable, ok bool) {
+	for _, v := range e.vars {
+		if v.name == name && !v.unset {
+			return Variable{v: v}, true
+		}
+	}
+	return Variable{}, false
+}
+
+// Range calls f for each variable in the environment, in the order they were
+// declared, until f returns false. Unset markers are skipped.
+func (e Environment) Range(f func(v Variable) bool) {
+	for _, v := range e.vars {
+		if v.unset {
+			continue
+		}
+		if !f(Variable{v: v}) {
+			return
+		}
+	}
+}
+
 // IsStatic returns true if the environment doesn't depend on any outer
 // environment variables.
 //
 // Put another way: if this function returns true, then Resolve() will always
-// return the same map for the environment.
+// return the same map for the environment. If interpolation is enabled, this
+// includes checking whether any static value references the outer
+// environment.
 func (e Environment) IsStatic() bool {
+	for _, v := range e.vars {
+		if v.value == nil && !v.unset {
+			return false
+		}
+	}
+
+	if e.interpolate {
+		in := newInterpolator(e.vars, nil)
+		for _, v := range e.vars {
+			if !v.unset && in.dependsOnOuter(v.name, map[string]bool{}) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (e Environment) hasOnlyStaticValues() bool {
 	for _, v := range e.vars {
 		if v.value == nil {
 			return false
@@ -106,51 +522,193 @@ func (e Environment) IsStatic() bool {
 	return true
 }
 
+func (e Environment) hasSecrets() bool {
+	for _, v := range e.vars {
+		if v.secret {
+			return true
+		}
+	}
+	return false
+}
+
 // Resolve resolves the environment, using values from the given outer
 // environment to fill in environment values as needed. If an environment
-// variable doesn't exist in the outer environment, then an empty string will be
-// used as the value.
+// variable doesn't exist in the outer environment, then its default value will
+// be used, or an empty string if it has no default.
+//
+// Pattern variables, such as AWS_*, are expanded to every matching outer
+// variable, unless the variable is also declared explicitly.
 //
 // outer must be an array of strings in the form `KEY=VALUE`. Generally
 // speaking, this will be the return value from os.Environ().
+//
+// If the environment is resolved strictly, a *MissingVariablesError listing
+// every required variable that isn't set in the outer environment is returned.
+//
+// If interpolation is enabled, references within static values are expanded,
+// and an *UndefinedReferenceError is returned if they
+// cannot be.
+//
+// If the environment has a policy, a *PolicyError is returned if it would read
+// any blocked outer variables.
+//
+// The returned map includes the values of secret variables: use
+// ResolveEnvironment to get a resolved environment that can redact them.
+//
+// outer is converted into a MapSource: use ResolveFrom to look values up
+// elsewhere.
 func (e Environment) Resolve(outer []string) (map[string]string, error) {
-	// Convert the given outer environment into a map.
-	omap := make(map[string]string, len(outer))
-	for _, v := range outer {
-		kv := strings.SplitN(v, "=", 2)
-		if len(kv) != 2 {
-			return nil, errors.Errorf("unable to parse environment variable %q", v)
+	// Convert the given outer environment into a source.
+	source, err := environSource(outer)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := e.resolve(context.Background(), source)
+	if err != nil {
+		return nil, err
+	}
+	return r.values, nil
+}
+
+// ResolveFrom resolves the environment in the same way as Resolve, but looks
+// values up in the given sources rather than an array of strings. Each
+// variable is looked up in the sources in turn, and the first source in which
+// it is set wins: see ChainSource.
+//
+// Each variable is looked up at most once. Pattern variables are only
+// expanded using sources that implement NameLister.
+func (e Environment) ResolveFrom(ctx context.Context, sources ...Source) (map[string]string, error) {
+	r, err := e.resolve(ctx, ChainSource(sources...))
+	if err != nil {
+		return nil, err
+	}
+	return r.values, nil
+}
+
+func (e Environment) resolve(ctx context.Context, outer Source) (*ResolvedEnvironment, error) {
+	// We check the policy before reading anything, since reading a blocked
+	// outer variable could have side effects, such as audit logs.
+	if err := e.policy.Check(e); err != nil {
+		return nil, err
+	}
+
+	outer = newCachedSource(outer)
+
+	if e.strict {
+		var missing []string
+		for _, v := range e.vars {
+			if !v.required {
+				continue
+			}
+			if _, ok, err := outer.Lookup(ctx, v.name); err != nil {
+				return nil, err
+			} else if !ok {
+				missing = append(missing, v.name)
+			}
+		}
+		if len(missing) > 0 {
+			return nil, &MissingVariablesError{Names: missing}
 		}
-		omap[kv[0]] = kv[1]
 	}
 
-	// Now we can iterate over our own environment and fill in the missing
-	// values.
-	resolved := make(map[string]string, len(e.vars))
+	r := &ResolvedEnvironment{
+		values: make(map[string]string, len(e.vars)),
+		secret: make(map[string]bool),
+	}
+	add := func(name, value string, secret bool) {
+		if _, ok := r.values[name]; !ok {
+			r.names = append(r.names, name)
+			r.values[name] = value
+		}
+		if secret {
+			r.secret[name] = true
+		}
+	}
+
+	// Explicitly declared variables win over pattern matches, wherever they
+	// are declared.
+	explicit := make(map[string]bool, len(e.vars))
 	for _, v := range e.vars {
-		if v.value == nil {
-			// We don't bother checking if v.name exists in omap here because
-			// the default behaviour is what we want anyway: we'll get an empty
-			// string (since that's the zero value for a string), and that is
-			// the desired outcome if the environment variable isn't set.
-			resolved[v.name] = omap[v.name]
-		} else {
-			resolved[v.name] = *v.value
+		if v.pattern == nil && !v.unset {
+			explicit[v.name] = true
 		}
 	}
 
-	return resolved, nil
+	var in *interpolator
+	if e.interpolate {
+		in = newInterpolator(e.vars, outer)
+	}
+
+	var outerNames []string
+	listed := false
+	for _, v := range e.vars {
+		if v.unset {
+			continue
+		}
+
+		if v.pattern == nil {
+			var value string
+			var err error
+			if in != nil {
+				value, err = in.resolve(ctx, v.name)
+			} else {
+				value, err = v.resolve(ctx, outer)
+			}
+			if err != nil {
+				return nil, err
+			}
+			add(v.name, value, v.secret)
+			continue
+		}
+
+		// Now we can expand the pattern, which requires the names of all the
+		// outer variables.
+		if !listed {
+			names, err := listNames(ctx, outer)
+			if err != nil {
+				return nil, err
+			}
+			outerNames = append([]string{}, names...)
+			sort.Strings(outerNames)
+			listed = true
+		}
+		for _, name := range outerNames {
+			if explicit[name] || !v.pattern.Match(name) || !e.policy.Allows(name) {
+				continue
+			}
+			value, ok, err := outer.Lookup(ctx, name)
+			if err != nil {
+				return nil, err
+			} else if ok {
+				add(name, value, v.secret)
+			}
+		}
+	}
+
+	r.buildRedactor()
+	return r, nil
 }
 
-// Equal verifies if two environments are equal.
+// Equal verifies if two environments are equal, which is the case if they
+// declare the same variables. How they're resolved, which isn't marshalled,
+// isn't compared: see EqualResolution.
 func (e Environment) Equal(other Environment) bool {
 	return cmp.Equal(e.mapify(), other.mapify())
 }
 
-func (e Environment) mapify() map[string]*string {
-	m := make(map[string]*string, len(e.vars))
+// EqualResolution verifies if two environments are resolved in the same way:
+// with or without interpolation, strictly or not, and with equal policies.
+func (e Environment) EqualResolution(other Environment) bool {
+	return e.interpolate == other.interpolate &&
+		e.strict == other.strict &&
+		e.policy.Equal(other.policy)
+}
+
+func (e Environment) mapify() map[string]variable {
+	m := make(map[string]variable, len(e.vars))
 	for _, v := range e.vars {
-		m[v.name] = v.value
+		m[v.name] = v
 	}
 
 	return m
@@ -1,11 +1,15 @@
 package env
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"testing"
 
+	"github.com/gobwas/glob"
 	"github.com/google/go-cmp/cmp"
 	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
 )
 
 func TestEnvironment_MarshalJSON(t *testing.T) {
@@ -31,6 +35,20 @@ func TestEnvironment_MarshalJSON(t *testing.T) {
 			}},
 			want: `[{"foo":"bar"},"quux"]`,
 		},
+		"with default": {
+			in: Environment{vars: []variable{
+				{name: "foo", value: stringPtr("bar")},
+				{name: "quux", defaultValue: stringPtr("baz")},
+			}},
+			want: `[{"foo":"bar"},{"name":"quux","default":"baz"}]`,
+		},
+		"static variables in declaration order": {
+			in: Environment{vars: []variable{
+				{name: "quux", value: stringPtr("baz")},
+				{name: "foo", value: stringPtr("\"bar\"")},
+			}},
+			want: `{"quux":"baz","foo":"\"bar\""}`,
+		},
 	} {
 		t.Run(name, func(t *testing.T) {
 			have, err := json.Marshal(tc.in)
@@ -62,6 +80,13 @@ func TestEnvironment_UnmarshalJSON(t *testing.T) {
 					{name: "quux"},
 				}},
 			},
+			"array with default": {
+				in: `[{"foo":"bar"},{"name":"quux","default":"baz"}]`,
+				want: Environment{vars: []variable{
+					{name: "foo", value: stringPtr("bar")},
+					{name: "quux", defaultValue: stringPtr("baz")},
+				}},
+			},
 			"empty object": {
 				in:   `{}`,
 				want: Environment{},
@@ -90,9 +115,9 @@ func TestEnvironment_UnmarshalJSON(t *testing.T) {
 	t.Run("failure", func(t *testing.T) {
 		for name, in := range map[string]string{
 			"invalid outer type":             `false`,
-			"invalid object inner type":      `{"foo":false}`,
+			"invalid object inner type":      `{"foo":{}}`,
 			"invalid array inner type":       `[false]`,
-			"invalid array inner inner type": `[{"foo":false}]`,
+			"invalid array inner inner type": `[{"foo":[]}]`,
 		} {
 			t.Run(name, func(t *testing.T) {
 				var have Environment
@@ -121,6 +146,13 @@ func TestEnvironment_UnmarshalYAML(t *testing.T) {
 					{name: "quux"},
 				}},
 			},
+			"array with default": {
+				in: "- foo: bar\n- name: quux\n  default: baz",
+				want: Environment{vars: []variable{
+					{name: "foo", value: stringPtr("bar")},
+					{name: "quux", defaultValue: stringPtr("baz")},
+				}},
+			},
 			"empty object": {
 				in:   `{}`,
 				want: Environment{},
@@ -163,6 +195,284 @@ func TestEnvironment_UnmarshalYAML(t *testing.T) {
 	})
 }
 
+func TestEnvironment_Scalars(t *testing.T) {
+	unmarshallers := map[string]func([]byte, interface{}) error{
+		"JSON":    json.Unmarshal,
+		"yaml.v2": yaml.Unmarshal,
+		"yaml.v3": yamlv3.Unmarshal,
+	}
+
+	t.Run("success", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			in   string
+			want Environment
+		}{
+			"object": {
+				in: `{"PORT":8080,"VERSION":1.10,"DEBUG":true,"EMPTY":null,"NAME":"foo"}`,
+				want: Environment{vars: []variable{
+					{name: "PORT", value: stringPtr("8080")},
+					{name: "VERSION", value: stringPtr("1.10")},
+					{name: "DEBUG", value: stringPtr("true")},
+					{name: "EMPTY", value: stringPtr("")},
+					{name: "NAME", value: stringPtr("foo")},
+				}},
+			},
+			"array": {
+				in: `[{"PORT":8080},{"name":"DEBUG","default":false},{"EMPTY":null}]`,
+				want: Environment{vars: []variable{
+					{name: "PORT", value: stringPtr("8080")},
+					{name: "DEBUG", defaultValue: stringPtr("false")},
+					{name: "EMPTY", value: stringPtr("")},
+				}},
+			},
+		} {
+			for format, unmarshal := range unmarshallers {
+				t.Run(name+"/"+format, func(t *testing.T) {
+					var have Environment
+					if err := unmarshal([]byte(tc.in), &have); err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+					if diff := cmp.Diff(have, tc.want); diff != "" {
+						t.Errorf("unexpected environment:\n%s", diff)
+					}
+				})
+			}
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			in   string
+			want errNonScalarValue
+		}{
+			"object": {
+				in:   `{"A":"a","B":[1,2]}`,
+				want: errNonScalarValue{name: "B", key: "value", kind: "list"},
+			},
+			"array": {
+				in:   `["A",{"B":{"c":"d"}}]`,
+				want: errNonScalarValue{name: "B", key: "value", kind: "map"},
+			},
+			"array object": {
+				in:   `[{"name":"B","default":[]}]`,
+				want: errNonScalarValue{name: "B", key: "default", kind: "list"},
+			},
+		} {
+			for format, unmarshal := range unmarshallers {
+				t.Run(name+"/"+format, func(t *testing.T) {
+					var have Environment
+					err := unmarshal([]byte(tc.in), &have)
+					if err == nil {
+						t.Fatal("unexpected nil error")
+					}
+
+					var e errNonScalarValue
+					if !errors.As(err, &e) {
+						t.Errorf("unexpected error of type %T: %v", err, err)
+					} else if e != tc.want {
+						t.Errorf("unexpected error: have=%+v want=%+v", e, tc.want)
+					}
+				})
+			}
+		}
+	})
+}
+
+func TestEnvironment_Order(t *testing.T) {
+	names := []string{"Z", "B", "Q", "A", "X", "C", "W", "D", "V", "E", "U", "F"}
+	want := make([]variable, len(names))
+	for i, name := range names {
+		want[i] = variable{name: name, value: stringPtr(name + "-value")}
+	}
+
+	jsonInput := `{"Z":"Z-value","B":"B-value","Q":"Q-value","A":"A-value","X":"X-value","C":"C-value","W":"W-value","D":"D-value","V":"V-value","E":"E-value","U":"U-value","F":"F-value"}`
+	yamlInput := ""
+	for _, name := range names {
+		yamlInput += name + ": " + name + "-value\n"
+	}
+
+	for name, unmarshal := range map[string]func(*Environment) error{
+		"JSON":    func(e *Environment) error { return json.Unmarshal([]byte(jsonInput), e) },
+		"yaml.v2": func(e *Environment) error { return yaml.Unmarshal([]byte(yamlInput), e) },
+		"yaml.v3": func(e *Environment) error { return yamlv3.Unmarshal([]byte(yamlInput), e) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			// The order of map iteration is random, so we'll try a few times to
+			// make sure it isn't leaking into the environment.
+			for i := 0; i < 10; i++ {
+				var have Environment
+				if err := unmarshal(&have); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				if diff := cmp.Diff(have.vars, want); diff != "" {
+					t.Fatalf("unexpected variables:\n%s", diff)
+				}
+				if diff := cmp.Diff(have.Names(), names); diff != "" {
+					t.Fatalf("unexpected names:\n%s", diff)
+				}
+
+				data, err := json.Marshal(have)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if string(data) != jsonInput {
+					t.Fatalf("unexpected JSON: have=%q want=%q", string(data), jsonInput)
+				}
+			}
+		})
+	}
+
+	t.Run("duplicate JSON keys", func(t *testing.T) {
+		var have Environment
+		err := json.Unmarshal([]byte(`{"b":"1","a":"2","b":"3"}`), &have)
+		if e, ok := err.(errDuplicateVariable); !ok {
+			t.Errorf("unexpected error of type %T: %v", err, err)
+		} else if diff := cmp.Diff(e.indexes, []int{0, 2}); diff != "" {
+			t.Errorf("unexpected indexes:\n%s", diff)
+		}
+	})
+}
+
+func TestEnvironment_ResolveStrict(t *testing.T) {
+	env := Environment{vars: []variable{
+		{name: "TOKEN", required: true},
+		{name: "OPTIONAL"},
+		{name: "STATIC", value: stringPtr("static")},
+		{name: "HOST", required: true},
+		{name: "EMPTY", required: true},
+	}}
+	outer := []string{"EMPTY="}
+
+	t.Run("not strict", func(t *testing.T) {
+		have, err := env.Resolve(outer)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := map[string]string{"TOKEN": "", "OPTIONAL": "", "STATIC": "static", "HOST": "", "EMPTY": ""}
+		if diff := cmp.Diff(have, want); diff != "" {
+			t.Errorf("unexpected resolved environment:\n%s", diff)
+		}
+	})
+
+	t.Run("strict with missing variables", func(t *testing.T) {
+		_, err := env.WithStrictResolution().Resolve(outer)
+
+		var e *MissingVariablesError
+		if !errors.As(err, &e) {
+			t.Fatalf("unexpected error of type %T: %v", err, err)
+		}
+		if diff := cmp.Diff(e.Names, []string{"TOKEN", "HOST"}); diff != "" {
+			t.Errorf("unexpected missing variables:\n%s", diff)
+		}
+		if have, want := e.Error(), "required environment variables TOKEN, HOST are not set"; have != want {
+			t.Errorf("unexpected error message: have=%q want=%q", have, want)
+		}
+	})
+
+	t.Run("strict with a missing variable", func(t *testing.T) {
+		_, err := env.WithStrictResolution().Resolve([]string{"TOKEN=x", "EMPTY="})
+
+		var e *MissingVariablesError
+		if !errors.As(err, &e) {
+			t.Fatalf("unexpected error of type %T: %v", err, err)
+		}
+		if have, want := e.Error(), "required environment variable HOST is not set"; have != want {
+			t.Errorf("unexpected error message: have=%q want=%q", have, want)
+		}
+	})
+
+	t.Run("strict success", func(t *testing.T) {
+		have, err := env.WithStrictResolution().Resolve([]string{"TOKEN=secret", "HOST=example.com", "EMPTY="})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := map[string]string{"TOKEN": "secret", "OPTIONAL": "", "STATIC": "static", "HOST": "example.com", "EMPTY": ""}
+		if diff := cmp.Diff(have, want); diff != "" {
+			t.Errorf("unexpected resolved environment:\n%s", diff)
+		}
+	})
+}
+
+func TestEnvironment_RoundTrip(t *testing.T) {
+	in := Environment{vars: []variable{
+		{name: "foo", value: stringPtr("bar")},
+		{name: "quux"},
+		{name: "baz", defaultValue: stringPtr("fallback")},
+		{name: "token", required: true},
+		{name: "password", value: stringPtr("hunter2"), secret: true},
+		{name: "key", secret: true},
+	}}
+
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var have Environment
+	if err := json.Unmarshal(data, &have); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !have.Equal(in) {
+		t.Errorf("environment did not round trip: have=%+v want=%+v", have, in)
+	}
+
+	other := Environment{vars: []variable{
+		{name: "foo", value: stringPtr("bar")},
+		{name: "quux"},
+		{name: "baz", defaultValue: stringPtr("other")},
+	}}
+	if have.Equal(other) {
+		t.Error("environments with different defaults are equal")
+	}
+
+	t.Run("static secrets", func(t *testing.T) {
+		in := Environment{vars: []variable{
+			{name: "foo", value: stringPtr("bar")},
+			{name: "password", value: stringPtr("hunter2"), secret: true},
+		}}
+
+		data, err := json.Marshal(in)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if want := `[{"foo":"bar"},{"name":"password","value":"hunter2","secret":true}]`; string(data) != want {
+			t.Errorf("unexpected JSON: have=%s want=%s", data, want)
+		}
+
+		var have Environment
+		if err := json.Unmarshal(data, &have); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !have.Equal(in) {
+			t.Errorf("environment did not round trip: have=%+v want=%+v", have, in)
+		}
+	})
+
+	t.Run("resolution", func(t *testing.T) {
+		in := New().MustWithStatic("A", "${HOME}").WithInterpolation().WithStrictResolution()
+
+		data, err := json.Marshal(in)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var have Environment
+		if err := json.Unmarshal(data, &have); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !have.Equal(in) {
+			t.Errorf("environment did not round trip: have=%+v want=%+v", have, in)
+		}
+		if have.EqualResolution(in) {
+			t.Error("environments with different resolution are equal")
+		}
+		if !have.WithInterpolation().WithStrictResolution().EqualResolution(in) {
+			t.Error("environments with the same resolution are not equal")
+		}
+	})
+}
+
 func TestEnvironment_IsStatic(t *testing.T) {
 	for name, tc := range map[string]struct {
 		env  Environment
@@ -186,6 +496,13 @@ func TestEnvironment_IsStatic(t *testing.T) {
 			}},
 			want: false,
 		},
+		"default": {
+			env: Environment{vars: []variable{
+				{name: "foo", value: stringPtr("bar")},
+				{name: "quux", defaultValue: stringPtr("baz")},
+			}},
+			want: false,
+		},
 	} {
 		t.Run(name, func(t *testing.T) {
 			if have := tc.env.IsStatic(); have != tc.want {
@@ -201,6 +518,23 @@ func TestEnvironment_Resolve(t *testing.T) {
 		{name: "foo", value: stringPtr("bar")},
 	}}
 
+	t.Run("defaults", func(t *testing.T) {
+		env := Environment{vars: []variable{
+			{name: "default", defaultValue: stringPtr("fallback")},
+			{name: "empty", defaultValue: stringPtr("fallback")},
+			{name: "unset", defaultValue: stringPtr("fallback")},
+		}}
+
+		have, err := env.Resolve([]string{"default=outer", "empty="})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := map[string]string{"default": "outer", "empty": "", "unset": "fallback"}
+		if diff := cmp.Diff(have, want); diff != "" {
+			t.Errorf("unexpected resolved environment:\n%s", diff)
+		}
+	})
+
 	t.Run("invalid outer", func(t *testing.T) {
 		if _, err := env.Resolve([]string{"foo"}); err == nil {
 			t.Error("unexpected nil error")
@@ -255,3 +589,481 @@ func TestEnvironment_Resolve(t *testing.T) {
 		}
 	})
 }
+
+func TestEnvironment_ResolvePatterns(t *testing.T) {
+	var env Environment
+	if err := yaml.Unmarshal([]byte(`
+- AWS_REGION: us-east-1
+- AWS_*
+- HOME
+- name: GOOGLE_*
+  secret: true
+- AWS_PROFILE
+- "*_TOKEN"
+`), &env); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	outer := []string{
+		"AWS_SECRET_ACCESS_KEY=secret",
+		"AWS_ACCESS_KEY_ID=id",
+		"AWS_REGION=eu-west-1",
+		"GOOGLE_APPLICATION_CREDENTIALS=/creds.json",
+		"GITHUB_TOKEN=gh",
+		"GOOGLE_TOKEN=google",
+		"HOME=/home/me",
+		"PATH=/bin",
+	}
+
+	t.Run("Resolve", func(t *testing.T) {
+		have, err := env.Resolve(outer)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		want := map[string]string{
+			"AWS_REGION":                     "us-east-1",
+			"AWS_ACCESS_KEY_ID":              "id",
+			"AWS_SECRET_ACCESS_KEY":          "secret",
+			"HOME":                           "/home/me",
+			"GOOGLE_APPLICATION_CREDENTIALS": "/creds.json",
+			"GOOGLE_TOKEN":                   "google",
+			"AWS_PROFILE":                    "",
+			"GITHUB_TOKEN":                   "gh",
+		}
+		if diff := cmp.Diff(have, want); diff != "" {
+			t.Errorf("unexpected resolved environment:\n%s", diff)
+		}
+	})
+
+	t.Run("ResolveEnvironment", func(t *testing.T) {
+		r, err := env.ResolveEnvironment(outer)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		wantNames := []string{
+			"AWS_REGION",
+			"AWS_ACCESS_KEY_ID",
+			"AWS_SECRET_ACCESS_KEY",
+			"HOME",
+			"GOOGLE_APPLICATION_CREDENTIALS",
+			"GOOGLE_TOKEN",
+			"AWS_PROFILE",
+			"GITHUB_TOKEN",
+		}
+		if diff := cmp.Diff(r.Names(), wantNames); diff != "" {
+			t.Errorf("unexpected names:\n%s", diff)
+		}
+
+		for name, want := range map[string]bool{
+			"AWS_ACCESS_KEY_ID":              false,
+			"GOOGLE_APPLICATION_CREDENTIALS": true,
+			"GOOGLE_TOKEN":                   true,
+			"GITHUB_TOKEN":                   false,
+		} {
+			if have := r.IsSecret(name); have != want {
+				t.Errorf("unexpected secret status for %s: have=%v want=%v", name, have, want)
+			}
+		}
+	})
+
+	t.Run("no matches", func(t *testing.T) {
+		have, err := env.Resolve(nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		want := map[string]string{"AWS_REGION": "us-east-1", "HOME": "", "AWS_PROFILE": ""}
+		if diff := cmp.Diff(have, want); diff != "" {
+			t.Errorf("unexpected resolved environment:\n%s", diff)
+		}
+	})
+
+	t.Run("interpolation", func(t *testing.T) {
+		env := Environment{vars: []variable{
+			{name: "AWS_*", pattern: glob.MustCompile("AWS_*")},
+			{name: "URL", value: stringPtr("s3://${AWS_BUCKET}")},
+		}}.WithInterpolation()
+
+		have, err := env.Resolve([]string{"AWS_BUCKET=bucket"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		want := map[string]string{"AWS_BUCKET": "bucket", "URL": "s3://bucket"}
+		if diff := cmp.Diff(have, want); diff != "" {
+			t.Errorf("unexpected resolved environment:\n%s", diff)
+		}
+	})
+
+	t.Run("sources without names", func(t *testing.T) {
+		have, err := env.ResolveFrom(context.Background(), SourceFunc(func(ctx context.Context, name string) (string, bool, error) {
+			return "value", true, nil
+		}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		want := map[string]string{"AWS_REGION": "us-east-1", "HOME": "value", "AWS_PROFILE": "value"}
+		if diff := cmp.Diff(have, want); diff != "" {
+			t.Errorf("unexpected resolved environment:\n%s", diff)
+		}
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		data, err := json.Marshal(env)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := `[{"AWS_REGION":"us-east-1"},"AWS_*","HOME",{"name":"GOOGLE_*","secret":true},"AWS_PROFILE","*_TOKEN"]`
+		if string(data) != want {
+			t.Errorf("unexpected JSON: have=%s want=%s", data, want)
+		}
+
+		var have Environment
+		if err := json.Unmarshal(data, &have); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !have.Equal(env) {
+			t.Errorf("environment did not round trip: have=%+v want=%+v", have, env)
+		}
+		if have.IsStatic() {
+			t.Error("unexpected static environment")
+		}
+	})
+}
+
+func TestEnvironment_Builder(t *testing.T) {
+	env := New().
+		MustWithStatic("FOO", "bar").
+		MustWithPassthrough("HOME").
+		MustWithPassthrough("AWS_*")
+
+	t.Run("equal to unmarshalled", func(t *testing.T) {
+		var want Environment
+		if err := json.Unmarshal([]byte(`[{"FOO":"bar"},"HOME","AWS_*"]`), &want); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !env.Equal(want) {
+			t.Errorf("unexpected environment: have=%+v want=%+v", env, want)
+		}
+	})
+
+	t.Run("static", func(t *testing.T) {
+		data, err := json.Marshal(New().MustWithStatic("FOO", "bar").MustWithStatic("BAZ", "quux"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if want := `{"FOO":"bar","BAZ":"quux"}`; string(data) != want {
+			t.Errorf("unexpected JSON: have=%s want=%s", data, want)
+		}
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		have := env.MustWithStatic("HOME", "/home/me").MustWithPassthrough("FOO")
+		if diff := cmp.Diff(have.Names(), []string{"FOO", "HOME", "AWS_*"}); diff != "" {
+			t.Errorf("unexpected names:\n%s", diff)
+		}
+		if v, _ := have.Lookup("HOME"); !v.IsStatic() {
+			t.Error("HOME was not replaced")
+		}
+		if v, _ := have.Lookup("FOO"); v.IsStatic() {
+			t.Error("FOO was not replaced")
+		}
+	})
+
+	t.Run("copies", func(t *testing.T) {
+		base := New().MustWithStatic("A", "a").MustWithStatic("B", "b")
+		a := base.MustWithStatic("C", "c")
+		b := base.MustWithStatic("D", "d").MustWithStatic("A", "z")
+
+		if diff := cmp.Diff(base.Names(), []string{"A", "B"}); diff != "" {
+			t.Errorf("unexpected names:\n%s", diff)
+		}
+		if diff := cmp.Diff(a.Names(), []string{"A", "B", "C"}); diff != "" {
+			t.Errorf("unexpected names:\n%s", diff)
+		}
+		if v, _ := base.Lookup("A"); valueOf(v) != "a" {
+			t.Errorf("base environment was modified: %+v", base)
+		}
+		if v, _ := b.Lookup("A"); valueOf(v) != "z" {
+			t.Errorf("unexpected value: %+v", b)
+		}
+	})
+
+	t.Run("relaxed names", func(t *testing.T) {
+		have, err := New().WithRelaxedNames().WithStatic("A-B", "a")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, ok := have.Lookup("A-B"); !ok {
+			t.Error("A-B was not added")
+		}
+
+		if _, err := New().WithRelaxedNames().WithPassthrough("A=B"); err == nil {
+			t.Error("unexpected nil error")
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		base := New().MustWithStatic("A", "a")
+		for name, tc := range map[string]struct {
+			build func() (Environment, error)
+			must  func()
+		}{
+			"static pattern": {
+				build: func() (Environment, error) { return base.WithStatic("AWS_*", "foo") },
+				must:  func() { base.MustWithStatic("AWS_*", "foo") },
+			},
+			"invalid passthrough": {
+				build: func() (Environment, error) { return base.WithPassthrough("AWS_[") },
+				must:  func() { base.MustWithPassthrough("AWS_[") },
+			},
+			"invalid static name": {
+				build: func() (Environment, error) { return base.WithStatic("1BAD", "foo") },
+				must:  func() { base.MustWithStatic("1BAD", "foo") },
+			},
+			"invalid passthrough name": {
+				build: func() (Environment, error) { return base.WithPassthrough("A-B") },
+				must:  func() { base.MustWithPassthrough("A-B") },
+			},
+			"empty name": {
+				build: func() (Environment, error) { return base.WithStatic("", "foo") },
+				must:  func() { base.MustWithStatic("", "foo") },
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				have, err := tc.build()
+				if err == nil {
+					t.Error("unexpected nil error")
+				}
+				if !have.Equal(base) {
+					t.Errorf("environment was modified: %+v", have)
+				}
+
+				defer func() {
+					if recover() == nil {
+						t.Error("unexpected nil panic")
+					}
+				}()
+				tc.must()
+			})
+		}
+	})
+}
+
+func valueOf(v Variable) string {
+	value, _ := v.Value()
+	return value
+}
+
+func TestEnvironment_Lookup(t *testing.T) {
+	var env Environment
+	if err := json.Unmarshal([]byte(`[
+		{"FOO":"bar"},
+		"HOME",
+		{"name":"TOKEN","secret":true,"required":true},
+		{"name":"REGION","default":"us-east-1"},
+		"AWS_*",
+		{"name":"GONE","unset":true}
+	]`), &env); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	type attributes struct {
+		Static, Required, Secret, Pattern bool
+		Value, Default                    *string
+	}
+	attributesOf := func(v Variable) attributes {
+		a := attributes{
+			Static:   v.IsStatic(),
+			Required: v.IsRequired(),
+			Secret:   v.IsSecret(),
+			Pattern:  v.IsPattern(),
+		}
+		if value, ok := v.Value(); ok {
+			a.Value = &value
+		}
+		if value, ok := v.Default(); ok {
+			a.Default = &value
+		}
+		return a
+	}
+
+	for name, want := range map[string]attributes{
+		"FOO":    {Static: true, Value: stringPtr("bar")},
+		"HOME":   {},
+		"TOKEN":  {Required: true, Secret: true},
+		"REGION": {Default: stringPtr("us-east-1")},
+		"AWS_*":  {Pattern: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			v, ok := env.Lookup(name)
+			if !ok {
+				t.Fatal("variable not found")
+			}
+			if v.Name() != name {
+				t.Errorf("unexpected name: have=%q want=%q", v.Name(), name)
+			}
+			if diff := cmp.Diff(attributesOf(v), want); diff != "" {
+				t.Errorf("unexpected attributes:\n%s", diff)
+			}
+		})
+	}
+
+	for _, name := range []string{"MISSING", "GONE", "AWS_REGION"} {
+		if _, ok := env.Lookup(name); ok {
+			t.Errorf("unexpected variable %s", name)
+		}
+	}
+
+	if have := env.Len(); have != 5 {
+		t.Errorf("unexpected length: have=%d want=%d", have, 5)
+	}
+}
+
+func TestEnvironment_Range(t *testing.T) {
+	env := New().
+		MustWithStatic("B", "b").
+		MustWithPassthrough("A").
+		MustWithPassthrough("C")
+
+	var have []string
+	env.Range(func(v Variable) bool {
+		have = append(have, v.Name())
+		return true
+	})
+	if diff := cmp.Diff(have, []string{"B", "A", "C"}); diff != "" {
+		t.Errorf("unexpected order:\n%s", diff)
+	}
+
+	have = nil
+	env.Range(func(v Variable) bool {
+		have = append(have, v.Name())
+		return v.IsStatic()
+	})
+	if diff := cmp.Diff(have, []string{"B", "A"}); diff != "" {
+		t.Errorf("unexpected variables after stopping:\n%s", diff)
+	}
+}
+
+func TestEnvironment_NameValidation(t *testing.T) {
+	unmarshallers := map[string]func([]byte, interface{}) error{
+		"JSON":    json.Unmarshal,
+		"yaml.v2": yaml.Unmarshal,
+		"yaml.v3": yamlv3.Unmarshal,
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			in      string
+			relaxed bool
+		}{
+			"POSIX names":           {in: `["HOME","_private","lower_case","A1"]`},
+			"POSIX object":          {in: `{"HOME":"a","_X2":"b"}`},
+			"patterns":              {in: `["AWS_*","GOOGLE_{APPLICATION,CLOUD}_*","[A-Z]*"]`},
+			"relaxed names":         {in: `["my-var","ProgramFiles(x86)","1ST","é"]`, relaxed: true},
+			"relaxed object":        {in: `{"my.var":"a","with space":"b"}`, relaxed: true},
+			"unset marker":          {in: `["A",{"name":"B","unset":true}]`},
+			"object with variables": {in: `[{"A":"a"},{"name":"B","default":"b"}]`},
+		} {
+			for format, unmarshal := range unmarshallers {
+				t.Run(name+"/"+format, func(t *testing.T) {
+					var have Environment
+					if tc.relaxed {
+						have = New().WithRelaxedNames()
+					}
+					if err := unmarshal([]byte(tc.in), &have); err != nil {
+						t.Errorf("unexpected error: %v", err)
+					}
+				})
+			}
+		}
+	})
+
+	t.Run("invalid names", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			in      string
+			relaxed bool
+			want    errInvalidVariableName
+		}{
+			"empty":           {in: `["A",""]`, want: errInvalidVariableName{name: "", index: 1}},
+			"empty object":    {in: `{"A":"a","":"b"}`, want: errInvalidVariableName{name: "", index: 1}},
+			"equals":          {in: `["A=B"]`, want: errInvalidVariableName{name: "A=B", index: 0}},
+			"relaxed equals":  {in: `["A","A=B"]`, relaxed: true, want: errInvalidVariableName{name: "A=B", index: 1}},
+			"relaxed empty":   {in: `[{"name":"","default":"a"}]`, relaxed: true, want: errInvalidVariableName{name: "", index: 0}},
+			"hyphen":          {in: `["A","B","my-var"]`, want: errInvalidVariableName{name: "my-var", index: 2}},
+			"leading digit":   {in: `{"1ST":"a"}`, want: errInvalidVariableName{name: "1ST", index: 0}},
+			"space":           {in: `[{"A B":"a"}]`, want: errInvalidVariableName{name: "A B", index: 0}},
+			"non-ASCII":       {in: `["É"]`, want: errInvalidVariableName{name: "É", index: 0}},
+			"equals in value": {in: `[{"name":"A=","value":"a"}]`, want: errInvalidVariableName{name: "A=", index: 0}},
+		} {
+			for format, unmarshal := range unmarshallers {
+				t.Run(name+"/"+format, func(t *testing.T) {
+					var have Environment
+					if tc.relaxed {
+						have = New().WithRelaxedNames()
+					}
+					err := unmarshal([]byte(tc.in), &have)
+					if e, ok := err.(errInvalidVariableName); !ok {
+						t.Errorf("unexpected error of type %T: %v", err, err)
+					} else if e.name != tc.want.name || e.index != tc.want.index {
+						t.Errorf("unexpected error: have=%+v want=%+v", e, tc.want)
+					} else if e.reason == "" {
+						t.Error("unexpected empty reason")
+					}
+				})
+			}
+		}
+	})
+
+	t.Run("duplicates", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			in   string
+			want errDuplicateVariable
+		}{
+			"names":        {in: `["A","B","A"]`, want: errDuplicateVariable{name: "A", indexes: []int{0, 2}}},
+			"three times":  {in: `["B","A","C","A",{"A":"a"}]`, want: errDuplicateVariable{name: "A", indexes: []int{1, 3, 4}}},
+			"first":        {in: `["B","A","A","B"]`, want: errDuplicateVariable{name: "B", indexes: []int{0, 3}}},
+			"static":       {in: `[{"A":"a"},{"name":"A","value":"b"}]`, want: errDuplicateVariable{name: "A", indexes: []int{0, 1}}},
+			"pattern":      {in: `["AWS_*","AWS_*"]`, want: errDuplicateVariable{name: "AWS_*", indexes: []int{0, 1}}},
+			"unset marker": {in: `["A",{"name":"A","unset":true}]`, want: errDuplicateVariable{name: "A", indexes: []int{0, 1}}},
+			"object":       {in: `{"A":"a","B":"b","A":"c"}`, want: errDuplicateVariable{name: "A", indexes: []int{0, 2}}},
+		} {
+			for format, unmarshal := range unmarshallers {
+				t.Run(name+"/"+format, func(t *testing.T) {
+					var have Environment
+					err := unmarshal([]byte(tc.in), &have)
+					if e, ok := err.(errDuplicateVariable); !ok {
+						t.Errorf("unexpected error of type %T: %v", err, err)
+					} else if e.name != tc.want.name {
+						t.Errorf("unexpected name: have=%q want=%q", e.name, tc.want.name)
+					} else if diff := cmp.Diff(e.indexes, tc.want.indexes); diff != "" {
+						t.Errorf("unexpected indexes:\n%s", diff)
+					}
+				})
+			}
+		}
+	})
+
+	t.Run("messages", func(t *testing.T) {
+		for _, tc := range []struct {
+			err  error
+			want string
+		}{
+			{
+				err:  errInvalidVariableName{name: "1ST", index: 2, reason: "names must not start with a digit"},
+				want: `invalid environment variable name "1ST" at index 2: names must not start with a digit`,
+			},
+			{
+				err:  errDuplicateVariable{name: "A", indexes: []int{0, 3}},
+				want: `duplicate environment variable "A" at indexes 0, 3`,
+			},
+		} {
+			if have := tc.err.Error(); have != tc.want {
+				t.Errorf("unexpected message: have=%q want=%q", have, tc.want)
+			}
+		}
+	})
+}
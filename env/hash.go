@@ -0,0 +1,191 @@
+package env
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// SecretHashMode determines how the values of secret variables affect hashes.
+type SecretHashMode int
+
+const (
+	// ExcludeSecrets excludes secret values from hashes: only the names of
+	// secret variables affect them.
+	ExcludeSecrets SecretHashMode = iota
+	// HMACSecrets includes an HMAC of each secret value in hashes, using
+	// HashOptions.Key, so that changing a secret changes the hash without
+	// the hash leaking the secret.
+	HMACSecrets
+	// IncludeSecrets includes secret values in hashes as is.
+	IncludeSecrets
+)
+
+// HashOptions configures the hashing of environments.
+type HashOptions struct {
+	// Secrets determines how the values of secret variables are hashed. The
+	// default is to exclude them.
+	Secrets SecretHashMode
+	// Key is the key used to HMAC secret values, which must be set if Secrets
+	// is HMACSecrets.
+	Key []byte
+}
+
+// Hash returns a hash of the environment, which is the same for equal
+// environments regardless of the order their variables are declared in, and
+// which can be used as part of a cache key. Pass-through variables are hashed
+// by name, not by value: use ResolvedEnvironment.Hash to hash the values.
+func (e Environment) Hash(opts HashOptions) (string, error) {
+	if err := opts.validate(); err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	enc := envEncoder{opts: opts, w: h}
+
+	// Every variable is encoded separately, so that the encodings can be
+	// sorted to make the hash independent of the order of the variables.
+	encoded := make([]string, 0, len(e.vars))
+	for _, v := range e.vars {
+		encoded = append(encoded, enc.encodeVariable(v))
+	}
+	sort.Strings(encoded)
+
+	enc.writeBool(e.interpolate)
+	enc.writeBool(e.strict)
+	enc.writePolicy(e.policy)
+	enc.writeInt(len(encoded))
+	for _, s := range encoded {
+		enc.writeString(s)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Hash returns a hash of the resolved environment, which is the same for
+// environments with the same values regardless of the order their variables
+// were declared in, and which can be used as part of a cache key.
+func (r *ResolvedEnvironment) Hash(opts HashOptions) (string, error) {
+	if err := opts.validate(); err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	enc := envEncoder{opts: opts, w: h}
+
+	names := r.Names()
+	sort.Strings(names)
+
+	enc.writeInt(len(names))
+	for _, name := range names {
+		enc.writeString(name)
+		enc.writeBool(r.secret[name])
+		enc.writeValue(r.values[name], r.secret[name])
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (opts HashOptions) validate() error {
+	switch opts.Secrets {
+	case ExcludeSecrets, IncludeSecrets:
+		return nil
+	case HMACSecrets:
+		if len(opts.Key) == 0 {
+			return errors.New("a key is required to HMAC secret values")
+		}
+		return nil
+	default:
+		return errors.Errorf("unknown secret hash mode %d", opts.Secrets)
+	}
+}
+
+// envEncoder writes an unambiguous encoding of an environment: each field is
+// prefixed by its length.
+type envEncoder struct {
+	opts HashOptions
+	w    io.Writer
+}
+
+// encodeVariable returns the encoding of a single variable.
+func (enc envEncoder) encodeVariable(v variable) string {
+	var buf bytes.Buffer
+	ve := envEncoder{opts: enc.opts, w: &buf}
+	ve.writeString(v.name)
+	ve.writeBool(v.pattern != nil)
+	ve.writeBool(v.unset)
+	ve.writeBool(v.required)
+	ve.writeBool(v.secret)
+	ve.writeOptionalValue(v.value, v.secret)
+	ve.writeOptionalValue(v.defaultValue, v.secret)
+	return buf.String()
+}
+
+func (enc envEncoder) writePolicy(p *Policy) {
+	enc.writeBool(p != nil)
+	if p == nil {
+		return
+	}
+
+	// The order of the rules within each list doesn't affect the policy.
+	for _, rules := range [][]policyRule{p.allow, p.deny} {
+		patterns := make([]string, len(rules))
+		for i, r := range rules {
+			patterns[i] = r.pattern
+		}
+		sort.Strings(patterns)
+
+		enc.writeInt(len(patterns))
+		for _, pattern := range patterns {
+			enc.writeString(pattern)
+		}
+	}
+}
+
+func (enc envEncoder) writeOptionalValue(value *string, secret bool) {
+	enc.writeBool(value != nil)
+	if value != nil {
+		enc.writeValue(*value, secret)
+	}
+}
+
+// writeValue writes a value, handling secret values according to the options.
+func (enc envEncoder) writeValue(value string, secret bool) {
+	if !secret {
+		enc.writeString(value)
+		return
+	}
+
+	switch enc.opts.Secrets {
+	case ExcludeSecrets:
+		enc.writeString("")
+	case HMACSecrets:
+		mac := hmac.New(sha256.New, enc.opts.Key)
+		mac.Write([]byte(value))
+		enc.writeString(string(mac.Sum(nil)))
+	case IncludeSecrets:
+		enc.writeString(value)
+	}
+}
+
+func (enc envEncoder) writeString(s string) {
+	enc.writeInt(len(s))
+	enc.w.Write([]byte(s))
+}
+
+func (enc envEncoder) writeInt(n int) {
+	var buf [binary.MaxVarintLen64]byte
+	enc.w.Write(buf[:binary.PutUvarint(buf[:], uint64(n))])
+}
+
+func (enc envEncoder) writeBool(b bool) {
+	if b {
+		enc.w.Write([]byte{1})
+	} else {
+		enc.w.Write([]byte{0})
+	}
+}
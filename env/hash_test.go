@@ -0,0 +1,307 @@
+package env
+
+import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+
+	"github.com/gobwas/glob"
+	"gopkg.in/yaml.v2"
+)
+
+// randomEnvironment is an Environment that can be generated by testing/quick.
+type randomEnvironment struct{ Environment }
+
+func (randomEnvironment) Generate(r *rand.Rand, size int) reflect.Value {
+	names := []string{"A", "B", "C", "HOME", "PATH", "TOKEN", "AWS_*", "GOOGLE_?"}
+	values := []string{"", "a", "b", "${A}", "$$", "x y", "'quoted'", "line\nbreak"}
+	pick := func() *string {
+		v := values[r.Intn(len(values))]
+		return &v
+	}
+
+	var e Environment
+	for _, i := range r.Perm(len(names))[:r.Intn(len(names)+1)] {
+		v := variable{name: names[i]}
+		if isPattern(v.name) {
+			v.pattern = glob.MustCompile(v.name)
+			v.secret = r.Intn(2) == 0
+		} else {
+			switch r.Intn(5) {
+			case 0:
+				v.value = pick()
+			case 1:
+				v.defaultValue = pick()
+			case 2:
+				v.required = true
+			case 3:
+				v.unset = true
+			}
+			v.secret = !v.unset && r.Intn(3) == 0
+		}
+		e.vars = append(e.vars, v)
+	}
+
+	e.interpolate = r.Intn(2) == 0
+	e.strict = r.Intn(2) == 0
+	return reflect.ValueOf(randomEnvironment{e})
+}
+
+// shuffled returns a copy of the environment with its variables in a random
+// order.
+func shuffled(e Environment, r *rand.Rand) Environment {
+	vars := make([]variable, len(e.vars))
+	for i, j := range r.Perm(len(e.vars)) {
+		vars[i] = e.vars[j]
+	}
+	e.vars = vars
+	return e
+}
+
+// copy returns a deep copy of the variable.
+func (v variable) copy() variable {
+	cp := func(s *string) *string {
+		if s == nil {
+			return nil
+		}
+		c := *s
+		return &c
+	}
+
+	v.value = cp(v.value)
+	v.defaultValue = cp(v.defaultValue)
+	if v.pattern != nil {
+		v.pattern = glob.MustCompile(v.name)
+	}
+	return v
+}
+
+func mustHash(t *testing.T, hash func(HashOptions) (string, error), opts HashOptions) string {
+	t.Helper()
+
+	h, err := hash(opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return h
+}
+
+var hashOptions = map[string]HashOptions{
+	"exclude secrets": {},
+	"HMAC secrets":    {Secrets: HMACSecrets, Key: []byte("key")},
+	"include secrets": {Secrets: IncludeSecrets},
+}
+
+func TestEnvironment_Hash(t *testing.T) {
+	for name, opts := range hashOptions {
+		t.Run(name, func(t *testing.T) {
+			t.Run("order independent", func(t *testing.T) {
+				if err := quick.Check(func(re randomEnvironment, seed int64) bool {
+					shuffled := shuffled(re.Environment, rand.New(rand.NewSource(seed)))
+					return mustHash(t, re.Hash, opts) == mustHash(t, shuffled.Hash, opts)
+				}, nil); err != nil {
+					t.Error(err)
+				}
+			})
+
+			t.Run("equal environments", func(t *testing.T) {
+				if err := quick.Check(func(a randomEnvironment, seed int64) bool {
+					// We copy the environment through its variables, so that
+					// nothing is shared.
+					var b Environment
+					for _, v := range shuffled(a.Environment, rand.New(rand.NewSource(seed))).vars {
+						b.vars = append(b.vars, v.copy())
+					}
+					b.interpolate, b.strict = a.interpolate, a.strict
+
+					return a.Equal(b) && a.EqualResolution(b) && mustHash(t, a.Hash, opts) == mustHash(t, b.Hash, opts)
+				}, nil); err != nil {
+					t.Error(err)
+				}
+			})
+
+			t.Run("round trip", func(t *testing.T) {
+				if err := quick.Check(func(re randomEnvironment) bool {
+					data, err := json.Marshal(re.Environment)
+					if err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+
+					var fromJSON, fromYAML Environment
+					if err := json.Unmarshal(data, &fromJSON); err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+					if err := yaml.Unmarshal(data, &fromYAML); err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+					fromJSON.interpolate, fromYAML.interpolate = re.interpolate, re.interpolate
+					fromJSON.strict, fromYAML.strict = re.strict, re.strict
+
+					want := mustHash(t, re.Hash, opts)
+					return mustHash(t, fromJSON.Hash, opts) == want && mustHash(t, fromYAML.Hash, opts) == want
+				}, nil); err != nil {
+					t.Error(err)
+				}
+			})
+		})
+	}
+
+	t.Run("different environments", func(t *testing.T) {
+		// When secrets are included, every difference between environments
+		// should affect the hash.
+		opts := HashOptions{Secrets: IncludeSecrets}
+		if err := quick.Check(func(a, b randomEnvironment) bool {
+			return (a.Equal(b.Environment) && a.EqualResolution(b.Environment)) || mustHash(t, a.Hash, opts) != mustHash(t, b.Hash, opts)
+		}, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("differences", func(t *testing.T) {
+		base := Environment{vars: []variable{
+			{name: "A", value: stringPtr("a")},
+			{name: "B"},
+		}}
+		want := mustHash(t, base.Hash, HashOptions{})
+
+		for name, e := range map[string]Environment{
+			"value":          {vars: []variable{{name: "A", value: stringPtr("b")}, {name: "B"}}},
+			"name":           {vars: []variable{{name: "A", value: stringPtr("a")}, {name: "C"}}},
+			"default":        {vars: []variable{{name: "A", value: stringPtr("a")}, {name: "B", defaultValue: stringPtr("")}}},
+			"required":       {vars: []variable{{name: "A", value: stringPtr("a")}, {name: "B", required: true}}},
+			"secret":         {vars: []variable{{name: "A", value: stringPtr("a")}, {name: "B", secret: true}}},
+			"unset":          {vars: []variable{{name: "A", value: stringPtr("a")}, {name: "B", unset: true}}},
+			"missing":        {vars: []variable{{name: "A", value: stringPtr("a")}}},
+			"ambiguous":      {vars: []variable{{name: "A", value: stringPtr("aB")}}},
+			"interpolation":  base.WithInterpolation(),
+			"strict":         base.WithStrictResolution(),
+			"policy":         base.WithPolicy(newTestPolicy(t, nil, []string{"SRC_*"})),
+			"passed through": {vars: []variable{{name: "A"}, {name: "B"}}},
+		} {
+			if have := mustHash(t, e.Hash, HashOptions{}); have == want {
+				t.Errorf("%s: unexpected equal hash", name)
+			}
+		}
+	})
+
+	t.Run("policy order", func(t *testing.T) {
+		a := Environment{}.WithPolicy(newTestPolicy(t, []string{"A", "B"}, []string{"C", "D"}))
+		b := Environment{}.WithPolicy(newTestPolicy(t, []string{"B", "A"}, []string{"D", "C"}))
+		c := Environment{}.WithPolicy(newTestPolicy(t, []string{"C", "D"}, []string{"A", "B"}))
+
+		if mustHash(t, a.Hash, HashOptions{}) != mustHash(t, b.Hash, HashOptions{}) {
+			t.Error("equivalent policies hash differently")
+		}
+		if mustHash(t, a.Hash, HashOptions{}) == mustHash(t, c.Hash, HashOptions{}) {
+			t.Error("different policies hash the same")
+		}
+	})
+
+	t.Run("secrets", func(t *testing.T) {
+		secret := func(value string) Environment {
+			return Environment{vars: []variable{{name: "TOKEN", value: &value, secret: true}}}
+		}
+
+		for name, tc := range map[string]struct {
+			opts      HashOptions
+			different bool
+		}{
+			"exclude secrets": {opts: HashOptions{}, different: false},
+			"HMAC secrets":    {opts: HashOptions{Secrets: HMACSecrets, Key: []byte("key")}, different: true},
+			"include secrets": {opts: HashOptions{Secrets: IncludeSecrets}, different: true},
+		} {
+			t.Run(name, func(t *testing.T) {
+				a := mustHash(t, secret("a").Hash, tc.opts)
+				b := mustHash(t, secret("b").Hash, tc.opts)
+				if (a != b) != tc.different {
+					t.Errorf("unexpected hashes: %s and %s", a, b)
+				}
+			})
+		}
+
+		t.Run("HMAC key", func(t *testing.T) {
+			a := mustHash(t, secret("a").Hash, HashOptions{Secrets: HMACSecrets, Key: []byte("a")})
+			b := mustHash(t, secret("a").Hash, HashOptions{Secrets: HMACSecrets, Key: []byte("b")})
+			if a == b {
+				t.Error("unexpected equal hashes for different keys")
+			}
+		})
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		for name, opts := range map[string]HashOptions{
+			"HMAC without key": {Secrets: HMACSecrets},
+			"unknown mode":     {Secrets: SecretHashMode(42)},
+		} {
+			t.Run(name, func(t *testing.T) {
+				if _, err := (Environment{}).Hash(opts); err == nil {
+					t.Error("unexpected nil error")
+				}
+				if _, err := (&ResolvedEnvironment{}).Hash(opts); err == nil {
+					t.Error("unexpected nil error")
+				}
+			})
+		}
+	})
+}
+
+func TestResolvedEnvironment_Hash(t *testing.T) {
+	outer := []string{"HOME=/home/me", "PATH=/bin", "TOKEN=s3cr3t", "AWS_REGION=us-east-1", "GOOGLE_A=a"}
+
+	for name, opts := range hashOptions {
+		t.Run(name, func(t *testing.T) {
+			if err := quick.Check(func(re randomEnvironment, seed int64) bool {
+				// Interpolation and strict resolution can fail, so we don't
+				// test them here.
+				e := re.Environment
+				e.interpolate, e.strict = false, false
+				shuffled := shuffled(e, rand.New(rand.NewSource(seed)))
+
+				a, err := e.ResolveEnvironment(outer)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				b, err := shuffled.ResolveEnvironment(outer)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				return mustHash(t, a.Hash, opts) == mustHash(t, b.Hash, opts)
+			}, nil); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	t.Run("differences", func(t *testing.T) {
+		resolve := func(e Environment, outer ...string) *ResolvedEnvironment {
+			r, err := e.ResolveEnvironment(outer)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			return r
+		}
+		passthrough := Environment{vars: []variable{{name: "A"}, {name: "B"}}}
+
+		// Unlike the unresolved environment, the static and pass-through
+		// forms of the same values hash the same.
+		static := Environment{vars: []variable{{name: "A", value: stringPtr("a")}, {name: "B", value: stringPtr("")}}}
+		if mustHash(t, resolve(passthrough, "A=a").Hash, HashOptions{}) != mustHash(t, resolve(static).Hash, HashOptions{}) {
+			t.Error("environments with the same values hash differently")
+		}
+
+		if mustHash(t, resolve(passthrough, "A=a").Hash, HashOptions{}) == mustHash(t, resolve(passthrough, "A=b").Hash, HashOptions{}) {
+			t.Error("environments with different values hash the same")
+		}
+
+		secret := Environment{vars: []variable{{name: "A", secret: true}, {name: "B"}}}
+		if mustHash(t, resolve(secret, "A=a").Hash, HashOptions{}) != mustHash(t, resolve(secret, "A=b").Hash, HashOptions{}) {
+			t.Error("excluded secret values affect the hash")
+		}
+		if mustHash(t, resolve(secret, "A=a").Hash, HashOptions{}) == mustHash(t, resolve(passthrough, "A=a").Hash, HashOptions{}) {
+			t.Error("secret status doesn't affect the hash")
+		}
+	})
+}
@@ -0,0 +1,180 @@
+package env
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// UndefinedReferenceError is returned when resolving an environment with
+// interpolation enabled, and the value of a variable references a variable
+// that is neither in the environment nor the outer environment.
+type UndefinedReferenceError struct {
+	// Variable is the name of the variable whose value contains the
+	// reference.
+	Variable string
+	// Reference is the name of the undefined variable.
+	Reference string
+}
+
+func (e *UndefinedReferenceError) Error() string {
+	return fmt.Sprintf("environment variable %q references undefined variable %q", e.Variable, e.Reference)
+}
+
+type errInvalidReference struct{ value string }
+
+func (e errInvalidReference) Error() string {
+	return fmt.Sprintf("invalid environment variable reference in %q", e.value)
+}
+
+// expand replaces each ${NAME} reference within value with the result of
+// calling lookup with NAME. $$ is replaced with a literal $, and a $ that
+// isn't followed by $ or { is left as is.
+func expand(value string, lookup func(name string) (string, error)) (string, error) {
+	original := value
+
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(value, '$')
+		if i < 0 || i == len(value)-1 {
+			b.WriteString(value)
+			return b.String(), nil
+		}
+		b.WriteString(value[:i])
+
+		switch value[i+1] {
+		case '$':
+			b.WriteByte('$')
+			value = value[i+2:]
+
+		case '{':
+			end := strings.IndexByte(value[i:], '}')
+			if end < 0 {
+				return "", errInvalidReference{value: original}
+			}
+			name := value[i+2 : i+end]
+			if name == "" {
+				return "", errInvalidReference{value: original}
+			}
+
+			replacement, err := lookup(name)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(replacement)
+			value = value[i+end+1:]
+
+		default:
+			b.WriteByte('$')
+			value = value[i+1:]
+		}
+	}
+}
+
+// references returns the names referenced within value. Invalid references
+// are ignored.
+func references(value string) []string {
+	var names []string
+	expand(value, func(name string) (string, error) {
+		names = append(names, name)
+		return "", nil
+	})
+	return names
+}
+
+// interpolator expands the references within the static values of an
+// environment. A value can only reference variables declared before it, so
+// references can't form cycles.
+type interpolator struct {
+	vars map[string]variable
+	// index contains the position of each variable in the environment.
+	index map[string]int
+	outer Source
+
+	resolved map[string]string
+}
+
+func newInterpolator(vars []variable, outer Source) *interpolator {
+	in := &interpolator{
+		vars:     make(map[string]variable, len(vars)),
+		index:    make(map[string]int, len(vars)),
+		outer:    outer,
+		resolved: make(map[string]string, len(vars)),
+	}
+	for i, v := range vars {
+		// Patterns can't be referenced, but their matches can be, since
+		// they're outer variables.
+		if v.pattern == nil && !v.unset {
+			in.vars[v.name] = v
+			in.index[v.name] = i
+		}
+	}
+	return in
+}
+
+// declaredBefore returns true if ref is a variable in the environment that is
+// declared before the given variable, and so can be referenced by it.
+func (in *interpolator) declaredBefore(ref, name string) bool {
+	i, ok := in.index[ref]
+	return ok && i < in.index[name]
+}
+
+// resolve returns the value of the given variable in the environment, with
+// all references expanded.
+func (in *interpolator) resolve(ctx context.Context, name string) (string, error) {
+	if value, ok := in.resolved[name]; ok {
+		return value, nil
+	}
+
+	v := in.vars[name]
+	if v.value == nil {
+		value, err := v.resolve(ctx, in.outer)
+		if err != nil {
+			return "", err
+		}
+		in.resolved[name] = value
+		return value, nil
+	}
+
+	value, err := expand(*v.value, func(ref string) (string, error) {
+		// A variable referencing itself or a later variable refers to the
+		// outer value, which allows values such as PATH: ${PATH}:/usr/local/bin.
+		if in.declaredBefore(ref, name) {
+			return in.resolve(ctx, ref)
+		}
+		if value, ok, err := in.outer.Lookup(ctx, ref); err != nil {
+			return "", err
+		} else if ok {
+			return value, nil
+		}
+		return "", &UndefinedReferenceError{Variable: name, Reference: ref}
+	})
+	if err != nil {
+		return "", err
+	}
+
+	in.resolved[name] = value
+	return value, nil
+}
+
+// dependsOnOuter returns true if the value of the given variable references,
+// directly or indirectly, the outer environment.
+func (in *interpolator) dependsOnOuter(name string, seen map[string]bool) bool {
+	v, ok := in.vars[name]
+	if !ok || v.value == nil {
+		return true
+	}
+	if seen[name] {
+		// We've already found that this variable doesn't, since we'd have
+		// stopped otherwise.
+		return false
+	}
+	seen[name] = true
+
+	for _, ref := range references(*v.value) {
+		if !in.declaredBefore(ref, name) || in.dependsOnOuter(ref, seen) {
+			return true
+		}
+	}
+	return false
+}
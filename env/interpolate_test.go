@@ -0,0 +1,260 @@
+package env
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExpand(t *testing.T) {
+	lookup := func(name string) (string, error) {
+		if name == "fail" {
+			return "", errors.New("lookup failed")
+		}
+		return "<" + name + ">", nil
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		for in, want := range map[string]string{
+			"":                    "",
+			"foo":                 "foo",
+			"${A}":                "<A>",
+			"${A}${B}":            "<A><B>",
+			"https://${H}:${P}/x": "https://<H>:<P>/x",
+			"$$":                  "$",
+			"$${A}":               "${A}",
+			"$$$${A}":             "$${A}",
+			"$$$$${A}":            "$$<A>",
+			"$A":                  "$A",
+			"$":                   "$",
+			"a$":                  "a$",
+			"${A}$":               "<A>$",
+			"${A B}":              "<A B>",
+		} {
+			t.Run(in, func(t *testing.T) {
+				have, err := expand(in, lookup)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if have != want {
+					t.Errorf("unexpected value: have=%q want=%q", have, want)
+				}
+			})
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, in := range []string{"${", "${A", "x${}y", "${A}${"} {
+			t.Run(in, func(t *testing.T) {
+				_, err := expand(in, lookup)
+				if e, ok := err.(errInvalidReference); !ok {
+					t.Errorf("unexpected error of type %T: %v", err, err)
+				} else if e.value != in {
+					t.Errorf("unexpected value in error: have=%q want=%q", e.value, in)
+				}
+			})
+		}
+	})
+
+	t.Run("lookup error", func(t *testing.T) {
+		if _, err := expand("a${fail}", lookup); err == nil {
+			t.Error("unexpected nil error")
+		}
+	})
+}
+
+func TestEnvironment_ResolveInterpolation(t *testing.T) {
+	outer := []string{"HOME=/home/me", "HOST=example.com", "PATH=/bin"}
+
+	t.Run("valid", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			vars []variable
+			want map[string]string
+		}{
+			"outer": {
+				vars: []variable{{name: "PREFIX", value: stringPtr("${HOME}/tools")}},
+				want: map[string]string{"PREFIX": "/home/me/tools"},
+			},
+			"earlier variables": {
+				vars: []variable{
+					{name: "PORT", value: stringPtr("8080")},
+					{name: "URL", value: stringPtr("https://${HOST}:${PORT}")},
+				},
+				want: map[string]string{"PORT": "8080", "URL": "https://example.com:8080"},
+			},
+			"later variables don't shadow outer": {
+				vars: []variable{
+					{name: "PREFIX", value: stringPtr("${HOME}/tools")},
+					{name: "HOME", value: stringPtr("/root")},
+				},
+				want: map[string]string{"HOME": "/root", "PREFIX": "/home/me/tools"},
+			},
+			"variables shadow outer": {
+				vars: []variable{
+					{name: "HOME", value: stringPtr("/root")},
+					{name: "PREFIX", value: stringPtr("${HOME}/tools")},
+				},
+				want: map[string]string{"HOME": "/root", "PREFIX": "/root/tools"},
+			},
+			"transitive": {
+				vars: []variable{
+					{name: "C", value: stringPtr("${HOME}")},
+					{name: "B", value: stringPtr("${C}/b")},
+					{name: "A", value: stringPtr("${B}/a")},
+				},
+				want: map[string]string{"A": "/home/me/b/a", "B": "/home/me/b", "C": "/home/me"},
+			},
+			"self reference": {
+				vars: []variable{{name: "PATH", value: stringPtr("${PATH}:/usr/local/bin")}},
+				want: map[string]string{"PATH": "/bin:/usr/local/bin"},
+			},
+			"pass-through": {
+				vars: []variable{
+					{name: "HOST"},
+					{name: "MISSING"},
+					{name: "URL", value: stringPtr("${HOST}${MISSING}")},
+				},
+				want: map[string]string{"HOST": "example.com", "MISSING": "", "URL": "example.com"},
+			},
+			"escaped": {
+				vars: []variable{{name: "A", value: stringPtr("$${HOME} costs $$5")}},
+				want: map[string]string{"A": "${HOME} costs $5"},
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				env := Environment{vars: tc.vars}.WithInterpolation()
+				have, err := env.Resolve(outer)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if diff := cmp.Diff(have, tc.want); diff != "" {
+					t.Errorf("unexpected resolved environment:\n%s", diff)
+				}
+			})
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		env := Environment{vars: []variable{{name: "A", value: stringPtr("${HOME}")}}}
+		have, err := env.Resolve(outer)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if diff := cmp.Diff(have, map[string]string{"A": "${HOME}"}); diff != "" {
+			t.Errorf("unexpected resolved environment:\n%s", diff)
+		}
+	})
+
+	t.Run("forward references", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			vars []variable
+			want UndefinedReferenceError
+		}{
+			"later variable": {
+				vars: []variable{
+					{name: "URL", value: stringPtr("https://${HOST}:${PORT}")},
+					{name: "PORT", value: stringPtr("8080")},
+				},
+				want: UndefinedReferenceError{Variable: "URL", Reference: "PORT"},
+			},
+			"mutual references": {
+				vars: []variable{
+					{name: "A", value: stringPtr("${B}")},
+					{name: "B", value: stringPtr("${A}")},
+				},
+				want: UndefinedReferenceError{Variable: "A", Reference: "B"},
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				env := Environment{vars: tc.vars}.WithInterpolation()
+				_, err := env.Resolve(outer)
+
+				var e *UndefinedReferenceError
+				if !errors.As(err, &e) {
+					t.Fatalf("unexpected error of type %T: %v", err, err)
+				}
+				if diff := cmp.Diff(*e, tc.want); diff != "" {
+					t.Errorf("unexpected error:\n%s", diff)
+				}
+			})
+		}
+	})
+
+	t.Run("undefined", func(t *testing.T) {
+		env := Environment{vars: []variable{
+			{name: "B", value: stringPtr("${NOPE}")},
+			{name: "A", value: stringPtr("${B}")},
+		}}.WithInterpolation()
+		_, err := env.Resolve(outer)
+
+		var e *UndefinedReferenceError
+		if !errors.As(err, &e) {
+			t.Fatalf("unexpected error of type %T: %v", err, err)
+		}
+		if e.Variable != "B" || e.Reference != "NOPE" {
+			t.Errorf("unexpected error: %+v", e)
+		}
+		if e.Error() != `environment variable "B" references undefined variable "NOPE"` {
+			t.Errorf("unexpected error message: %q", e.Error())
+		}
+	})
+
+	t.Run("invalid reference", func(t *testing.T) {
+		env := Environment{vars: []variable{{name: "A", value: stringPtr("${B")}}}.WithInterpolation()
+		if _, err := env.Resolve(outer); err == nil {
+			t.Error("unexpected nil error")
+		}
+	})
+}
+
+func TestEnvironment_IsStaticInterpolation(t *testing.T) {
+	for name, tc := range map[string]struct {
+		vars []variable
+		want bool
+	}{
+		"no references": {
+			vars: []variable{{name: "A", value: stringPtr("a")}},
+			want: true,
+		},
+		"internal references": {
+			vars: []variable{
+				{name: "B", value: stringPtr("b$${C}")},
+				{name: "A", value: stringPtr("${B}")},
+			},
+			want: true,
+		},
+		"outer reference": {
+			vars: []variable{{name: "A", value: stringPtr("${HOME}")}},
+			want: false,
+		},
+		"indirect outer reference": {
+			vars: []variable{
+				{name: "B", value: stringPtr("${HOME}")},
+				{name: "A", value: stringPtr("${B}")},
+			},
+			want: false,
+		},
+		"self reference": {
+			vars: []variable{{name: "PATH", value: stringPtr("${PATH}:/bin")}},
+			want: false,
+		},
+		"forward reference": {
+			vars: []variable{
+				{name: "A", value: stringPtr("${B}")},
+				{name: "B", value: stringPtr("b")},
+			},
+			want: false,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			env := Environment{vars: tc.vars}
+			if !env.IsStatic() {
+				t.Error("environment without interpolation is not static")
+			}
+			if have := env.WithInterpolation().IsStatic(); have != tc.want {
+				t.Errorf("unexpected static value: have=%v want=%v", have, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package env
+
+import "strings"
+
+// Layer is an environment that can be merged with others by Merge, such as
+// the environment of a batch spec, a step, or a repository.
+type Layer struct {
+	// Name identifies the layer, and is returned by Environment.Origin for
+	// the variables declared in it.
+	Name        string
+	Environment Environment
+}
+
+// Merge merges the given layers into a single environment. Later layers take
+// precedence over earlier ones:
+//
+//   - A variable declared in a later layer replaces any variable of the same
+//     name in an earlier layer, including whether it's static or passed
+//     through from the outer environment, while keeping the position of the
+//     earlier variable. Pattern variables, such as AWS_*, are only replaced by
+//     the same pattern.
+//   - An unset marker, such as {name: FOO, unset: true}, removes FOO from the
+//     earlier layers. An unset marker for a pattern, such as AWS_*, removes
+//     the same pattern and every variable it matches. Unset markers are
+//     removed from the merged environment.
+//
+// The merged environment uses interpolation if any layer does, in which case
+// static values from layers without interpolation are escaped so that they
+// keep their meaning. It is resolved strictly if any layer is, and uses the
+// policy of the last layer that has one. It accepts relaxed names if any layer
+// does, since it may contain variables with such names.
+func Merge(layers ...Layer) Environment {
+	var merged Environment
+	for _, l := range layers {
+		merged.interpolate = merged.interpolate || l.Environment.interpolate
+		merged.strict = merged.strict || l.Environment.strict
+		merged.relaxedNames = merged.relaxedNames || l.Environment.relaxedNames
+		if l.Environment.policy != nil {
+			merged.policy = l.Environment.policy
+		}
+	}
+
+	for _, l := range layers {
+		for _, v := range l.Environment.vars {
+			v.layer = l.Name
+			if merged.interpolate && !l.Environment.interpolate && v.value != nil {
+				escaped := strings.ReplaceAll(*v.value, "$", "$$")
+				v.value = &escaped
+			}
+
+			if v.unset {
+				merged.vars = unsetVariables(merged.vars, v)
+			} else {
+				merged.vars = setVariable(merged.vars, v)
+			}
+		}
+	}
+
+	return merged
+}
+
+// setVariable replaces the variable with the same name as v, or appends v.
+func setVariable(vars []variable, v variable) []variable {
+	for i := range vars {
+		if vars[i].name == v.name {
+			vars[i] = v
+			return vars
+		}
+	}
+	return append(vars, v)
+}
+
+// unsetVariables removes the variables matching the given unset marker.
+func unsetVariables(vars []variable, marker variable) []variable {
+	kept := vars[:0]
+	for _, v := range vars {
+		if v.name == marker.name || (marker.pattern != nil && v.pattern == nil && marker.pattern.Match(v.name)) {
+			continue
+		}
+		kept = append(kept, v)
+	}
+	return kept
+}
+
+// Origin returns the name of the layer that the given variable was declared
+// in, if the environment was created by Merge. ok is false if the variable
+// isn't declared in the environment.
+func (e Environment) Origin(name string) (layer string, ok bool) {
+	for _, v := range e.vars {
+		if v.name == name && !v.unset {
+			return v.layer, true
+		}
+	}
+	return "", false
+}
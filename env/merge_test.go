@@ -0,0 +1,305 @@
+package env
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gopkg.in/yaml.v2"
+)
+
+func unmarshalTestEnvironment(t *testing.T, in string) Environment {
+	t.Helper()
+
+	var env Environment
+	if err := yaml.Unmarshal([]byte(in), &env); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return env
+}
+
+func TestMerge(t *testing.T) {
+	global := unmarshalTestEnvironment(t, `
+- CI: "true"
+- HOME
+- GITHUB_TOKEN
+- AWS_*
+- name: LOG_LEVEL
+  default: info
+`)
+	step := unmarshalTestEnvironment(t, `
+- HOME: /step
+- name: GITHUB_TOKEN
+  unset: true
+- STEP: "1"
+- name: LOG_LEVEL
+  secret: true
+`)
+	repo := unmarshalTestEnvironment(t, `
+- CI
+- name: AWS_*
+  unset: true
+- name: MISSING
+  unset: true
+- REPO: yes
+`)
+
+	merged := Merge(
+		Layer{Name: "global", Environment: global},
+		Layer{Name: "step", Environment: step},
+		Layer{Name: "repo", Environment: repo},
+	)
+
+	t.Run("variables", func(t *testing.T) {
+		if diff := cmp.Diff(merged.Names(), []string{"CI", "HOME", "LOG_LEVEL", "STEP", "REPO"}); diff != "" {
+			t.Errorf("unexpected names:\n%s", diff)
+		}
+
+		have, err := merged.Resolve([]string{"CI=outer", "GITHUB_TOKEN=token", "AWS_REGION=us-east-1", "LOG_LEVEL=debug"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := map[string]string{
+			"CI":        "outer",
+			"HOME":      "/step",
+			"LOG_LEVEL": "debug",
+			"STEP":      "1",
+			"REPO":      "yes",
+		}
+		if diff := cmp.Diff(have, want); diff != "" {
+			t.Errorf("unexpected resolved environment:\n%s", diff)
+		}
+
+		if merged.IsStatic() {
+			t.Error("unexpected static environment")
+		}
+	})
+
+	t.Run("static and pass-through", func(t *testing.T) {
+		r, err := merged.ResolveEnvironment(nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// LOG_LEVEL is replaced entirely by the step layer, so it loses its
+		// default but becomes secret.
+		if value, _ := r.Get("LOG_LEVEL"); value != "" {
+			t.Errorf("unexpected value for LOG_LEVEL: %q", value)
+		}
+		if !r.IsSecret("LOG_LEVEL") {
+			t.Error("LOG_LEVEL isn't secret")
+		}
+		if value, _ := r.Get("CI"); value != "" {
+			t.Errorf("unexpected value for CI: %q", value)
+		}
+	})
+
+	t.Run("Origin", func(t *testing.T) {
+		for name, want := range map[string]string{
+			"CI":        "repo",
+			"HOME":      "step",
+			"LOG_LEVEL": "step",
+			"STEP":      "step",
+			"REPO":      "repo",
+		} {
+			if have, ok := merged.Origin(name); !ok || have != want {
+				t.Errorf("unexpected origin for %s: have=%q (%v) want=%q", name, have, ok, want)
+			}
+		}
+
+		for _, name := range []string{"GITHUB_TOKEN", "AWS_*", "MISSING"} {
+			if have, ok := merged.Origin(name); ok {
+				t.Errorf("unexpected origin for %s: %q", name, have)
+			}
+		}
+	})
+
+	t.Run("layers are unchanged", func(t *testing.T) {
+		if diff := cmp.Diff(global.Names(), []string{"CI", "HOME", "GITHUB_TOKEN", "AWS_*", "LOG_LEVEL"}); diff != "" {
+			t.Errorf("unexpected global names:\n%s", diff)
+		}
+		if have, ok := global.Origin("CI"); !ok || have != "" {
+			t.Errorf("unexpected origin in unmerged environment: %q (%v)", have, ok)
+		}
+	})
+
+	t.Run("no layers", func(t *testing.T) {
+		if have := Merge(); !have.Equal(Environment{}) {
+			t.Errorf("unexpected environment: %+v", have)
+		}
+	})
+}
+
+func TestMerge_UnsetPatterns(t *testing.T) {
+	merged := Merge(
+		Layer{Name: "global", Environment: unmarshalTestEnvironment(t, `
+- AWS_REGION: us-east-1
+- AWS_PROFILE
+- AWS_*
+- GOOGLE_*
+- HOME
+`)},
+		Layer{Name: "step", Environment: unmarshalTestEnvironment(t, `
+- name: AWS_*
+  unset: true
+- name: GOOGLE_[AB]*
+  unset: true
+`)},
+	)
+
+	if diff := cmp.Diff(merged.Names(), []string{"GOOGLE_*", "HOME"}); diff != "" {
+		t.Errorf("unexpected names:\n%s", diff)
+	}
+}
+
+func TestMerge_Interpolation(t *testing.T) {
+	global := unmarshalTestEnvironment(t, `
+- LITERAL: ${HOME}$$
+- BASE: /opt
+`)
+	step := unmarshalTestEnvironment(t, `
+- BIN: ${BASE}/bin
+- ESCAPED: $${BASE}
+`)
+
+	t.Run("interpolating layer", func(t *testing.T) {
+		merged := Merge(
+			Layer{Name: "global", Environment: global},
+			Layer{Name: "step", Environment: step.WithInterpolation()},
+		)
+
+		have, err := merged.Resolve([]string{"HOME=/home/me"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := map[string]string{
+			"LITERAL": "${HOME}$$",
+			"BASE":    "/opt",
+			"BIN":     "/opt/bin",
+			"ESCAPED": "${BASE}",
+		}
+		if diff := cmp.Diff(have, want); diff != "" {
+			t.Errorf("unexpected resolved environment:\n%s", diff)
+		}
+	})
+
+	t.Run("no interpolation", func(t *testing.T) {
+		merged := Merge(
+			Layer{Name: "global", Environment: global},
+			Layer{Name: "step", Environment: step},
+		)
+
+		have, err := merged.Resolve(nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := map[string]string{
+			"LITERAL": "${HOME}$$",
+			"BASE":    "/opt",
+			"BIN":     "${BASE}/bin",
+			"ESCAPED": "$${BASE}",
+		}
+		if diff := cmp.Diff(have, want); diff != "" {
+			t.Errorf("unexpected resolved environment:\n%s", diff)
+		}
+	})
+}
+
+func TestMerge_Options(t *testing.T) {
+	policy := newTestPolicy(t, nil, []string{"SRC_*"})
+	other := newTestPolicy(t, nil, []string{"AWS_*"})
+	env := Environment{vars: []variable{{name: "A", value: stringPtr("a")}}}
+
+	merged := Merge(
+		Layer{Name: "a", Environment: env.WithStrictResolution().WithPolicy(other)},
+		Layer{Name: "b", Environment: env.WithPolicy(policy)},
+		Layer{Name: "c", Environment: env},
+	)
+	if !merged.strict {
+		t.Error("merged environment isn't strict")
+	}
+	if merged.interpolate {
+		t.Error("merged environment uses interpolation")
+	}
+	if !merged.policy.Equal(policy) {
+		t.Error("merged environment doesn't use the last policy")
+	}
+	if merged.relaxedNames {
+		t.Error("merged environment accepts relaxed names")
+	}
+
+	t.Run("relaxed names", func(t *testing.T) {
+		relaxed := New().WithRelaxedNames().MustWithStatic("my-var", "a")
+		merged := Merge(Layer{Name: "a", Environment: env}, Layer{Name: "b", Environment: relaxed})
+
+		// Names the layers accept are still accepted by the merged
+		// environment.
+		if _, err := merged.WithStatic("other-var", "b"); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestVariable_Unset(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		env := unmarshalTestEnvironment(t, `
+- FOO: bar
+- name: BAZ
+  unset: true
+`)
+
+		data, err := json.Marshal(env)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if want := `[{"FOO":"bar"},{"name":"BAZ","unset":true}]`; string(data) != want {
+			t.Errorf("unexpected JSON: have=%s want=%s", data, want)
+		}
+
+		var have Environment
+		if err := json.Unmarshal(data, &have); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !have.Equal(env) {
+			t.Errorf("environment did not round trip: have=%+v want=%+v", have, env)
+		}
+
+		// Unset markers aren't variables.
+		if diff := cmp.Diff(have.Names(), []string{"FOO"}); diff != "" {
+			t.Errorf("unexpected names:\n%s", diff)
+		}
+		if !have.IsStatic() {
+			t.Error("unexpected non-static environment")
+		}
+		resolved, err := have.Resolve([]string{"BAZ=outer"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if diff := cmp.Diff(resolved, map[string]string{"FOO": "bar"}); diff != "" {
+			t.Errorf("unexpected resolved environment:\n%s", diff)
+		}
+	})
+
+	t.Run("conflicting keys", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			in   string
+			want string
+		}{
+			"value":    {in: `{"name":"FOO","unset":true,"value":"bar"}`, want: "value"},
+			"default":  {in: `{"name":"FOO","unset":true,"default":"bar"}`, want: "default"},
+			"required": {in: `{"name":"FOO","unset":true,"required":true}`, want: "required"},
+			"secret":   {in: `{"name":"FOO","unset":true,"secret":true}`, want: "secret"},
+		} {
+			t.Run(name, func(t *testing.T) {
+				var have variable
+				if err := json.Unmarshal([]byte(tc.in), &have); err == nil {
+					t.Error("unexpected nil error")
+				} else if e, ok := err.(errConflictingVariableKeys); !ok {
+					t.Errorf("unexpected error of type %T: %v", err, err)
+				} else if e.b != tc.want {
+					t.Errorf("unexpected key in the error: have=%q want=%q", e.b, tc.want)
+				}
+			})
+		}
+	})
+}
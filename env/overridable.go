@@ -0,0 +1,350 @@
+package env
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/sourcegraph/batch-change-utils/internal/jsonwalk"
+	"github.com/sourcegraph/batch-change-utils/overridable"
+)
+
+// OverridableEnvironment is an environment in which static values can be
+// overridden for specific repositories, using the same rule lists as the
+// overridable package, in which the last matching rule wins:
+//
+//	env:
+//	  - NPM_REGISTRY:
+//	      - "*": https://registry.npmjs.org
+//	      - github.com/internal/*: https://npm.internal
+//	  - HOME
+//
+// As with static values, rule values can be any scalar. Any other variable is
+// declared as in an Environment. ForRepo and ForRepoBranch return the
+// environment for a specific repository.
+type OverridableEnvironment struct {
+	vars []overridableVariable
+
+	// relaxedNames allows the same names as in an Environment with relaxed
+	// names.
+	relaxedNames bool
+}
+
+// WithRelaxedNames returns a copy of the environment that accepts variable
+// names other than POSIX names when unmarshalled into, as with
+// Environment.WithRelaxedNames. The environments returned by ForRepo accept
+// them too.
+func (e OverridableEnvironment) WithRelaxedNames() OverridableEnvironment {
+	e.relaxedNames = true
+	return e
+}
+
+// overridableVariable is either a variable, or the name of a variable with a
+// list of rules.
+type overridableVariable struct {
+	variable variable
+	rules    *overridableRules
+}
+
+// ForRepo returns the environment for the given repository. A variable with a
+// list of rules has the value of the last rule matching the repository, and
+// is omitted if no rule matches. The branch suffixes of rules are ignored: use
+// ForRepoBranch to match them.
+func (e OverridableEnvironment) ForRepo(name string) Environment {
+	return e.forRepo(func(rules *overridableRules) (string, bool) {
+		return rules.Lookup(name)
+	})
+}
+
+// ForRepoBranch returns the environment for the given repository and branch,
+// as ForRepo does, except that rules with a branch suffix only match branches
+// matching it, as with overridable.String.ValueWithSuffix.
+func (e OverridableEnvironment) ForRepoBranch(name, branch string) Environment {
+	return e.forRepo(func(rules *overridableRules) (string, bool) {
+		return rules.LookupWithSuffix(name, branch)
+	})
+}
+
+func (e OverridableEnvironment) forRepo(lookup func(*overridableRules) (string, bool)) Environment {
+	env := Environment{relaxedNames: e.relaxedNames}
+	for _, ov := range e.vars {
+		if ov.rules == nil {
+			env.vars = append(env.vars, ov.variable)
+			continue
+		}
+
+		if value, ok := lookup(ov.rules); ok {
+			env.vars = append(env.vars, variable{name: ov.variable.name, value: &value})
+		}
+	}
+	return env
+}
+
+// MarshalJSON marshals the environment into the array form.
+func (e OverridableEnvironment) MarshalJSON() ([]byte, error) {
+	if e.vars == nil {
+		return []byte(`{}`), nil
+	}
+	return json.Marshal(e.vars)
+}
+
+// UnmarshalJSON unmarshals an environment from either the array or object
+// form. In the object form, each value is either a string or a list of rules.
+func (e *OverridableEnvironment) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &e.vars); err == nil {
+		return e.validateNames()
+	} else if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		// It is an array, so the error is about one of its variables.
+		return err
+	}
+
+	kv := make(map[string]overridableValue)
+	if err := json.Unmarshal(data, &kv); err != nil {
+		return err
+	}
+
+	members, err := jsonwalk.Members(data)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, len(members))
+	for i, m := range members {
+		keys[i] = m.Key
+	}
+	if err := checkNames(keys, e.relaxedNames); err != nil {
+		return err
+	}
+
+	e.vars = overridableVariables(keys, kv)
+	return nil
+}
+
+// UnmarshalYAML unmarshals an environment from either the array or object
+// form. In the object form, each value is either a string or a list of rules.
+func (e *OverridableEnvironment) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	err := unmarshal(&e.vars)
+	if err == nil {
+		return e.validateNames()
+	}
+	var seq []interface{}
+	if unmarshal(&seq) == nil {
+		// It is a sequence, so the error is about one of its variables.
+		return err
+	}
+
+	// As with Environment, we check the keys before yaml.v3 can reject
+	// duplicates with a less specific error.
+	keys := yamlKeys(unmarshal)
+	if err := checkNames(keys, e.relaxedNames); err != nil {
+		return err
+	}
+	kv := make(map[string]overridableValue)
+	if err := unmarshal(&kv); err != nil {
+		return err
+	}
+
+	e.vars = overridableVariables(keys, kv)
+	return nil
+}
+
+// validateNames checks the names of the variables in the environment, in the
+// same way as Environment.
+func (e OverridableEnvironment) validateNames() error {
+	names := make([]string, len(e.vars))
+	for i, ov := range e.vars {
+		names[i] = ov.variable.name
+	}
+	return checkNames(names, e.relaxedNames)
+}
+
+// overridableVariables converts an object into a slice of variables, ordered
+// by the given keys: see orderKeys.
+func overridableVariables(keys []string, kv map[string]overridableValue) []overridableVariable {
+	all := make([]string, 0, len(kv))
+	for k := range kv {
+		all = append(all, k)
+	}
+
+	vars := make([]overridableVariable, 0, len(kv))
+	for _, k := range orderKeys(keys, all) {
+		value := kv[k]
+		vars = append(vars, overridableVariable{
+			variable: variable{name: k, value: value.static},
+			rules:    value.rules,
+		})
+	}
+	return vars
+}
+
+// Equal checks if two environments are equal.
+func (e OverridableEnvironment) Equal(other OverridableEnvironment) bool {
+	if len(e.vars) != len(other.vars) {
+		return false
+	}
+	for i := range e.vars {
+		if !e.vars[i].Equal(other.vars[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a overridableVariable) Equal(b overridableVariable) bool {
+	if a.rules == nil || b.rules == nil {
+		return a.rules == b.rules && a.variable.Equal(b.variable)
+	}
+	return a.variable.name == b.variable.name && a.rules.Equal(b.rules.String)
+}
+
+func (ov overridableVariable) MarshalJSON() ([]byte, error) {
+	if ov.rules == nil {
+		return json.Marshal(ov.variable)
+	}
+
+	// An empty list of rules is marshalled as an empty string, and a single
+	// rule matching every repository as a scalar, either of which would
+	// unmarshal as a static variable, so we need to expand them.
+	rules := []byte("[]")
+	if !ov.rules.Equal(overridable.String{}) {
+		var err error
+		if rules, err = json.Marshal(ov.rules); err != nil {
+			return nil, err
+		}
+		if !bytes.HasPrefix(rules, []byte("[")) {
+			rules = append(append([]byte(`[{"*":`), rules...), "}]"...)
+		}
+	}
+
+	return json.Marshal(map[string]json.RawMessage{ov.variable.name: rules})
+}
+
+func (ov *overridableVariable) UnmarshalJSON(data []byte) error {
+	// A variable with rules is an object with one property, the value of which
+	// is an array. Anything else is a variable.
+	var kv map[string]json.RawMessage
+	if err := json.Unmarshal(data, &kv); err == nil && len(kv) == 1 {
+		for k, raw := range kv {
+			if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+				var rules overridableRules
+				if err := json.Unmarshal(raw, &rules); err != nil {
+					return err
+				}
+				*ov = overridableVariable{variable: variable{name: k}, rules: &rules}
+				return nil
+			}
+		}
+	}
+
+	*ov = overridableVariable{}
+	return ov.variable.UnmarshalJSON(data)
+}
+
+func (ov *overridableVariable) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	// As above, but a sequence rather than an array.
+	var kv map[string][]interface{}
+	if err := unmarshal(&kv); err == nil && len(kv) == 1 {
+		var rules map[string]overridableRules
+		if err := unmarshal(&rules); err != nil {
+			return err
+		}
+		for k, r := range rules {
+			r := r
+			*ov = overridableVariable{variable: variable{name: k}, rules: &r}
+		}
+		return nil
+	}
+
+	*ov = overridableVariable{}
+	return ov.variable.UnmarshalYAML(unmarshal)
+}
+
+// overridableValue is the value of a variable in an OverridableEnvironment:
+// either a static scalar, or a list of rules.
+type overridableValue struct {
+	static *string
+	rules  *overridableRules
+}
+
+func (v *overridableValue) UnmarshalJSON(data []byte) error {
+	var s scalar
+	if err := json.Unmarshal(data, &s); err == nil {
+		*v = overridableValue{static: (*string)(&s)}
+		return nil
+	}
+
+	// We only want lists of rules here, not the scalar form.
+	var list []json.RawMessage
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errInvalidVariableType
+	}
+
+	var rules overridableRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return err
+	}
+	*v = overridableValue{rules: &rules}
+	return nil
+}
+
+func (v *overridableValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s scalar
+	if err := unmarshal(&s); err == nil {
+		*v = overridableValue{static: (*string)(&s)}
+		return nil
+	}
+
+	var list []interface{}
+	if err := unmarshal(&list); err != nil {
+		return errInvalidVariableType
+	}
+
+	var rules overridableRules
+	if err := unmarshal(&rules); err != nil {
+		return err
+	}
+	*v = overridableValue{rules: &rules}
+	return nil
+}
+
+// overridableRules is a list of rules for the value of a variable. As with
+// static values, rule values may be any scalar, which is coerced to a string:
+// see scalar.
+type overridableRules struct {
+	overridable.String
+}
+
+func (r *overridableRules) UnmarshalJSON(data []byte) error {
+	return r.unmarshal(func(v interface{}) error { return json.Unmarshal(data, v) })
+}
+
+func (r *overridableRules) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return r.unmarshal(unmarshal)
+}
+
+// unmarshal coerces the value of each rule before the rules are unmarshalled
+// into the overridable.String.
+func (r *overridableRules) unmarshal(unmarshal func(interface{}) error) error {
+	// Null values are decoded as nil, since yaml.v3 drops them otherwise.
+	var values []map[string]*scalar
+	if err := unmarshal(&values); err != nil {
+		// Let the overridable package report which rule is invalid, if it
+		// can.
+		if rerr := unmarshal(&r.String); rerr != nil {
+			return rerr
+		}
+		return err
+	}
+
+	rules := make([]map[string]string, len(values))
+	for i, rule := range values {
+		rules[i] = make(map[string]string, len(rule))
+		for pattern, value := range rule {
+			rules[i][pattern] = value.value()
+		}
+	}
+
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &r.String)
+}
@@ -0,0 +1,386 @@
+package env
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+const overridableEnvironmentYAML = `
+- CI: "true"
+- NPM_REGISTRY:
+    - "*": https://registry.npmjs.org
+    - github.com/internal/*: https://npm.internal
+    - github.com/internal/legacy: https://npm-legacy.internal
+- HOME
+- INTERNAL_ONLY:
+    - github.com/internal/*: "yes"
+- name: TOKEN
+  secret: true
+- ALWAYS:
+    - "*": always
+- NEVER: []
+`
+
+func TestOverridableEnvironment_ForRepo(t *testing.T) {
+	var env OverridableEnvironment
+	if err := yaml.Unmarshal([]byte(overridableEnvironmentYAML), &env); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for repo, tc := range map[string]struct {
+		names []string
+		want  map[string]string
+	}{
+		"github.com/public/repo": {
+			names: []string{"CI", "NPM_REGISTRY", "HOME", "TOKEN", "ALWAYS"},
+			want: map[string]string{
+				"CI":           "true",
+				"NPM_REGISTRY": "https://registry.npmjs.org",
+				"HOME":         "/home/me",
+				"TOKEN":        "",
+				"ALWAYS":       "always",
+			},
+		},
+		"github.com/internal/repo": {
+			names: []string{"CI", "NPM_REGISTRY", "HOME", "INTERNAL_ONLY", "TOKEN", "ALWAYS"},
+			want: map[string]string{
+				"CI":            "true",
+				"NPM_REGISTRY":  "https://npm.internal",
+				"HOME":          "/home/me",
+				"INTERNAL_ONLY": "yes",
+				"TOKEN":         "",
+				"ALWAYS":        "always",
+			},
+		},
+		"github.com/internal/legacy": {
+			names: []string{"CI", "NPM_REGISTRY", "HOME", "INTERNAL_ONLY", "TOKEN", "ALWAYS"},
+			want: map[string]string{
+				"CI":            "true",
+				"NPM_REGISTRY":  "https://npm-legacy.internal",
+				"HOME":          "/home/me",
+				"INTERNAL_ONLY": "yes",
+				"TOKEN":         "",
+				"ALWAYS":        "always",
+			},
+		},
+	} {
+		t.Run(repo, func(t *testing.T) {
+			repoEnv := env.ForRepo(repo)
+			if diff := cmp.Diff(repoEnv.Names(), tc.names); diff != "" {
+				t.Errorf("unexpected names:\n%s", diff)
+			}
+
+			r, err := repoEnv.ResolveEnvironment([]string{"HOME=/home/me"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(r.Map(), tc.want); diff != "" {
+				t.Errorf("unexpected resolved environment:\n%s", diff)
+			}
+			if !r.IsSecret("TOKEN") {
+				t.Error("TOKEN isn't secret")
+			}
+		})
+	}
+}
+
+func TestOverridableEnvironment_ForRepoBranch(t *testing.T) {
+	var env OverridableEnvironment
+	in := "- DEPLOY:\n    - '*': staging\n    - github.com/a/*@main: production\n- RELEASE:\n    - github.com/a/*@release/*: yes\n"
+	if err := yaml.Unmarshal([]byte(in), &env); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		repo, branch string
+		want         map[string]string
+	}{
+		"main":           {repo: "github.com/a/b", branch: "main", want: map[string]string{"DEPLOY": "production"}},
+		"release":        {repo: "github.com/a/b", branch: "release/1.0", want: map[string]string{"DEPLOY": "staging", "RELEASE": "yes"}},
+		"other branch":   {repo: "github.com/a/b", branch: "feature", want: map[string]string{"DEPLOY": "staging"}},
+		"other repo":     {repo: "github.com/c/d", branch: "main", want: map[string]string{"DEPLOY": "staging"}},
+		"without branch": {repo: "github.com/a/b", want: map[string]string{"DEPLOY": "staging"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			have, err := env.ForRepoBranch(tc.repo, tc.branch).Resolve(nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(have, tc.want); diff != "" {
+				t.Errorf("unexpected resolved environment:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestOverridableEnvironment_RoundTrip(t *testing.T) {
+	var in OverridableEnvironment
+	if err := yaml.Unmarshal([]byte(overridableEnvironmentYAML), &in); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `[{"CI":"true"},` +
+		`{"NPM_REGISTRY":[{"*":"https://registry.npmjs.org"},{"github.com/internal/*":"https://npm.internal"},{"github.com/internal/legacy":"https://npm-legacy.internal"}]},` +
+		`"HOME",` +
+		`{"INTERNAL_ONLY":[{"github.com/internal/*":"yes"}]},` +
+		`{"name":"TOKEN","secret":true},` +
+		`{"ALWAYS":[{"*":"always"}]},` +
+		`{"NEVER":[]}]`
+	if string(data) != want {
+		t.Errorf("unexpected JSON:\nhave=%s\nwant=%s", data, want)
+	}
+
+	for name, unmarshal := range map[string]func([]byte, interface{}) error{
+		"JSON":    json.Unmarshal,
+		"yaml.v2": yaml.Unmarshal,
+		"yaml.v3": yamlv3.Unmarshal,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var have OverridableEnvironment
+			if err := unmarshal(data, &have); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !have.Equal(in) {
+				t.Errorf("environment did not round trip:\nhave=%+v\nwant=%+v", have, in)
+			}
+		})
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		data, err := json.Marshal(OverridableEnvironment{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(data) != `{}` {
+			t.Errorf("unexpected JSON: %s", data)
+		}
+
+		var have OverridableEnvironment
+		if err := json.Unmarshal(data, &have); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(have.ForRepo("repo").Names()) != 0 {
+			t.Errorf("unexpected variables: %+v", have)
+		}
+	})
+}
+
+func TestOverridableEnvironment_ObjectForm(t *testing.T) {
+	for name, tc := range map[string]struct {
+		in        string
+		unmarshal func([]byte, interface{}) error
+	}{
+		"JSON": {
+			in:        `{"Z":"z","REGISTRY":[{"*":"public"},{"internal/*":"internal"}],"A":"a","PORT":8080}`,
+			unmarshal: json.Unmarshal,
+		},
+		"yaml.v2": {
+			in:        "Z: z\nREGISTRY:\n  - '*': public\n  - internal/*: internal\nA: a\nPORT: 8080\n",
+			unmarshal: yaml.Unmarshal,
+		},
+		"yaml.v3": {
+			in:        "Z: z\nREGISTRY:\n  - '*': public\n  - internal/*: internal\nA: a\nPORT: 8080\n",
+			unmarshal: yamlv3.Unmarshal,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var env OverridableEnvironment
+			if err := tc.unmarshal([]byte(tc.in), &env); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for repo, want := range map[string]string{
+				"public/repo":   "public",
+				"internal/repo": "internal",
+			} {
+				repoEnv := env.ForRepo(repo)
+				if diff := cmp.Diff(repoEnv.Names(), []string{"Z", "REGISTRY", "A", "PORT"}); diff != "" {
+					t.Errorf("unexpected names:\n%s", diff)
+				}
+
+				have, err := repoEnv.Resolve(nil)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if diff := cmp.Diff(have, map[string]string{"Z": "z", "REGISTRY": want, "A": "a", "PORT": "8080"}); diff != "" {
+					t.Errorf("unexpected resolved environment for %s:\n%s", repo, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestOverridableEnvironment_ScalarRules(t *testing.T) {
+	// Rule values are coerced like static values, keeping their literal text.
+	in := `[{"PORT":[{"*":8080},{"internal/*":null}]},{"VERSION":[{"*":1.10}]},{"DEBUG":[{"*":true}]}]`
+
+	for name, unmarshal := range map[string]func([]byte, interface{}) error{
+		"JSON":    json.Unmarshal,
+		"yaml.v2": yaml.Unmarshal,
+		"yaml.v3": yamlv3.Unmarshal,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var env OverridableEnvironment
+			if err := unmarshal([]byte(in), &env); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for repo, want := range map[string]map[string]string{
+				"public/repo":   {"PORT": "8080", "VERSION": "1.10", "DEBUG": "true"},
+				"internal/repo": {"PORT": "", "VERSION": "1.10", "DEBUG": "true"},
+			} {
+				have, err := env.ForRepo(repo).Resolve(nil)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if diff := cmp.Diff(have, want); diff != "" {
+					t.Errorf("unexpected resolved environment for %s:\n%s", repo, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestOverridableEnvironment_ArrayErrors(t *testing.T) {
+	// Errors within the array form are reported as is, rather than as a
+	// failure to unmarshal the array as an object.
+	for name, tc := range map[string]struct {
+		in        string
+		unmarshal func([]byte, interface{}) error
+	}{
+		"JSON":    {in: `[{"NPM":[{"*":[1]}]}]`, unmarshal: json.Unmarshal},
+		"yaml.v2": {in: "- NPM:\n    - '*': [1]", unmarshal: yaml.Unmarshal},
+		"yaml.v3": {in: "- NPM:\n    - '*': [1]", unmarshal: yamlv3.Unmarshal},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var env OverridableEnvironment
+			err := tc.unmarshal([]byte(tc.in), &env)
+			if err == nil {
+				t.Fatal("unexpected nil error")
+			}
+			if !strings.Contains(err.Error(), "invalid value for array entry 0") {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestOverridableEnvironment_NameValidation(t *testing.T) {
+	for name, tc := range map[string]struct {
+		json, yaml string
+		want       error
+	}{
+		"duplicate in array": {
+			json: `["A",{"B":"b"},{"A":[{"*":"a"}]}]`,
+			yaml: "- A\n- B: b\n- A:\n    - '*': a",
+			want: errDuplicateVariable{name: "A", indexes: []int{0, 2}},
+		},
+		"invalid name in array": {
+			json: `["A",{"1BAD":[{"*":"a"}]}]`,
+			yaml: "- A\n- 1BAD:\n    - '*': a",
+			want: errInvalidVariableName{name: "1BAD", index: 1, reason: "names must only contain letters, digits, and underscores, and must not start with a digit"},
+		},
+		"invalid name in object": {
+			json: `{"A":"a","A-B":[{"*":"b"}]}`,
+			yaml: "A: a\nA-B:\n  - '*': b",
+			want: errInvalidVariableName{name: "A-B", index: 1, reason: "names must only contain letters, digits, and underscores, and must not start with a digit"},
+		},
+		"duplicate in object": {
+			json: `{"A":"a","B":"b","A":[{"*":"c"}]}`,
+			yaml: "A: a\nB: b\nA:\n  - '*': c",
+			want: errDuplicateVariable{name: "A", indexes: []int{0, 2}},
+		},
+	} {
+		for format, unmarshal := range map[string]func() error{
+			"JSON":    func() error { var e OverridableEnvironment; return json.Unmarshal([]byte(tc.json), &e) },
+			"yaml.v2": func() error { var e OverridableEnvironment; return yaml.Unmarshal([]byte(tc.yaml), &e) },
+			"yaml.v3": func() error { var e OverridableEnvironment; return yamlv3.Unmarshal([]byte(tc.yaml), &e) },
+		} {
+			t.Run(name+"/"+format, func(t *testing.T) {
+				err := unmarshal()
+				if err == nil {
+					t.Fatal("unexpected nil error")
+				}
+				if err.Error() != tc.want.Error() {
+					t.Errorf("unexpected error: have=%q want=%q", err.Error(), tc.want.Error())
+				}
+			})
+		}
+	}
+}
+
+func TestOverridableEnvironment_RelaxedNames(t *testing.T) {
+	for name, tc := range map[string]struct {
+		in        string
+		unmarshal func([]byte, interface{}) error
+	}{
+		"JSON array":    {in: `[{"my-var":[{"*":"a"}]},{"other.var":"b"}]`, unmarshal: json.Unmarshal},
+		"JSON object":   {in: `{"my-var":[{"*":"a"}],"other.var":"b"}`, unmarshal: json.Unmarshal},
+		"yaml.v2 array": {in: "- my-var:\n    - '*': a\n- other.var: b", unmarshal: yaml.Unmarshal},
+		"yaml.v3 array": {in: "- my-var:\n    - '*': a\n- other.var: b", unmarshal: yamlv3.Unmarshal},
+		"yaml.v2 map":   {in: "my-var:\n  - '*': a\nother.var: b", unmarshal: yaml.Unmarshal},
+		"yaml.v3 map":   {in: "my-var:\n  - '*': a\nother.var: b", unmarshal: yamlv3.Unmarshal},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var strict OverridableEnvironment
+			if err := tc.unmarshal([]byte(tc.in), &strict); err == nil {
+				t.Error("unexpected nil error without relaxed names")
+			}
+
+			env := OverridableEnvironment{}.WithRelaxedNames()
+			if err := tc.unmarshal([]byte(tc.in), &env); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			repoEnv := env.ForRepo("github.com/a/b")
+			if diff := cmp.Diff(repoEnv.Names(), []string{"my-var", "other.var"}); diff != "" {
+				t.Errorf("unexpected names:\n%s", diff)
+			}
+			if _, err := repoEnv.WithStatic("third-var", "c"); err != nil {
+				t.Errorf("unexpected error adding relaxed name: %v", err)
+			}
+		})
+	}
+}
+
+func TestOverridableEnvironment_Invalid(t *testing.T) {
+	for name, tc := range map[string]struct {
+		json, yaml string
+	}{
+		"invalid glob": {
+			json: `[{"A":[{"[":"a"}]}]`,
+			yaml: "- A:\n    - '[': a",
+		},
+		"invalid rule value": {
+			json: `[{"A":[{"*":{"b":1}}]}]`,
+			yaml: "- A:\n    - '*': [a]",
+		},
+		"invalid object value": {
+			json: `{"A":{"b":"c"}}`,
+			yaml: "A: {b: c}",
+		},
+		"invalid variable": {
+			json: `[{"name":"A","default":"a","required":true}]`,
+			yaml: "- name: A\n  default: a\n  required: true",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var env OverridableEnvironment
+			if err := json.Unmarshal([]byte(tc.json), &env); err == nil {
+				t.Error("unexpected nil error from JSON")
+			}
+			if err := yaml.Unmarshal([]byte(tc.yaml), &env); err == nil {
+				t.Error("unexpected nil error from YAML")
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package env
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gobwas/glob"
+	"github.com/pkg/errors"
+)
+
+// Policy restricts the outer variables that an environment may read, such as
+// to prevent batch specs from reading credentials used by the executor.
+//
+// An outer variable may be read if it matches at least one allow rule, or
+// there are no allow rules, and doesn't match any deny rule.
+//
+// A nil *Policy allows every outer variable to be read.
+type Policy struct {
+	allow []policyRule
+	deny  []policyRule
+}
+
+type policyRule struct {
+	pattern  string
+	compiled glob.Glob
+}
+
+// NewPolicy builds a new policy from the given allow and deny glob patterns,
+// such as SRC_*.
+func NewPolicy(allow, deny []string) (*Policy, error) {
+	var p Policy
+	var err error
+	if p.allow, err = compilePolicyRules(allow); err != nil {
+		return nil, err
+	}
+	if p.deny, err = compilePolicyRules(deny); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func compilePolicyRules(patterns []string) ([]policyRule, error) {
+	rules := make([]policyRule, len(patterns))
+	for i, pattern := range patterns {
+		compiled, err := glob.Compile(pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid policy pattern %q", pattern)
+		}
+		rules[i] = policyRule{pattern: pattern, compiled: compiled}
+	}
+	return rules, nil
+}
+
+// Allows returns true if the policy allows the given outer variable to be
+// read.
+func (p *Policy) Allows(name string) bool {
+	_, ok := p.check(name)
+	return ok
+}
+
+// check returns whether the given outer variable may be read and, if it may
+// not be, the pattern of the deny rule that blocks it, which is empty if it
+// doesn't match any allow rule.
+func (p *Policy) check(name string) (rule string, ok bool) {
+	if p == nil {
+		return "", true
+	}
+
+	for _, r := range p.deny {
+		if r.compiled.Match(name) {
+			return r.pattern, false
+		}
+	}
+
+	if len(p.allow) == 0 {
+		return "", true
+	}
+	for _, r := range p.allow {
+		if r.compiled.Match(name) {
+			return "", true
+		}
+	}
+	return "", false
+}
+
+// Equal checks if two policies have the same rules.
+func (p *Policy) Equal(other *Policy) bool {
+	if p == nil || other == nil {
+		return p == other
+	}
+	return policyRulesEqual(p.allow, other.allow) && policyRulesEqual(p.deny, other.deny)
+}
+
+func policyRulesEqual(a, b []policyRule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].pattern != b[i].pattern {
+			return false
+		}
+	}
+	return true
+}
+
+// PolicyViolation describes an outer variable that an environment would read,
+// but that is blocked by a Policy.
+type PolicyViolation struct {
+	// Variable is the name of the variable in the environment.
+	Variable string
+	// Outer is the name of the outer variable that would be read. This is the
+	// same as Variable unless the value of Variable references Outer.
+	Outer string
+	// Rule is the pattern of the deny rule that blocks the outer variable, or
+	// empty if it doesn't match any allow rule.
+	Rule string
+}
+
+func (v PolicyViolation) String() string {
+	subject := fmt.Sprintf("environment variable %q", v.Variable)
+	if v.Outer != v.Variable {
+		subject = fmt.Sprintf("environment variable %q references %q, which", v.Variable, v.Outer)
+	}
+
+	if v.Rule != "" {
+		return fmt.Sprintf("%s is denied by policy rule %q", subject, v.Rule)
+	}
+	return fmt.Sprintf("%s is not allowed by any policy rule", subject)
+}
+
+// PolicyError is returned when an environment would read outer variables that
+// are blocked by a Policy.
+type PolicyError struct {
+	// Violations contains each blocked outer variable, in the order the
+	// variables reading them were declared.
+	Violations []PolicyViolation
+}
+
+func (e *PolicyError) Error() string {
+	if len(e.Violations) == 1 {
+		return e.Violations[0].String()
+	}
+
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.String()
+	}
+	return fmt.Sprintf("%d environment variables violate the policy: %s", len(e.Violations), strings.Join(msgs, "; "))
+}
+
+// Check statically checks the environment against the policy, returning a
+// *PolicyError listing every outer variable it would read that the policy
+// blocks. This covers pass-through variables and, if interpolation is
+// enabled, references to outer variables within static values.
+//
+// Pattern variables, such as AWS_*, are never violations, since variables
+// blocked by the policy are excluded when they are expanded.
+func (p *Policy) Check(e Environment) error {
+	// declared contains the variables declared before the current one, which
+	// are the only ones its value can reference.
+	declared := make(map[string]bool, len(e.vars))
+
+	var violations []PolicyViolation
+	add := func(variable, outer string) {
+		if rule, ok := p.check(outer); !ok {
+			violations = append(violations, PolicyViolation{Variable: variable, Outer: outer, Rule: rule})
+		}
+	}
+
+	for _, v := range e.vars {
+		switch {
+		case v.pattern != nil || v.unset:
+			continue
+
+		case v.value == nil:
+			add(v.name, v.name)
+
+		case e.interpolate:
+			seen := map[string]bool{}
+			for _, ref := range references(*v.value) {
+				// References to earlier variables in the environment will be
+				// checked with those variables, but a variable referencing
+				// itself or a later variable reads the outer variable.
+				if seen[ref] || declared[ref] {
+					continue
+				}
+				seen[ref] = true
+				add(v.name, ref)
+			}
+		}
+
+		if v.pattern == nil && !v.unset {
+			declared[v.name] = true
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package env
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gobwas/glob"
+	"github.com/google/go-cmp/cmp"
+	"gopkg.in/yaml.v2"
+)
+
+func newTestPolicy(t *testing.T, allow, deny []string) *Policy {
+	t.Helper()
+
+	p, err := NewPolicy(allow, deny)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return p
+}
+
+func TestNewPolicy(t *testing.T) {
+	for name, tc := range map[string]struct {
+		allow, deny []string
+	}{
+		"invalid allow": {allow: []string{"["}},
+		"invalid deny":  {deny: []string{"A", "["}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewPolicy(tc.allow, tc.deny); err == nil {
+				t.Error("unexpected nil error")
+			}
+		})
+	}
+}
+
+func TestPolicy_Allows(t *testing.T) {
+	for name, tc := range map[string]struct {
+		policy *Policy
+		want   map[string]bool
+	}{
+		"nil": {
+			policy: nil,
+			want:   map[string]bool{"SRC_ACCESS_TOKEN": true, "HOME": true},
+		},
+		"empty": {
+			policy: newTestPolicy(t, nil, nil),
+			want:   map[string]bool{"SRC_ACCESS_TOKEN": true, "HOME": true},
+		},
+		"deny only": {
+			policy: newTestPolicy(t, nil, []string{"SRC_*", "*_METADATA_TOKEN"}),
+			want:   map[string]bool{"SRC_ACCESS_TOKEN": false, "GCE_METADATA_TOKEN": false, "HOME": true},
+		},
+		"allow only": {
+			policy: newTestPolicy(t, []string{"AWS_*", "HOME"}, nil),
+			want:   map[string]bool{"AWS_REGION": true, "HOME": true, "HOMEDIR": false, "SRC_ACCESS_TOKEN": false},
+		},
+		"deny wins": {
+			policy: newTestPolicy(t, []string{"AWS_*"}, []string{"AWS_SECRET_*"}),
+			want:   map[string]bool{"AWS_REGION": true, "AWS_SECRET_ACCESS_KEY": false},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			for variable, want := range tc.want {
+				if have := tc.policy.Allows(variable); have != want {
+					t.Errorf("unexpected result for %s: have=%v want=%v", variable, have, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPolicy_Check(t *testing.T) {
+	policy := newTestPolicy(t, []string{"AWS_*", "HOME", "PATH", "CI*"}, []string{"SRC_*", "AWS_SECRET_*"})
+
+	for name, tc := range map[string]struct {
+		env  string
+		want []PolicyViolation
+	}{
+		"allowed": {
+			env: `
+- HOME
+- AWS_REGION
+- name: AWS_PROFILE
+  default: default
+- STATIC: ${SRC_ACCESS_TOKEN}
+- "*"
+`,
+		},
+		"pass-through": {
+			env: `
+- HOME
+- SRC_ACCESS_TOKEN
+- name: AWS_SECRET_ACCESS_KEY
+  secret: true
+- name: USER
+  default: me
+- CI
+`,
+			want: []PolicyViolation{
+				{Variable: "SRC_ACCESS_TOKEN", Outer: "SRC_ACCESS_TOKEN", Rule: "SRC_*"},
+				{Variable: "AWS_SECRET_ACCESS_KEY", Outer: "AWS_SECRET_ACCESS_KEY", Rule: "AWS_SECRET_*"},
+				{Variable: "USER", Outer: "USER"},
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var env Environment
+			if err := yaml.Unmarshal([]byte(tc.env), &env); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			err := policy.Check(env)
+			if tc.want == nil {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var e *PolicyError
+			if !errors.As(err, &e) {
+				t.Fatalf("unexpected error of type %T: %v", err, err)
+			}
+			if diff := cmp.Diff(e.Violations, tc.want); diff != "" {
+				t.Errorf("unexpected violations:\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("interpolation", func(t *testing.T) {
+		var env Environment
+		if err := yaml.Unmarshal([]byte(`
+- EARLY: ${SRC_LATER}
+- TOKEN: ${SRC_ACCESS_TOKEN}
+- PATH: ${PATH}:/usr/local/bin
+- HOME
+- URL: https://${TOKEN}@${HOME}/${USER}/${USER}/${SRC_ENDPOINT}
+- ESCAPED: $${SRC_ACCESS_TOKEN}
+- SELF: ${SELF}
+- SRC_LATER: later
+`), &env); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var e *PolicyError
+		if err := policy.Check(env.WithInterpolation()); !errors.As(err, &e) {
+			t.Fatalf("unexpected error of type %T: %v", err, err)
+		}
+		want := []PolicyViolation{
+			{Variable: "EARLY", Outer: "SRC_LATER", Rule: "SRC_*"},
+			{Variable: "TOKEN", Outer: "SRC_ACCESS_TOKEN", Rule: "SRC_*"},
+			{Variable: "URL", Outer: "USER"},
+			{Variable: "URL", Outer: "SRC_ENDPOINT", Rule: "SRC_*"},
+			{Variable: "SELF", Outer: "SELF"},
+		}
+		if diff := cmp.Diff(e.Violations, want); diff != "" {
+			t.Errorf("unexpected violations:\n%s", diff)
+		}
+	})
+
+	t.Run("nil policy", func(t *testing.T) {
+		var p *Policy
+		if err := p.Check(Environment{vars: []variable{{name: "SRC_ACCESS_TOKEN"}}}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestPolicyError_Error(t *testing.T) {
+	for name, tc := range map[string]struct {
+		violations []PolicyViolation
+		want       string
+	}{
+		"denied": {
+			violations: []PolicyViolation{{Variable: "SRC_ACCESS_TOKEN", Outer: "SRC_ACCESS_TOKEN", Rule: "SRC_*"}},
+			want:       `environment variable "SRC_ACCESS_TOKEN" is denied by policy rule "SRC_*"`,
+		},
+		"not allowed": {
+			violations: []PolicyViolation{{Variable: "USER", Outer: "USER"}},
+			want:       `environment variable "USER" is not allowed by any policy rule`,
+		},
+		"reference": {
+			violations: []PolicyViolation{{Variable: "URL", Outer: "SRC_ENDPOINT", Rule: "SRC_*"}},
+			want:       `environment variable "URL" references "SRC_ENDPOINT", which is denied by policy rule "SRC_*"`,
+		},
+		"multiple": {
+			violations: []PolicyViolation{
+				{Variable: "A", Outer: "A", Rule: "*"},
+				{Variable: "B", Outer: "C"},
+			},
+			want: `2 environment variables violate the policy: environment variable "A" is denied by policy rule "*"; environment variable "B" references "C", which is not allowed by any policy rule`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if have := (&PolicyError{Violations: tc.violations}).Error(); have != tc.want {
+				t.Errorf("unexpected message:\nhave=%q\nwant=%q", have, tc.want)
+			}
+		})
+	}
+}
+
+func TestEnvironment_ResolvePolicy(t *testing.T) {
+	policy := newTestPolicy(t, nil, []string{"SRC_*", "AWS_SECRET_*"})
+	outer := []string{
+		"AWS_REGION=us-east-1",
+		"AWS_SECRET_ACCESS_KEY=secret",
+		"HOME=/home/me",
+		"SRC_ACCESS_TOKEN=token",
+	}
+
+	t.Run("violation", func(t *testing.T) {
+		env := Environment{vars: []variable{
+			{name: "HOME"},
+			{name: "SRC_ACCESS_TOKEN"},
+		}}.WithPolicy(policy)
+
+		lookups := 0
+		_, err := env.ResolveFrom(context.Background(), SourceFunc(func(ctx context.Context, name string) (string, bool, error) {
+			lookups++
+			return "", false, nil
+		}))
+
+		var e *PolicyError
+		if !errors.As(err, &e) {
+			t.Fatalf("unexpected error of type %T: %v", err, err)
+		}
+		if lookups != 0 {
+			t.Errorf("unexpected lookups before the policy was checked: %d", lookups)
+		}
+	})
+
+	t.Run("patterns", func(t *testing.T) {
+		env := Environment{vars: []variable{
+			{name: "AWS_*", pattern: glob.MustCompile("AWS_*")},
+			{name: "*", pattern: glob.MustCompile("*")},
+		}}.WithPolicy(policy)
+
+		have, err := env.Resolve(outer)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		want := map[string]string{"AWS_REGION": "us-east-1", "HOME": "/home/me"}
+		if diff := cmp.Diff(have, want); diff != "" {
+			t.Errorf("unexpected resolved environment:\n%s", diff)
+		}
+
+		if have, err := env.WithPolicy(nil).Resolve(outer); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if len(have) != len(outer) {
+			t.Errorf("unexpected resolved environment without policy: %v", have)
+		}
+	})
+
+	t.Run("EqualResolution", func(t *testing.T) {
+		env := Environment{vars: []variable{{name: "HOME"}}}
+
+		if !env.WithPolicy(policy).EqualResolution(env.WithPolicy(newTestPolicy(t, nil, []string{"SRC_*", "AWS_SECRET_*"}))) {
+			t.Error("environments with equivalent policies are not equal")
+		}
+		if env.WithPolicy(policy).EqualResolution(env) {
+			t.Error("environments with and without a policy are equal")
+		}
+		if env.WithPolicy(policy).EqualResolution(env.WithPolicy(newTestPolicy(t, nil, []string{"SRC_*"}))) {
+			t.Error("environments with different policies are equal")
+		}
+
+		// Policies aren't marshalled, so they don't affect Equal.
+		if !env.WithPolicy(policy).Equal(env) {
+			t.Error("environments with the same variables are not equal")
+		}
+	})
+}
@@ -0,0 +1,169 @@
+package env
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Redacted replaces the values of secret variables when a ResolvedEnvironment
+// is formatted, marshalled, or used to redact text.
+const Redacted = "[REDACTED]"
+
+// ResolvedEnvironment is an environment with all of its values resolved,
+// which knows which of those values are secret.
+//
+// The String, GoString, and MarshalJSON methods replace secret values with
+// Redacted, including where they appear within other values, such as through
+// interpolation, so a ResolvedEnvironment may be logged or cached safely. Use
+// Get or Environ to access the actual values.
+type ResolvedEnvironment struct {
+	names  []string
+	values map[string]string
+	secret map[string]bool
+
+	redactor *strings.Replacer
+}
+
+// ResolveEnvironment resolves the environment in the same way as Resolve,
+// but returns a ResolvedEnvironment that keeps track of secret variables.
+func (e Environment) ResolveEnvironment(outer []string) (*ResolvedEnvironment, error) {
+	source, err := environSource(outer)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.resolve(context.Background(), source)
+}
+
+// ResolveEnvironmentFrom resolves the environment in the same way as
+// ResolveFrom, but returns a ResolvedEnvironment that keeps track of secret
+// variables.
+func (e Environment) ResolveEnvironmentFrom(ctx context.Context, sources ...Source) (*ResolvedEnvironment, error) {
+	return e.resolve(ctx, ChainSource(sources...))
+}
+
+func (r *ResolvedEnvironment) buildRedactor() {
+	var secrets []string
+	for _, name := range r.names {
+		if value := r.values[name]; r.secret[name] && value != "" {
+			secrets = append(secrets, value)
+		}
+	}
+
+	// When secrets overlap, strings.Replacer prefers the earlier one, so we
+	// sort longer secrets first to avoid leaking part of them.
+	sort.SliceStable(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+	pairs := make([]string, 0, 2*len(secrets))
+	for _, s := range secrets {
+		pairs = append(pairs, s, Redacted)
+	}
+	r.redactor = strings.NewReplacer(pairs...)
+}
+
+// Names returns the names of the variables in the environment, in the order
+// they were declared. Variables matching a pattern are in lexicographical
+// order in place of the pattern.
+func (r *ResolvedEnvironment) Names() []string {
+	return append([]string{}, r.names...)
+}
+
+// Get returns the value of the given variable, and whether it is in the
+// environment.
+func (r *ResolvedEnvironment) Get(name string) (string, bool) {
+	value, ok := r.values[name]
+	return value, ok
+}
+
+// IsSecret returns true if the given variable is secret.
+func (r *ResolvedEnvironment) IsSecret(name string) bool {
+	return r.secret[name]
+}
+
+// Map returns the values of the environment, including secret values.
+func (r *ResolvedEnvironment) Map() map[string]string {
+	m := make(map[string]string, len(r.values))
+	for k, v := range r.values {
+		m[k] = v
+	}
+	return m
+}
+
+// Environ returns the environment as an array of strings in the form
+// `KEY=VALUE`, in declaration order, including secret values. This is the
+// form expected by exec.Cmd.
+func (r *ResolvedEnvironment) Environ() []string {
+	environ := make([]string, len(r.names))
+	for i, name := range r.names {
+		environ[i] = name + "=" + r.values[name]
+	}
+	return environ
+}
+
+// Redact replaces every occurrence of a secret value within s with Redacted.
+// Empty secret values are ignored.
+func (r *ResolvedEnvironment) Redact(s string) string {
+	if r.redactor == nil {
+		return s
+	}
+	return r.redactor.Replace(s)
+}
+
+// redacted returns the value of the given variable, or Redacted if the
+// variable is secret. Secret values within the values of other variables are
+// redacted too, since interpolation can copy them there.
+func (r *ResolvedEnvironment) redacted(name string) string {
+	if r.secret[name] {
+		return Redacted
+	}
+	return r.Redact(r.values[name])
+}
+
+// String returns the environment in the form `KEY="VALUE"`, with secret
+// values redacted.
+func (r *ResolvedEnvironment) String() string {
+	parts := make([]string, len(r.names))
+	for i, name := range r.names {
+		parts[i] = fmt.Sprintf("%s=%q", name, r.redacted(name))
+	}
+	return strings.Join(parts, " ")
+}
+
+// GoString ensures that secret values are also redacted when formatting with
+// %#v.
+func (r *ResolvedEnvironment) GoString() string {
+	return fmt.Sprintf("env.ResolvedEnvironment{%s}", r.String())
+}
+
+// MarshalJSON marshals the environment as an object, in declaration order,
+// with secret values redacted.
+func (r *ResolvedEnvironment) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, name := range r.names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		k, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(r.redacted(name))
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
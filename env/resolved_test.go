@@ -0,0 +1,177 @@
+package env
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gopkg.in/yaml.v2"
+)
+
+func TestEnvironment_ResolveEnvironment(t *testing.T) {
+	var env Environment
+	if err := yaml.Unmarshal([]byte(`
+- HOST: example.com
+- name: TOKEN
+  secret: true
+- name: PASSWORD
+  value: hunter2
+  secret: true
+- name: UNSET
+  secret: true
+- USER
+`), &env); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r, err := env.ResolveEnvironment([]string{"TOKEN=s3cr3t", "USER=alice"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("values", func(t *testing.T) {
+		want := map[string]string{
+			"HOST":     "example.com",
+			"TOKEN":    "s3cr3t",
+			"PASSWORD": "hunter2",
+			"UNSET":    "",
+			"USER":     "alice",
+		}
+		if diff := cmp.Diff(r.Map(), want); diff != "" {
+			t.Errorf("unexpected values:\n%s", diff)
+		}
+		if value, ok := r.Get("TOKEN"); !ok || value != "s3cr3t" {
+			t.Errorf("unexpected value for TOKEN: %q (%v)", value, ok)
+		}
+		if _, ok := r.Get("MISSING"); ok {
+			t.Error("unexpected value for undeclared variable")
+		}
+
+		wantEnviron := []string{"HOST=example.com", "TOKEN=s3cr3t", "PASSWORD=hunter2", "UNSET=", "USER=alice"}
+		if diff := cmp.Diff(r.Environ(), wantEnviron); diff != "" {
+			t.Errorf("unexpected environ:\n%s", diff)
+		}
+	})
+
+	t.Run("secrets", func(t *testing.T) {
+		for name, want := range map[string]bool{
+			"HOST":     false,
+			"TOKEN":    true,
+			"PASSWORD": true,
+			"UNSET":    true,
+			"USER":     false,
+		} {
+			if have := r.IsSecret(name); have != want {
+				t.Errorf("unexpected secret status for %s: have=%v want=%v", name, have, want)
+			}
+		}
+	})
+
+	t.Run("String", func(t *testing.T) {
+		want := `HOST="example.com" TOKEN="[REDACTED]" PASSWORD="[REDACTED]" UNSET="[REDACTED]" USER="alice"`
+		for _, have := range []string{
+			r.String(),
+			fmt.Sprint(r),
+			fmt.Sprintf("%+v", r),
+		} {
+			if have != want {
+				t.Errorf("unexpected string: have=%q want=%q", have, want)
+			}
+		}
+
+		if have, want := fmt.Sprintf("%#v", r), "env.ResolvedEnvironment{"+want+"}"; have != want {
+			t.Errorf("unexpected Go string: have=%q want=%q", have, want)
+		}
+	})
+
+	t.Run("MarshalJSON", func(t *testing.T) {
+		have, err := json.Marshal(r)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := `{"HOST":"example.com","TOKEN":"[REDACTED]","PASSWORD":"[REDACTED]","UNSET":"[REDACTED]","USER":"alice"}`
+		if string(have) != want {
+			t.Errorf("unexpected JSON: have=%s want=%s", have, want)
+		}
+	})
+
+	t.Run("Redact", func(t *testing.T) {
+		for in, want := range map[string]string{
+			"":                                    "",
+			"nothing to see here":                 "nothing to see here",
+			"curl -H 'Token: s3cr3t' host":        "curl -H 'Token: [REDACTED]' host",
+			"s3cr3thunter2s3cr3t":                 "[REDACTED][REDACTED][REDACTED]",
+			"alice logged in to example.com":      "alice logged in to example.com",
+			"line one: s3cr3t\nline two: hunter2": "line one: [REDACTED]\nline two: [REDACTED]",
+		} {
+			if have := r.Redact(in); have != want {
+				t.Errorf("unexpected redaction of %q: have=%q want=%q", in, have, want)
+			}
+		}
+	})
+}
+
+func TestResolvedEnvironment_RedactOverlapping(t *testing.T) {
+	env := Environment{vars: []variable{
+		{name: "SHORT", value: stringPtr("abc"), secret: true},
+		{name: "LONG", value: stringPtr("abcdef"), secret: true},
+	}}
+
+	r, err := env.ResolveEnvironment(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if have, want := r.Redact("xabcdefx abcx"), "x[REDACTED]x [REDACTED]x"; have != want {
+		t.Errorf("unexpected redaction: have=%q want=%q", have, want)
+	}
+}
+
+func TestResolvedEnvironment_RedactInterpolated(t *testing.T) {
+	var env Environment
+	if err := json.Unmarshal([]byte(`[{"name":"TOKEN","secret":true},{"URL":"https://${TOKEN}@host"}]`), &env); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r, err := env.WithInterpolation().ResolveEnvironment([]string{"TOKEN=hunter2"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value, _ := r.Get("URL"); value != "https://hunter2@host" {
+		t.Errorf("unexpected value for URL: %q", value)
+	}
+
+	if have, want := r.String(), `TOKEN="[REDACTED]" URL="https://[REDACTED]@host"`; have != want {
+		t.Errorf("unexpected string: have=%q want=%q", have, want)
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if have, want := string(data), `{"TOKEN":"[REDACTED]","URL":"https://[REDACTED]@host"}`; have != want {
+		t.Errorf("unexpected JSON: have=%s want=%s", have, want)
+	}
+}
+
+func TestEnvironment_ResolveEnvironmentError(t *testing.T) {
+	env := Environment{vars: []variable{{name: "TOKEN", required: true, secret: true}}}
+
+	if _, err := env.WithStrictResolution().ResolveEnvironment(nil); err == nil {
+		t.Error("unexpected nil error")
+	}
+}
+
+func TestEnvironment_ResolveEnvironmentFrom(t *testing.T) {
+	env := Environment{vars: []variable{{name: "TOKEN", secret: true}, {name: "HOST"}}}
+
+	r, err := env.ResolveEnvironmentFrom(context.Background(), MapSource{"TOKEN": "s3cr3t", "HOST": "example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if have, want := r.Redact("s3cr3t@example.com"), "[REDACTED]@example.com"; have != want {
+		t.Errorf("unexpected redaction: have=%q want=%q", have, want)
+	}
+}
@@ -0,0 +1,100 @@
+package env
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	yamlv3 "gopkg.in/yaml.v3"
+
+	"github.com/sourcegraph/batch-change-utils/internal/literal"
+)
+
+// scalar is a value of a variable, which may be any JSON or YAML scalar rather
+// than only a string. Scalars are coerced to strings as follows:
+//
+//   - Strings are used as is.
+//   - Numbers and booleans use their original text, so 1.10 stays 1.10 rather
+//     than becoming 1.1, and YAML forms such as 0x1F and yes are kept.
+//   - Null is an empty string. Since a nil *scalar represents null, use value
+//     to get the string.
+//
+// Maps and lists aren't scalars: use checkScalar to report them.
+type scalar string
+
+func (s *scalar) value() string {
+	if s == nil {
+		return ""
+	}
+	return string(*s)
+}
+
+func (s *scalar) UnmarshalJSON(data []byte) error {
+	if len(data) == 0 {
+		return errInvalidVariableType
+	}
+
+	switch data[0] {
+	case '"':
+		var str string
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+		*s = scalar(str)
+	case 'n':
+		*s = ""
+	case '[', '{':
+		return errInvalidVariableType
+	default:
+		// Numbers and booleans are valid JSON, and therefore already in their
+		// canonical text form.
+		*s = scalar(data)
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.v3 unmarshaller interface, since only
+// yaml.v3 gives access to the original text of the scalar. yaml.v2 already
+// unmarshals any scalar into a string using its original text.
+func (s *scalar) UnmarshalYAML(node *yamlv3.Node) error {
+	if node.Kind == yamlv3.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind != yamlv3.ScalarNode {
+		return errInvalidVariableType
+	}
+
+	if node.ShortTag() == "!!null" {
+		*s = ""
+	} else {
+		*s = scalar(node.Value)
+	}
+	return nil
+}
+
+type errNonScalarValue struct{ name, key, kind string }
+
+func (e errNonScalarValue) Error() string {
+	return fmt.Sprintf("invalid environment variable: the %s of %q must be a string, number, boolean, or null, not a %s", e.key, e.name, e.kind)
+}
+
+// checkScalar returns an errNonScalarValue if the given value, as unmarshalled
+// into an interface{} from JSON or YAML, is a map or a list. key identifies the
+// value within the variable, such as "default".
+func checkScalar(name, key string, value interface{}) error {
+	switch value.(type) {
+	case map[string]interface{}, map[interface{}]interface{}:
+		return errNonScalarValue{name: name, key: key, kind: "map"}
+	case []interface{}:
+		return errNonScalarValue{name: name, key: key, kind: "list"}
+	default:
+		return nil
+	}
+}
+
+func init() {
+	// Numbers keep their original text when an Environment is unmarshalled
+	// with yaml.UnmarshalValidate, as they do with yaml.v3.
+	literal.RegisterNumbers(reflect.TypeOf(Environment{}))
+	literal.RegisterNumbers(reflect.TypeOf(OverridableEnvironment{}))
+}
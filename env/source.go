@@ -0,0 +1,306 @@
+package env
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Source provides the values of variables that aren't statically defined in
+// an environment, such as the outer environment of the executor, or a secret
+// store.
+type Source interface {
+	// Lookup returns the value of the given variable, and whether it is set.
+	// An error should only be returned if the source cannot be queried: a
+	// variable that isn't set is not an error.
+	Lookup(ctx context.Context, name string) (value string, ok bool, err error)
+}
+
+// NameLister is implemented by sources that can list the names of the
+// variables they provide. Pattern variables, such as AWS_*, can only be
+// expanded using sources that implement it.
+type NameLister interface {
+	Names(ctx context.Context) ([]string, error)
+}
+
+// listNames returns the names provided by the source, or nil if it doesn't
+// implement NameLister.
+func listNames(ctx context.Context, source Source) ([]string, error) {
+	if l, ok := source.(NameLister); ok {
+		return l.Names(ctx)
+	}
+	return nil, nil
+}
+
+// SourceFunc adapts a function to the Source interface.
+type SourceFunc func(ctx context.Context, name string) (string, bool, error)
+
+// Lookup calls f(ctx, name).
+func (f SourceFunc) Lookup(ctx context.Context, name string) (string, bool, error) {
+	return f(ctx, name)
+}
+
+// ProcessSource returns a Source that looks variables up in the environment of
+// the current process.
+func ProcessSource() Source {
+	return processSource{}
+}
+
+type processSource struct{}
+
+func (processSource) Lookup(ctx context.Context, name string) (string, bool, error) {
+	value, ok := os.LookupEnv(name)
+	return value, ok, nil
+}
+
+func (processSource) Names(ctx context.Context) ([]string, error) {
+	environ := os.Environ()
+	names := make([]string, 0, len(environ))
+	for _, v := range environ {
+		// Windows has some special variables that start with =, such as
+		// =C:, which cannot be looked up.
+		if i := strings.IndexByte(v, '='); i > 0 {
+			names = append(names, v[:i])
+		}
+	}
+	return names, nil
+}
+
+// MapSource is a Source backed by a map of variable names to values.
+type MapSource map[string]string
+
+// Lookup returns the value of the given variable in the map.
+func (m MapSource) Lookup(ctx context.Context, name string) (string, bool, error) {
+	value, ok := m[name]
+	return value, ok, nil
+}
+
+// Names returns the keys of the map.
+func (m MapSource) Names(ctx context.Context) ([]string, error) {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// environSource converts an array of strings in the form `KEY=VALUE`, as
+// returned by os.Environ(), into a MapSource.
+func environSource(environ []string) (MapSource, error) {
+	m := make(MapSource, len(environ))
+	for _, v := range environ {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("unable to parse environment variable %q", v)
+		}
+		m[kv[0]] = kv[1]
+	}
+	return m, nil
+}
+
+// DotenvSource returns a Source that looks variables up in the dotenv file at
+// the given path, as parsed by ParseDotenv. Values can only reference
+// variables declared earlier in the file: any other reference is an
+// *UndefinedReferenceError, so that a dotenv file can't copy outer variables,
+// which the Policy of the environment being resolved wouldn't apply to.
+//
+// The file is read the first time a variable is looked up; if it cannot be
+// read, parsed, or resolved, every lookup will return the error. Errors
+// caused by the context of the lookup being cancelled aren't kept, so the
+// file is read again by the next lookup.
+func DotenvSource(path string) Source {
+	return &dotenvSource{path: path}
+}
+
+type dotenvSource struct {
+	path string
+
+	mu     sync.Mutex
+	loaded bool
+	values map[string]string
+	err    error
+}
+
+func (s *dotenvSource) Lookup(ctx context.Context, name string) (string, bool, error) {
+	if err := s.load(ctx); err != nil {
+		return "", false, err
+	}
+
+	value, ok := s.values[name]
+	return value, ok, nil
+}
+
+func (s *dotenvSource) Names(ctx context.Context) ([]string, error) {
+	if err := s.load(ctx); err != nil {
+		return nil, err
+	}
+	return MapSource(s.values).Names(ctx)
+}
+
+func (s *dotenvSource) load(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.loaded {
+		return s.err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	values, err := s.read(ctx)
+	if err != nil && ctx.Err() != nil {
+		// The error may only be due to the context, so we'll try again next
+		// time.
+		return err
+	}
+
+	s.values, s.err, s.loaded = values, err, true
+	return s.err
+}
+
+func (s *dotenvSource) read(ctx context.Context) (map[string]string, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening dotenv file")
+	}
+	defer f.Close()
+
+	values, err := resolveDotenv(ctx, f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing dotenv file %q", s.path)
+	}
+	return values, nil
+}
+
+// DirectorySource returns a Source that looks variables up in a directory
+// containing one file per variable, named after the variable, such as a
+// mounted Kubernetes secret. The contents of the file are used as the value
+// as is, including any trailing newline.
+func DirectorySource(dir string) Source {
+	return directorySource(dir)
+}
+
+type directorySource string
+
+func (dir directorySource) Lookup(ctx context.Context, name string) (string, bool, error) {
+	// Names that can't be a file within the directory can never be set, and
+	// mustn't be used to read files outside of it.
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", false, nil
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(string(dir), name))
+	if os.IsNotExist(err) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, errors.Wrapf(err, "reading environment variable %q", name)
+	}
+	return string(data), true, nil
+}
+
+// Names returns the names of the regular files in the directory, following
+// symbolic links. Hidden files, such as the ..data directory Kubernetes uses
+// to update secrets atomically, are ignored.
+func (dir directorySource) Names(ctx context.Context) ([]string, error) {
+	infos, err := ioutil.ReadDir(string(dir))
+	if err != nil {
+		return nil, errors.Wrap(err, "listing environment variables")
+	}
+
+	var names []string
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if info, err = os.Stat(filepath.Join(string(dir), info.Name())); err != nil {
+				continue
+			}
+		}
+		if info.Mode().IsRegular() {
+			names = append(names, info.Name())
+		}
+	}
+	return names, nil
+}
+
+// ChainSource returns a Source that looks each variable up in the given
+// sources in turn, returning the value from the first source in which it is
+// set. An error from any source is returned immediately.
+//
+// The returned Source implements NameLister, listing the names provided by
+// each of the given sources that do.
+func ChainSource(sources ...Source) Source {
+	return chainSource(sources)
+}
+
+type chainSource []Source
+
+func (c chainSource) Lookup(ctx context.Context, name string) (string, bool, error) {
+	for _, s := range c {
+		value, ok, err := s.Lookup(ctx, name)
+		if err != nil || ok {
+			return value, ok, err
+		}
+	}
+	return "", false, nil
+}
+
+// Names returns the names provided by each source that implements
+// NameLister, without duplicates.
+func (c chainSource) Names(ctx context.Context) ([]string, error) {
+	var names []string
+	seen := map[string]bool{}
+	for _, s := range c {
+		sn, err := listNames(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range sn {
+			if !seen[name] {
+				names = append(names, name)
+				seen[name] = true
+			}
+		}
+	}
+	return names, nil
+}
+
+// cachedSource wraps a Source so that each variable is only looked up once.
+// It is not safe for concurrent use.
+type cachedSource struct {
+	source Source
+	cache  map[string]cachedValue
+}
+
+type cachedValue struct {
+	value string
+	ok    bool
+}
+
+func newCachedSource(source Source) *cachedSource {
+	return &cachedSource{source: source, cache: make(map[string]cachedValue)}
+}
+
+func (s *cachedSource) Lookup(ctx context.Context, name string) (string, bool, error) {
+	if cv, ok := s.cache[name]; ok {
+		return cv.value, cv.ok, nil
+	}
+
+	value, ok, err := s.source.Lookup(ctx, name)
+	if err != nil {
+		return "", false, err
+	}
+	s.cache[name] = cachedValue{value: value, ok: ok}
+	return value, ok, nil
+}
+
+func (s *cachedSource) Names(ctx context.Context) ([]string, error) {
+	return listNames(ctx, s.source)
+}
@@ -0,0 +1,441 @@
+package env
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type lookupResult struct {
+	value string
+	ok    bool
+}
+
+func lookupAll(t *testing.T, s Source, names ...string) map[string]lookupResult {
+	t.Helper()
+
+	results := make(map[string]lookupResult, len(names))
+	for _, name := range names {
+		value, ok, err := s.Lookup(context.Background(), name)
+		if err != nil {
+			t.Fatalf("unexpected error looking up %q: %v", name, err)
+		}
+		results[name] = lookupResult{value: value, ok: ok}
+	}
+	return results
+}
+
+func TestMapSource(t *testing.T) {
+	have := lookupAll(t, MapSource{"FOO": "bar", "EMPTY": ""}, "FOO", "EMPTY", "MISSING")
+	want := map[string]lookupResult{
+		"FOO":     {value: "bar", ok: true},
+		"EMPTY":   {value: "", ok: true},
+		"MISSING": {},
+	}
+	if diff := cmp.Diff(have, want, cmp.AllowUnexported(lookupResult{})); diff != "" {
+		t.Errorf("unexpected lookups:\n%s", diff)
+	}
+}
+
+func TestProcessSource(t *testing.T) {
+	const name = "BATCH_CHANGE_UTILS_TEST_PROCESS_SOURCE"
+	if err := os.Setenv(name, "value"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv(name)
+
+	have := lookupAll(t, ProcessSource(), name, name+"_MISSING")
+	want := map[string]lookupResult{
+		name:              {value: "value", ok: true},
+		name + "_MISSING": {},
+	}
+	if diff := cmp.Diff(have, want, cmp.AllowUnexported(lookupResult{})); diff != "" {
+		t.Errorf("unexpected lookups:\n%s", diff)
+	}
+}
+
+func TestDotenvSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, ".env")
+	if err := ioutil.WriteFile(path, []byte("# comment\nFOO=bar\nexport QUOTED=\"a b\"\n\nEMPTY=\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("success", func(t *testing.T) {
+		have := lookupAll(t, DotenvSource(path), "FOO", "QUOTED", "EMPTY", "MISSING")
+		want := map[string]lookupResult{
+			"FOO":     {value: "bar", ok: true},
+			"QUOTED":  {value: "a b", ok: true},
+			"EMPTY":   {value: "", ok: true},
+			"MISSING": {},
+		}
+		if diff := cmp.Diff(have, want, cmp.AllowUnexported(lookupResult{})); diff != "" {
+			t.Errorf("unexpected lookups:\n%s", diff)
+		}
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		source := DotenvSource(path)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, _, err := source.Lookup(ctx, "FOO"); !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		// The cancellation isn't cached.
+		have := lookupAll(t, source, "FOO")
+		if diff := cmp.Diff(have, map[string]lookupResult{"FOO": {value: "bar", ok: true}}, cmp.AllowUnexported(lookupResult{})); diff != "" {
+			t.Errorf("unexpected lookups:\n%s", diff)
+		}
+	})
+
+	t.Run("references", func(t *testing.T) {
+		const denied = "BATCH_CHANGE_UTILS_TEST_DOTENV_DENIED"
+		if err := os.Setenv(denied, "secret"); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Unsetenv(denied)
+
+		path := filepath.Join(dir, "references.env")
+		if err := ioutil.WriteFile(path, []byte("HOST=example.com\nURL=https://${HOST}\nCOPY=${"+denied+"}\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		// The denied variable can't be read through the dotenv file, even
+		// though it's set in the process environment.
+		policy, err := NewPolicy(nil, []string{denied})
+		if err != nil {
+			t.Fatal(err)
+		}
+		env := New().MustWithPassthrough("URL").MustWithPassthrough("COPY").WithPolicy(policy)
+		_, err = env.ResolveFrom(context.Background(), DotenvSource(path))
+
+		var e *UndefinedReferenceError
+		if !errors.As(err, &e) {
+			t.Fatalf("unexpected error of type %T: %v", err, err)
+		}
+		if e.Variable != "COPY" || e.Reference != denied {
+			t.Errorf("unexpected error: %+v", e)
+		}
+
+		path = filepath.Join(dir, "earlier.env")
+		if err := ioutil.WriteFile(path, []byte("HOST=example.com\nURL=https://${HOST}\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		have, err := env.MustWithPassthrough("HOST").ResolveFrom(context.Background(), DotenvSource(path))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if diff := cmp.Diff(have, map[string]string{"URL": "https://example.com", "COPY": "", "HOST": "example.com"}); diff != "" {
+			t.Errorf("unexpected resolved environment:\n%s", diff)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, _, err := DotenvSource(filepath.Join(dir, "missing")).Lookup(context.Background(), "FOO"); err == nil {
+			t.Error("unexpected nil error")
+		}
+	})
+
+	t.Run("invalid file", func(t *testing.T) {
+		path := filepath.Join(dir, "invalid.env")
+		if err := ioutil.WriteFile(path, []byte("FOO=bar\nnot a variable\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err := DotenvSource(path).Lookup(context.Background(), "FOO"); err == nil {
+			t.Error("unexpected nil error")
+		}
+	})
+}
+
+func TestDirectorySource(t *testing.T) {
+	root, err := ioutil.TempDir("", "env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	dir := filepath.Join(root, "secrets")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		filepath.Join(dir, "TOKEN"):     "s3cr3t",
+		filepath.Join(dir, "MULTILINE"): "line one\nline two\n",
+		filepath.Join(dir, "EMPTY"):     "",
+		filepath.Join(root, "OUTSIDE"):  "outside",
+	} {
+		if err := ioutil.WriteFile(name, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	have := lookupAll(t, DirectorySource(dir), "TOKEN", "MULTILINE", "EMPTY", "MISSING", "../OUTSIDE", "..", ".", "")
+	want := map[string]lookupResult{
+		"TOKEN":      {value: "s3cr3t", ok: true},
+		"MULTILINE":  {value: "line one\nline two\n", ok: true},
+		"EMPTY":      {value: "", ok: true},
+		"MISSING":    {},
+		"../OUTSIDE": {},
+		"..":         {},
+		".":          {},
+		"":           {},
+	}
+	if diff := cmp.Diff(have, want, cmp.AllowUnexported(lookupResult{})); diff != "" {
+		t.Errorf("unexpected lookups:\n%s", diff)
+	}
+
+	t.Run("unreadable", func(t *testing.T) {
+		// A directory with the name of the variable can't be read as a file.
+		if err := os.Mkdir(filepath.Join(dir, "DIR"), 0700); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err := DirectorySource(dir).Lookup(context.Background(), "DIR"); err == nil {
+			t.Error("unexpected nil error")
+		}
+	})
+}
+
+func TestChainSource(t *testing.T) {
+	t.Run("first set wins", func(t *testing.T) {
+		s := ChainSource(
+			MapSource{"A": "first", "EMPTY": ""},
+			MapSource{"A": "second", "B": "second", "EMPTY": "second"},
+		)
+
+		have := lookupAll(t, s, "A", "B", "EMPTY", "MISSING")
+		want := map[string]lookupResult{
+			"A":       {value: "first", ok: true},
+			"B":       {value: "second", ok: true},
+			"EMPTY":   {value: "", ok: true},
+			"MISSING": {},
+		}
+		if diff := cmp.Diff(have, want, cmp.AllowUnexported(lookupResult{})); diff != "" {
+			t.Errorf("unexpected lookups:\n%s", diff)
+		}
+	})
+
+	t.Run("no sources", func(t *testing.T) {
+		have := lookupAll(t, ChainSource(), "A")
+		if diff := cmp.Diff(have, map[string]lookupResult{"A": {}}, cmp.AllowUnexported(lookupResult{})); diff != "" {
+			t.Errorf("unexpected lookups:\n%s", diff)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		want := errors.New("store unavailable")
+		s := ChainSource(
+			MapSource{"A": "a"},
+			SourceFunc(func(ctx context.Context, name string) (string, bool, error) {
+				return "", false, want
+			}),
+			MapSource{"B": "b"},
+		)
+
+		if _, _, err := s.Lookup(context.Background(), "A"); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if _, _, err := s.Lookup(context.Background(), "B"); err != want {
+			t.Errorf("unexpected error: have=%v want=%v", err, want)
+		}
+	})
+}
+
+func TestEnvironment_ResolveFrom(t *testing.T) {
+	env := Environment{vars: []variable{
+		{name: "STATIC", value: stringPtr("static")},
+		{name: "TOKEN"},
+		{name: "HOST"},
+		{name: "PORT", defaultValue: stringPtr("443")},
+		{name: "UNSET"},
+	}}
+
+	t.Run("success", func(t *testing.T) {
+		have, err := env.ResolveFrom(
+			context.Background(),
+			MapSource{"TOKEN": "from secrets", "STATIC": "ignored"},
+			MapSource{"TOKEN": "from environment", "HOST": "example.com"},
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		want := map[string]string{
+			"STATIC": "static",
+			"TOKEN":  "from secrets",
+			"HOST":   "example.com",
+			"PORT":   "443",
+			"UNSET":  "",
+		}
+		if diff := cmp.Diff(have, want); diff != "" {
+			t.Errorf("unexpected resolved environment:\n%s", diff)
+		}
+	})
+
+	t.Run("no sources", func(t *testing.T) {
+		have, err := env.ResolveFrom(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		want := map[string]string{"STATIC": "static", "TOKEN": "", "HOST": "", "PORT": "443", "UNSET": ""}
+		if diff := cmp.Diff(have, want); diff != "" {
+			t.Errorf("unexpected resolved environment:\n%s", diff)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		want := errors.New("store unavailable")
+		_, err := env.ResolveFrom(context.Background(), SourceFunc(func(ctx context.Context, name string) (string, bool, error) {
+			return "", false, want
+		}))
+		if err != want {
+			t.Errorf("unexpected error: have=%v want=%v", err, want)
+		}
+	})
+
+	t.Run("context", func(t *testing.T) {
+		type key struct{}
+		ctx := context.WithValue(context.Background(), key{}, "value")
+
+		_, err := env.ResolveFrom(ctx, SourceFunc(func(ctx context.Context, name string) (string, bool, error) {
+			if ctx.Value(key{}) != "value" {
+				t.Errorf("unexpected context for %q", name)
+			}
+			return "", false, nil
+		}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("each variable is looked up once", func(t *testing.T) {
+		env := Environment{vars: []variable{
+			{name: "TOKEN", required: true},
+			{name: "URL", value: stringPtr("https://${TOKEN}@${HOST}/${HOST}")},
+		}}.WithInterpolation().WithStrictResolution()
+
+		lookups := map[string]int{}
+		have, err := env.ResolveFrom(context.Background(), SourceFunc(func(ctx context.Context, name string) (string, bool, error) {
+			lookups[name]++
+			return MapSource{"TOKEN": "t", "HOST": "h"}.Lookup(ctx, name)
+		}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if diff := cmp.Diff(have, map[string]string{"TOKEN": "t", "URL": "https://t@h/h"}); diff != "" {
+			t.Errorf("unexpected resolved environment:\n%s", diff)
+		}
+		if diff := cmp.Diff(lookups, map[string]int{"TOKEN": 1, "HOST": 1}); diff != "" {
+			t.Errorf("unexpected lookups:\n%s", diff)
+		}
+	})
+}
+
+func TestSource_Names(t *testing.T) {
+	dir, err := ioutil.TempDir("", "env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, content := range map[string]string{
+		"TOKEN":        "s3cr3t",
+		"..2021_01_01": "",
+		".hidden":      "",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "DIR"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("TOKEN", filepath.Join(dir, "LINK")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("MISSING", filepath.Join(dir, "BROKEN")); err != nil {
+		t.Fatal(err)
+	}
+
+	dotenv := filepath.Join(dir, ".env")
+	if err := ioutil.WriteFile(dotenv, []byte("A=1\nB=2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range map[string]struct {
+		source Source
+		want   []string
+	}{
+		"map":       {source: MapSource{"A": "", "B": ""}, want: []string{"A", "B"}},
+		"dotenv":    {source: DotenvSource(dotenv), want: []string{"A", "B"}},
+		"directory": {source: DirectorySource(dir), want: []string{"LINK", "TOKEN"}},
+		"chain": {
+			source: ChainSource(
+				MapSource{"B": "", "C": ""},
+				SourceFunc(func(ctx context.Context, name string) (string, bool, error) { return "", false, nil }),
+				MapSource{"A": "", "C": ""},
+			),
+			want: []string{"A", "B", "C"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			have, err := tc.source.(NameLister).Names(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			sort.Strings(have)
+			if diff := cmp.Diff(have, tc.want); diff != "" {
+				t.Errorf("unexpected names:\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("process", func(t *testing.T) {
+		const name = "BATCH_CHANGE_UTILS_TEST_PROCESS_NAMES"
+		if err := os.Setenv(name, "=value"); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Unsetenv(name)
+
+		names, err := ProcessSource().(NameLister).Names(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		found := false
+		for _, n := range names {
+			found = found || n == name
+		}
+		if !found {
+			t.Errorf("%s not found in %v", name, names)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for name, source := range map[string]Source{
+			"missing directory":   DirectorySource(filepath.Join(dir, "missing")),
+			"missing dotenv file": DotenvSource(filepath.Join(dir, "missing.env")),
+		} {
+			t.Run(name, func(t *testing.T) {
+				if _, err := source.(NameLister).Names(context.Background()); err == nil {
+					t.Error("unexpected nil error")
+				}
+			})
+		}
+	})
+}
@@ -1,9 +1,14 @@
 package env
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 
+	"github.com/gobwas/glob"
 	"github.com/pkg/errors"
 )
 
@@ -13,6 +18,28 @@ import (
 type variable struct {
 	name  string
 	value *string
+
+	// defaultValue is used when resolving a variable without a value that
+	// isn't set in the outer environment.
+	defaultValue *string
+
+	// required variables must be set in the outer environment when the
+	// environment is resolved strictly.
+	required bool
+
+	// secret variables have their values redacted by ResolvedEnvironment.
+	secret bool
+
+	// pattern is set if the name is a glob pattern, such as AWS_*, in which
+	// case the variable passes through every matching outer variable.
+	pattern glob.Glob
+
+	// unset variables are markers that remove inherited variables when
+	// environments are merged, rather than variables themselves.
+	unset bool
+	// layer is the name of the layer the variable was declared in, if the
+	// environment was merged.
+	layer string
 }
 
 var errInvalidVariableType = errors.New("invalid environment variable: unknown type")
@@ -23,10 +50,240 @@ func (e errInvalidVariableObject) Error() string {
 	return fmt.Sprintf("invalid environment variable: incorrect number of object elements (expected 1, got %d)", e.n)
 }
 
-func (v variable) MarshalJSON() ([]byte, error) {
+type errConflictingVariableKeys struct{ a, b string }
+
+func (e errConflictingVariableKeys) Error() string {
+	return fmt.Sprintf("invalid environment variable: %q and %q cannot be used together", e.a, e.b)
+}
+
+type errInvalidPatternKey struct{ key string }
+
+func (e errInvalidPatternKey) Error() string {
+	return fmt.Sprintf("invalid environment variable: %q cannot be used with a pattern", e.key)
+}
+
+type errUnknownVariableKey struct{ key string }
+
+func (e errUnknownVariableKey) Error() string {
+	return fmt.Sprintf("invalid environment variable: unknown key %q", e.key)
+}
+
+type errInvalidVariableName struct {
+	name   string
+	index  int
+	reason string
+}
+
+func (e errInvalidVariableName) Error() string {
+	return fmt.Sprintf("invalid environment variable name %q at index %d: %s", e.name, e.index, e.reason)
+}
+
+type errDuplicateVariable struct {
+	name    string
+	indexes []int
+}
+
+func (e errDuplicateVariable) Error() string {
+	indexes := make([]string, len(e.indexes))
+	for i, index := range e.indexes {
+		indexes[i] = strconv.Itoa(index)
+	}
+	return fmt.Sprintf("duplicate environment variable %q at indexes %s", e.name, strings.Join(indexes, ", "))
+}
+
+// variableObject is the object form of a variable with attributes, such as
+// {name: FOO, default: bar}. As in {FOO: null}, an explicitly null value or
+// default is an empty string: see fromMap.
+type variableObject struct {
+	Name     string  `json:"name" yaml:"name"`
+	Value    *scalar `json:"value,omitempty" yaml:"value"`
+	Default  *scalar `json:"default,omitempty" yaml:"default"`
+	Required bool    `json:"required,omitempty" yaml:"required"`
+	Secret   bool    `json:"secret,omitempty" yaml:"secret"`
+	Unset    bool    `json:"unset,omitempty" yaml:"unset"`
+}
+
+// variableObjectKeys are the keys that may appear in a variableObject.
+var variableObjectKeys = map[string]bool{
+	"name":     true,
+	"value":    true,
+	"default":  true,
+	"required": true,
+	"secret":   true,
+	"unset":    true,
+}
+
+// isVariableObject returns true if an object with the given keys should be
+// treated as a variableObject, rather than a single name: value pair.
+func isVariableObject(keys []string) bool {
+	if len(keys) < 2 {
+		return false
+	}
+	for _, k := range keys {
+		if k == "name" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkVariableObjectKeys ensures that only known keys are present.
+func checkVariableObjectKeys(keys []string) error {
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !variableObjectKeys[k] {
+			return errUnknownVariableKey{key: k}
+		}
+	}
+	return nil
+}
+
+// isPattern returns true if the given variable name is a glob pattern. The
+// metacharacters used by glob patterns are never valid in environment variable
+// names.
+func isPattern(name string) bool {
+	return strings.ContainsAny(name, "*?[]{}")
+}
+
+// checkName returns the reason the given variable name is invalid, or an
+// empty string if it's valid. By default, names must be POSIX names, made up of
+// letters, digits, and underscores, and not starting with a digit. If relaxed
+// is true, or the name is a pattern, names only need to be non-empty and not
+// contain = or NUL, which are the only restrictions most platforms impose.
+func checkName(name string, relaxed bool) string {
+	switch {
+	case name == "":
+		return "names must not be empty"
+	case strings.ContainsAny(name, "=\x00"):
+		return "names must not contain = or NUL"
+	case relaxed || isPattern(name):
+		return ""
+	}
+
+	for i, r := range name {
+		if !(r == '_' || (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || (i > 0 && r >= '0' && r <= '9')) {
+			return "names must only contain letters, digits, and underscores, and must not start with a digit"
+		}
+	}
+	return ""
+}
+
+// fromName initialises a variable that passes through the outer variable(s)
+// with the given name or pattern.
+func (v *variable) fromName(name string) error {
+	*v = variable{name: name}
+	return v.compilePattern()
+}
+
+// fromValue initialises a variable with a static value.
+func (v *variable) fromValue(name, value string) error {
+	if isPattern(name) {
+		return errInvalidPatternKey{key: "value"}
+	}
+
+	*v = variable{name: name, value: &value}
+	return nil
+}
+
+// compilePattern compiles the name of the variable if it's a pattern.
+func (v *variable) compilePattern() error {
+	if !isPattern(v.name) {
+		return nil
+	}
+
+	compiled, err := glob.Compile(v.name)
+	if err != nil {
+		return errors.Wrapf(err, "invalid environment variable pattern %q", v.name)
+	}
+	v.pattern = compiled
+	return nil
+}
+
+func (v *variable) fromObject(o variableObject) error {
+	// Unset markers only have a name.
+	if o.Unset {
+		switch {
+		case o.Value != nil:
+			return errConflictingVariableKeys{a: "unset", b: "value"}
+		case o.Default != nil:
+			return errConflictingVariableKeys{a: "unset", b: "default"}
+		case o.Required:
+			return errConflictingVariableKeys{a: "unset", b: "required"}
+		case o.Secret:
+			return errConflictingVariableKeys{a: "unset", b: "secret"}
+		}
+	}
+
+	// Patterns pass through outer variables as is.
+	if isPattern(o.Name) {
+		switch {
+		case o.Value != nil:
+			return errInvalidPatternKey{key: "value"}
+		case o.Default != nil:
+			return errInvalidPatternKey{key: "default"}
+		case o.Required:
+			return errInvalidPatternKey{key: "required"}
+		}
+	}
+
+	// Static values are never taken from the outer environment, so neither
+	// defaults nor requirements make sense for them.
+	if o.Value != nil && o.Default != nil {
+		return errConflictingVariableKeys{a: "value", b: "default"}
+	}
+	if o.Value != nil && o.Required {
+		return errConflictingVariableKeys{a: "value", b: "required"}
+	}
+	// A default would mean the variable can never be missing.
+	if o.Default != nil && o.Required {
+		return errConflictingVariableKeys{a: "default", b: "required"}
+	}
+
+	*v = variable{
+		name:         o.Name,
+		value:        (*string)(o.Value),
+		defaultValue: (*string)(o.Default),
+		required:     o.Required,
+		secret:       o.Secret,
+		unset:        o.Unset,
+	}
+	return v.compilePattern()
+}
+
+// resolve returns the value of the variable, looking it up in the outer
+// environment if required.
+func (v variable) resolve(ctx context.Context, outer Source) (string, error) {
 	if v.value != nil {
+		return *v.value, nil
+	}
+	if value, ok, err := outer.Lookup(ctx, v.name); err != nil {
+		return "", err
+	} else if ok {
+		return value, nil
+	}
+	if v.defaultValue != nil {
+		return *v.defaultValue, nil
+	}
+
+	// If the environment variable isn't set, an empty string is the desired
+	// outcome.
+	return "", nil
+}
+
+func (v variable) MarshalJSON() ([]byte, error) {
+	if v.value != nil && !v.secret {
 		return json.Marshal(map[string]string{v.name: *v.value})
 	}
+	if v.value != nil || v.defaultValue != nil || v.required || v.secret || v.unset {
+		return json.Marshal(variableObject{
+			Name:     v.name,
+			Value:    (*scalar)(v.value),
+			Default:  (*scalar)(v.defaultValue),
+			Required: v.required,
+			Secret:   v.secret,
+			Unset:    v.unset,
+		})
+	}
 
 	return json.Marshal(v.name)
 }
@@ -36,25 +293,15 @@ func (v *variable) UnmarshalJSON(data []byte) error {
 	// case first.
 	var k string
 	if err := json.Unmarshal(data, &k); err == nil {
-		v.name = k
-		v.value = nil
-		return nil
+		return v.fromName(k)
 	}
 
 	// We should have a bouncing baby object, then.
-	var kv map[string]string
+	var kv map[string]interface{}
 	if err := json.Unmarshal(data, &kv); err != nil {
 		return errInvalidVariableType
-	} else if len(kv) != 1 {
-		return errInvalidVariableObject{n: len(kv)}
 	}
-
-	for k, value := range kv {
-		v.name = k
-		v.value = &value
-	}
-
-	return nil
+	return v.fromMap(kv, func(out interface{}) error { return json.Unmarshal(data, out) })
 }
 
 func (v *variable) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -62,38 +309,135 @@ func (v *variable) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	// case first.
 	var k string
 	if err := unmarshal(&k); err == nil {
-		v.name = k
-		v.value = nil
-		return nil
+		return v.fromName(k)
 	}
 
 	// Object time.
-	var kv map[string]string
+	var kv map[string]interface{}
 	if err := unmarshal(&kv); err != nil {
 		return errInvalidVariableType
-	} else if len(kv) != 1 {
-		return errInvalidVariableObject{n: len(kv)}
 	}
+	return v.fromMap(kv, unmarshal)
+}
+
+// fromMap initialises a variable from an object, which is either a
+// variableObject or a single name: value pair, and which has been unmarshalled
+// into kv. unmarshal unmarshals the same object into another type, so that
+// values can be converted into scalars using their original text.
+func (v *variable) fromMap(kv map[string]interface{}, unmarshal func(interface{}) error) error {
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	if isVariableObject(keys) {
+		if err := checkVariableObjectKeys(keys); err != nil {
+			return err
+		}
+		name := fmt.Sprint(kv["name"])
+		for _, key := range []string{"value", "default"} {
+			if err := checkScalar(name, key, kv[key]); err != nil {
+				return err
+			}
+		}
+
+		var o variableObject
+		if err := unmarshal(&o); err != nil {
+			return errInvalidVariableType
+		}
 
+		// Null values are unmarshalled as nil, the same as missing ones, so we
+		// use the keys to tell them apart.
+		if _, ok := kv["value"]; ok && o.Value == nil {
+			o.Value = new(scalar)
+		}
+		if _, ok := kv["default"]; ok && o.Default == nil {
+			o.Default = new(scalar)
+		}
+		return v.fromObject(o)
+	}
+
+	if len(kv) != 1 {
+		return errInvalidVariableObject{n: len(kv)}
+	}
 	for k, value := range kv {
-		v.name = k
-		v.value = &value
+		if err := checkScalar(k, "value", value); err != nil {
+			return err
+		}
+	}
+
+	var values map[string]*scalar
+	if err := unmarshal(&values); err != nil {
+		return errInvalidVariableType
+	}
+	for k, value := range values {
+		return v.fromValue(k, value.value())
 	}
 
 	return nil
 }
 
-// Equal checks if two environment variables are equal.
+// Equal checks if two environment variables are equal. The layers they were
+// declared in are ignored.
 func (a variable) Equal(b variable) bool {
-	if a.name != b.name {
-		return false
-	}
+	return a.name == b.name &&
+		stringPtrEqual(a.value, b.value) &&
+		stringPtrEqual(a.defaultValue, b.defaultValue) &&
+		a.required == b.required &&
+		a.secret == b.secret &&
+		a.unset == b.unset &&
+		(a.pattern == nil) == (b.pattern == nil)
+}
 
-	if a.value == nil && b.value == nil {
+func stringPtrEqual(a, b *string) bool {
+	if a == nil && b == nil {
 		return true
 	}
-	if a.value == nil || b.value == nil {
+	if a == nil || b == nil {
 		return false
 	}
-	return *a.value == *b.value
+	return *a == *b
+}
+
+// Variable is a read-only view of a variable declared in an Environment, as
+// returned by Environment.Lookup and Environment.Range.
+type Variable struct {
+	v variable
+}
+
+// Name returns the name of the variable, which is a pattern, such as AWS_*,
+// if IsPattern returns true.
+func (v Variable) Name() string { return v.v.name }
+
+// IsStatic returns true if the variable has a static value, and false if it's
+// passed through from the outer environment.
+func (v Variable) IsStatic() bool { return v.v.value != nil }
+
+// Value returns the static value of the variable. ok is false if the variable
+// is passed through from the outer environment.
+func (v Variable) Value() (value string, ok bool) {
+	if v.v.value == nil {
+		return "", false
+	}
+	return *v.v.value, true
+}
+
+// Default returns the value used if a pass-through variable isn't set in the
+// outer environment. ok is false if the variable has no default.
+func (v Variable) Default() (value string, ok bool) {
+	if v.v.defaultValue == nil {
+		return "", false
+	}
+	return *v.v.defaultValue, true
 }
+
+// IsRequired returns true if the variable must be set in the outer
+// environment when the environment is resolved strictly.
+func (v Variable) IsRequired() bool { return v.v.required }
+
+// IsSecret returns true if the value of the variable is redacted once
+// resolved.
+func (v Variable) IsSecret() bool { return v.v.secret }
+
+// IsPattern returns true if the variable passes through every outer variable
+// matching its name.
+func (v Variable) IsPattern() bool { return v.v.pattern != nil }
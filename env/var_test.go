@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"testing"
 
+	"github.com/gobwas/glob"
 	"github.com/google/go-cmp/cmp"
 	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
 )
 
 func TestVariable_MarshalJSON(t *testing.T) {
@@ -21,6 +23,34 @@ func TestVariable_MarshalJSON(t *testing.T) {
 			in:   variable{name: "foo", value: stringPtr("bar")},
 			want: `{"foo":"bar"}`,
 		},
+		"with default": {
+			in:   variable{name: "foo", defaultValue: stringPtr("bar")},
+			want: `{"name":"foo","default":"bar"}`,
+		},
+		"with empty default": {
+			in:   variable{name: "foo", defaultValue: stringPtr("")},
+			want: `{"name":"foo","default":""}`,
+		},
+		"required": {
+			in:   variable{name: "foo", required: true},
+			want: `{"name":"foo","required":true}`,
+		},
+		"secret": {
+			in:   variable{name: "foo", secret: true},
+			want: `{"name":"foo","secret":true}`,
+		},
+		"secret with value": {
+			in:   variable{name: "foo", value: stringPtr("bar"), secret: true},
+			want: `{"name":"foo","value":"bar","secret":true}`,
+		},
+		"pattern": {
+			in:   variable{name: "AWS_*", pattern: glob.MustCompile("AWS_*")},
+			want: `"AWS_*"`,
+		},
+		"secret pattern": {
+			in:   variable{name: "AWS_*", secret: true, pattern: glob.MustCompile("AWS_*")},
+			want: `{"name":"AWS_*","secret":true}`,
+		},
 	} {
 		t.Run(name, func(t *testing.T) {
 			have, err := json.Marshal(tc.in)
@@ -49,6 +79,42 @@ func TestVariable_UnmarshalJSON(t *testing.T) {
 				in:   `{"foo":"bar"}`,
 				want: variable{name: "foo", value: stringPtr("bar")},
 			},
+			"with value named name": {
+				in:   `{"name":"bar"}`,
+				want: variable{name: "name", value: stringPtr("bar")},
+			},
+			"pattern": {
+				in:   `"AWS_*"`,
+				want: variable{name: "AWS_*", pattern: glob.MustCompile("AWS_*")},
+			},
+			"secret pattern": {
+				in:   `{"name":"GOOGLE_{APPLICATION,CLOUD}_*","secret":true}`,
+				want: variable{name: "GOOGLE_{APPLICATION,CLOUD}_*", secret: true, pattern: glob.MustCompile("GOOGLE_{APPLICATION,CLOUD}_*")},
+			},
+			"with default": {
+				in:   `{"name":"foo","default":"bar"}`,
+				want: variable{name: "foo", defaultValue: stringPtr("bar")},
+			},
+			"required": {
+				in:   `{"name":"foo","required":true}`,
+				want: variable{name: "foo", required: true},
+			},
+			"not required": {
+				in:   `{"name":"foo","required":false}`,
+				want: variable{name: "foo"},
+			},
+			"secret": {
+				in:   `{"name":"foo","secret":true}`,
+				want: variable{name: "foo", secret: true},
+			},
+			"secret with value": {
+				in:   `{"name":"foo","value":"bar","secret":true}`,
+				want: variable{name: "foo", value: stringPtr("bar"), secret: true},
+			},
+			"value object": {
+				in:   `{"name":"foo","value":"bar"}`,
+				want: variable{name: "foo", value: stringPtr("bar")},
+			},
 		} {
 			t.Run(name, func(t *testing.T) {
 				var have variable
@@ -66,8 +132,8 @@ func TestVariable_UnmarshalJSON(t *testing.T) {
 	t.Run("failure", func(t *testing.T) {
 		t.Run("invalid types", func(t *testing.T) {
 			for name, in := range map[string]string{
-				"invalid outer type": `false`,
-				"invalid inner type": `{"foo":false}`,
+				"invalid outer type":    `false`,
+				"invalid required type": `{"name":"foo","required":"yes please"}`,
 			} {
 				t.Run(name, func(t *testing.T) {
 					var have variable
@@ -125,6 +191,22 @@ func TestVariable_UnmarshalYAML(t *testing.T) {
 				in:   `foo: bar`,
 				want: variable{name: "foo", value: stringPtr("bar")},
 			},
+			"with value named name": {
+				in:   `name: bar`,
+				want: variable{name: "name", value: stringPtr("bar")},
+			},
+			"with default": {
+				in:   "name: foo\ndefault: bar",
+				want: variable{name: "foo", defaultValue: stringPtr("bar")},
+			},
+			"required": {
+				in:   "name: foo\nrequired: true",
+				want: variable{name: "foo", required: true},
+			},
+			"secret with value": {
+				in:   "name: foo\nvalue: bar\nsecret: true",
+				want: variable{name: "foo", value: stringPtr("bar"), secret: true},
+			},
 		} {
 			t.Run(name, func(t *testing.T) {
 				var have variable
@@ -142,8 +224,8 @@ func TestVariable_UnmarshalYAML(t *testing.T) {
 	t.Run("failure", func(t *testing.T) {
 		t.Run("invalid types", func(t *testing.T) {
 			for name, in := range map[string]string{
-				"invalid outer type": `[]`,
-				"invalid inner type": `foo: []`,
+				"invalid outer type":    `[]`,
+				"invalid required type": "name: foo\nrequired: yes please",
 			} {
 				t.Run(name, func(t *testing.T) {
 					var have variable
@@ -187,4 +269,224 @@ func TestVariable_UnmarshalYAML(t *testing.T) {
 	})
 }
 
+func TestVariable_UnknownKeys(t *testing.T) {
+	for name, unmarshal := range map[string]func(*variable) error{
+		"JSON": func(v *variable) error { return json.Unmarshal([]byte(`{"name":"foo","defualt":"bar"}`), v) },
+		"YAML": func(v *variable) error { return yaml.Unmarshal([]byte("name: foo\ndefualt: bar"), v) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			var have variable
+			if err := unmarshal(&have); err == nil {
+				t.Error("unexpected nil error")
+			} else if e, ok := err.(errUnknownVariableKey); !ok {
+				t.Errorf("unexpected error of type %T: %v", err, err)
+			} else if e.key != "defualt" {
+				t.Errorf("unexpected key in the error: have=%q want=%q", e.key, "defualt")
+			}
+		})
+	}
+}
+
+func TestVariable_Patterns(t *testing.T) {
+	t.Run("YAML", func(t *testing.T) {
+		var have variable
+		if err := yaml.Unmarshal([]byte(`"AWS_?"`), &have); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if have.pattern == nil || !have.pattern.Match("AWS_A") || have.pattern.Match("AWS_AB") {
+			t.Errorf("unexpected pattern: %+v", have)
+		}
+	})
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		for name, unmarshal := range map[string]func(*variable) error{
+			"JSON":        func(v *variable) error { return json.Unmarshal([]byte(`"AWS_["`), v) },
+			"JSON object": func(v *variable) error { return json.Unmarshal([]byte(`{"name":"AWS_[","secret":true}`), v) },
+			"YAML":        func(v *variable) error { return yaml.Unmarshal([]byte(`"AWS_["`), v) },
+		} {
+			t.Run(name, func(t *testing.T) {
+				var have variable
+				if err := unmarshal(&have); err == nil {
+					t.Error("unexpected nil error")
+				}
+			})
+		}
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			in   string
+			want string
+		}{
+			"value":           {in: `{"AWS_*":"foo"}`, want: "value"},
+			"value in object": {in: `{"name":"AWS_*","value":"foo"}`, want: "value"},
+			"default":         {in: `{"name":"AWS_*","default":"foo"}`, want: "default"},
+			"required":        {in: `{"name":"AWS_*","required":true}`, want: "required"},
+		} {
+			t.Run(name, func(t *testing.T) {
+				var have variable
+				if err := json.Unmarshal([]byte(tc.in), &have); err == nil {
+					t.Error("unexpected nil error")
+				} else if e, ok := err.(errInvalidPatternKey); !ok {
+					t.Errorf("unexpected error of type %T: %v", err, err)
+				} else if e.key != tc.want {
+					t.Errorf("unexpected key in the error: have=%q want=%q", e.key, tc.want)
+				}
+			})
+		}
+	})
+}
+
+func TestVariable_ConflictingKeys(t *testing.T) {
+	for name, unmarshal := range map[string]func(*variable) error{
+		"JSON": func(v *variable) error {
+			return json.Unmarshal([]byte(`{"name":"foo","default":"bar","required":true}`), v)
+		},
+		"YAML": func(v *variable) error {
+			return yaml.Unmarshal([]byte("name: foo\ndefault: bar\nrequired: true"), v)
+		},
+		"value and default": func(v *variable) error {
+			return json.Unmarshal([]byte(`{"name":"foo","value":"bar","default":"baz"}`), v)
+		},
+		"value and required": func(v *variable) error {
+			return json.Unmarshal([]byte(`{"name":"foo","value":"bar","required":true}`), v)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var have variable
+			if err := unmarshal(&have); err == nil {
+				t.Error("unexpected nil error")
+			} else if _, ok := err.(errConflictingVariableKeys); !ok {
+				t.Errorf("unexpected error of type %T: %v", err, err)
+			}
+		})
+	}
+}
+
+func TestVariable_Scalars(t *testing.T) {
+	unmarshallers := map[string]func([]byte, interface{}) error{
+		"JSON":    json.Unmarshal,
+		"yaml.v2": yaml.Unmarshal,
+		"yaml.v3": yamlv3.Unmarshal,
+	}
+
+	// JSON is also valid YAML, so these inputs work with every unmarshaller.
+	for name, tc := range map[string]struct {
+		in   string
+		want variable
+	}{
+		"integer":          {in: `{"PORT":8080}`, want: variable{name: "PORT", value: stringPtr("8080")}},
+		"float":            {in: `{"VERSION":1.10}`, want: variable{name: "VERSION", value: stringPtr("1.10")}},
+		"exponent":         {in: `{"SIZE":1e3}`, want: variable{name: "SIZE", value: stringPtr("1e3")}},
+		"negative":         {in: `{"OFFSET":-0.50}`, want: variable{name: "OFFSET", value: stringPtr("-0.50")}},
+		"boolean":          {in: `{"DEBUG":true}`, want: variable{name: "DEBUG", value: stringPtr("true")}},
+		"null":             {in: `{"EMPTY":null}`, want: variable{name: "EMPTY", value: stringPtr("")}},
+		"quoted number":    {in: `{"PORT":"08080"}`, want: variable{name: "PORT", value: stringPtr("08080")}},
+		"value object":     {in: `{"name":"PORT","value":8080}`, want: variable{name: "PORT", value: stringPtr("8080")}},
+		"default object":   {in: `{"name":"DEBUG","default":false}`, want: variable{name: "DEBUG", defaultValue: stringPtr("false")}},
+		"null value":       {in: `{"name":"HOME","value":null}`, want: variable{name: "HOME", value: stringPtr("")}},
+		"null default":     {in: `{"name":"HOME","default":null}`, want: variable{name: "HOME", defaultValue: stringPtr("")}},
+		"null and secret":  {in: `{"name":"TOKEN","value":null,"secret":true}`, want: variable{name: "TOKEN", value: stringPtr(""), secret: true}},
+		"secret and float": {in: `{"name":"PI","value":3.14159,"secret":true}`, want: variable{name: "PI", value: stringPtr("3.14159"), secret: true}},
+	} {
+		for format, unmarshal := range unmarshallers {
+			t.Run(name+"/"+format, func(t *testing.T) {
+				var have variable
+				if err := unmarshal([]byte(tc.in), &have); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if diff := cmp.Diff(have, tc.want); diff != "" {
+					t.Errorf("unexpected value:\n%s", diff)
+				}
+			})
+		}
+	}
+
+	t.Run("YAML forms", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			in   string
+			want string
+		}{
+			"hexadecimal": {in: "A: 0x1F", want: "0x1F"},
+			"yes":         {in: "A: yes", want: "yes"},
+			"tilde":       {in: "A: ~", want: ""},
+			"empty":       {in: "A:", want: ""},
+			"infinity":    {in: "A: .inf", want: ".inf"},
+			"anchor":      {in: "A: &x 1.50", want: "1.50"},
+			// Explicit nulls in the object form match those above.
+			"tilde object": {in: "name: A\nvalue: ~", want: ""},
+			"empty object": {in: "name: A\nvalue:", want: ""},
+		} {
+			for format, unmarshal := range map[string]func([]byte, interface{}) error{
+				"yaml.v2": yaml.Unmarshal,
+				"yaml.v3": yamlv3.Unmarshal,
+			} {
+				t.Run(name+"/"+format, func(t *testing.T) {
+					var have variable
+					if err := unmarshal([]byte(tc.in), &have); err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+					if diff := cmp.Diff(have, variable{name: "A", value: &tc.want}); diff != "" {
+						t.Errorf("unexpected value:\n%s", diff)
+					}
+				})
+			}
+		}
+	})
+
+	t.Run("non-scalar values", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			in   string
+			want errNonScalarValue
+		}{
+			"list":           {in: `{"A":[]}`, want: errNonScalarValue{name: "A", key: "value", kind: "list"}},
+			"map":            {in: `{"A":{"b":"c"}}`, want: errNonScalarValue{name: "A", key: "value", kind: "map"}},
+			"value object":   {in: `{"name":"A","value":["b"]}`, want: errNonScalarValue{name: "A", key: "value", kind: "list"}},
+			"default object": {in: `{"name":"A","default":{}}`, want: errNonScalarValue{name: "A", key: "default", kind: "map"}},
+		} {
+			for format, unmarshal := range unmarshallers {
+				t.Run(name+"/"+format, func(t *testing.T) {
+					var have variable
+					if err := unmarshal([]byte(tc.in), &have); err == nil {
+						t.Error("unexpected nil error")
+					} else if e, ok := err.(errNonScalarValue); !ok {
+						t.Errorf("unexpected error of type %T: %v", err, err)
+					} else if e != tc.want {
+						t.Errorf("unexpected error: have=%+v want=%+v", e, tc.want)
+					}
+				})
+			}
+		}
+	})
+}
+
+func TestVariable_Equal(t *testing.T) {
+	for name, tc := range map[string]struct {
+		a, b variable
+		want bool
+	}{
+		"pass-through":      {variable{name: "a"}, variable{name: "a"}, true},
+		"different names":   {variable{name: "a"}, variable{name: "b"}, false},
+		"static":            {variable{name: "a", value: stringPtr("x")}, variable{name: "a", value: stringPtr("x")}, true},
+		"different values":  {variable{name: "a", value: stringPtr("x")}, variable{name: "a", value: stringPtr("y")}, false},
+		"static and not":    {variable{name: "a", value: stringPtr("x")}, variable{name: "a"}, false},
+		"defaults":          {variable{name: "a", defaultValue: stringPtr("x")}, variable{name: "a", defaultValue: stringPtr("x")}, true},
+		"different default": {variable{name: "a", defaultValue: stringPtr("x")}, variable{name: "a", defaultValue: stringPtr("y")}, false},
+		"default and not":   {variable{name: "a", defaultValue: stringPtr("x")}, variable{name: "a"}, false},
+		"required":          {variable{name: "a", required: true}, variable{name: "a", required: true}, true},
+		"required and not":  {variable{name: "a", required: true}, variable{name: "a"}, false},
+		"secret and not":    {variable{name: "a", secret: true}, variable{name: "a"}, false},
+		"pattern":           {variable{name: "a*", pattern: glob.MustCompile("a*")}, variable{name: "a*", pattern: glob.MustCompile("a*")}, true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if have := tc.a.Equal(tc.b); have != tc.want {
+				t.Errorf("unexpected equality: have=%v want=%v", have, tc.want)
+			}
+			if have := tc.b.Equal(tc.a); have != tc.want {
+				t.Errorf("unexpected reversed equality: have=%v want=%v", have, tc.want)
+			}
+		})
+	}
+}
+
 func stringPtr(s string) *string { return &s }
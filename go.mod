@@ -1,6 +1,6 @@
 module github.com/sourcegraph/batch-change-utils
 
-go 1.15
+go 1.18
 
 require (
 	github.com/ghodss/yaml v1.0.0
@@ -13,5 +13,11 @@ require (
 	gopkg.in/yaml.v3 v3.0.0-20200615113413-eeeca48fe776
 )
 
+require (
+	github.com/hashicorp/errwrap v1.0.0 // indirect
+	github.com/xeipuuv/gojsonpointer v0.0.0-20180127040702-4e3ac2762d5f // indirect
+	github.com/xeipuuv/gojsonreference v0.0.0-20180127040603-bd5ef7bd5415 // indirect
+)
+
 // See: https://github.com/ghodss/yaml/pull/65
 replace github.com/ghodss/yaml => github.com/sourcegraph/yaml v1.0.1-0.20200714132230-56936252f152
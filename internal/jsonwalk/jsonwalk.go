@@ -0,0 +1,509 @@
+// Package jsonwalk walks JSON documents alongside the Go types they are
+// unmarshalled into, so that problems found while unmarshalling can be
+// attributed to a specific value within the document.
+package jsonwalk
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Locate returns the JSON pointer to the innermost value within data that
+// cannot be unmarshalled into the corresponding part of t, along with the
+// error unmarshalling that value returned. If data can be unmarshalled into t,
+// the returned error is nil.
+//
+// Values unmarshalled by a json.Unmarshaler or encoding.TextUnmarshaler are
+// treated as opaque: if they fail, the pointer refers to the whole value.
+func Locate(data []byte, t reflect.Type) (string, error) {
+	return locate(data, t, "")
+}
+
+func locate(data json.RawMessage, t reflect.Type, pointer string) (string, error) {
+	children, err := children(data, t)
+	if err != nil {
+		return pointer, err
+	}
+	for _, c := range children {
+		if loc, err := locate(c.value, c.typ, pointer+"/"+Escape(c.token)); err != nil {
+			return loc, err
+		}
+	}
+
+	// Either the value is a leaf, or none of its children failed on their own,
+	// in which case we have to check the value as a whole.
+	if err := json.Unmarshal(data, reflect.New(t).Interface()); err != nil {
+		return pointer, err
+	}
+	return "", nil
+}
+
+// UnknownFields returns the JSON pointers to the object members within data
+// that encoding/json would ignore when unmarshalling into t, because they
+// don't correspond to a struct field.
+func UnknownFields(data []byte, t reflect.Type) ([]string, error) {
+	return unknownFields(data, t, "")
+}
+
+func unknownFields(data json.RawMessage, t reflect.Type, pointer string) ([]string, error) {
+	var unknown []string
+	if st := structType(t); st != nil && isObject(data) {
+		members, err := Members(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range members {
+			if FieldByName(st, m.Key) == nil {
+				unknown = append(unknown, pointer+"/"+Escape(m.Key))
+			}
+		}
+	}
+
+	children, err := children(data, t)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range children {
+		nested, err := unknownFields(c.value, c.typ, pointer+"/"+Escape(c.token))
+		if err != nil {
+			return nil, err
+		}
+		unknown = append(unknown, nested...)
+	}
+
+	return unknown, nil
+}
+
+// DuplicateKeys returns the JSON pointers to the object members within data
+// that have the same key as an earlier member of the same object.
+func DuplicateKeys(data []byte) ([]string, error) {
+	return duplicateKeys(data, "")
+}
+
+func duplicateKeys(data json.RawMessage, pointer string) ([]string, error) {
+	var duplicates []string
+	walk := func(token string, value json.RawMessage) error {
+		nested, err := duplicateKeys(value, pointer+"/"+Escape(token))
+		duplicates = append(duplicates, nested...)
+		return err
+	}
+
+	switch {
+	case isObject(data):
+		members, err := Members(data)
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[string]bool, len(members))
+		for _, m := range members {
+			if seen[m.Key] {
+				duplicates = append(duplicates, pointer+"/"+Escape(m.Key))
+			}
+			seen[m.Key] = true
+			if err := walk(m.Key, m.Value); err != nil {
+				return nil, err
+			}
+		}
+
+	case isArray(data):
+		var elems []json.RawMessage
+		if err := json.Unmarshal(data, &elems); err != nil {
+			return nil, err
+		}
+		for i, elem := range elems {
+			if err := walk(strconv.Itoa(i), elem); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return duplicates, nil
+}
+
+// Find returns the JSON pointers to the values within data that encoding/json
+// would unmarshal into a type for which match returns true. Values nested
+// within a matching value aren't searched.
+func Find(data []byte, t reflect.Type, match func(reflect.Type) bool) ([]string, error) {
+	return find(data, t, match, "")
+}
+
+func find(data json.RawMessage, t reflect.Type, match func(reflect.Type) bool, pointer string) ([]string, error) {
+	if match(t) {
+		return []string{pointer}, nil
+	}
+
+	children, err := children(data, t)
+	if err != nil {
+		return nil, err
+	}
+	var found []string
+	for _, c := range children {
+		nested, err := find(c.value, c.typ, match, pointer+"/"+Escape(c.token))
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, nested...)
+	}
+	return found, nil
+}
+
+// Numbers returns the JSON pointers to the numbers within data.
+func Numbers(data []byte) ([]string, error) {
+	var numbers []string
+	err := eachValue(data, "", func(pointer string, value json.RawMessage) {
+		if c := firstByte(value); c == '-' || ('0' <= c && c <= '9') {
+			numbers = append(numbers, pointer)
+		}
+	})
+	return numbers, err
+}
+
+// eachValue calls f with every value within data, including data itself, in
+// the order they appear.
+func eachValue(data json.RawMessage, pointer string, f func(pointer string, value json.RawMessage)) error {
+	f(pointer, data)
+
+	switch {
+	case isObject(data):
+		members, err := Members(data)
+		if err != nil {
+			return err
+		}
+		for _, m := range members {
+			if err := eachValue(m.Value, pointer+"/"+Escape(m.Key), f); err != nil {
+				return err
+			}
+		}
+
+	case isArray(data):
+		var elems []json.RawMessage
+		if err := json.Unmarshal(data, &elems); err != nil {
+			return err
+		}
+		for i, elem := range elems {
+			if err := eachValue(elem, pointer+"/"+strconv.Itoa(i), f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Replace returns a copy of data in which the value each JSON pointer in
+// values refers to is replaced by the corresponding JSON value. Object members
+// keep their order.
+func Replace(data []byte, values map[string]json.RawMessage) ([]byte, error) {
+	if len(values) == 0 {
+		return data, nil
+	}
+	return replace(data, "", values)
+}
+
+func replace(data json.RawMessage, pointer string, values map[string]json.RawMessage) (json.RawMessage, error) {
+	if value, ok := values[pointer]; ok {
+		return value, nil
+	}
+	if !containsNested(values, pointer) {
+		return data, nil
+	}
+
+	var buf bytes.Buffer
+	switch {
+	case isObject(data):
+		members, err := Members(data)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte('{')
+		for i, m := range members {
+			value, err := replace(m.Value, pointer+"/"+Escape(m.Key), values)
+			if err != nil {
+				return nil, err
+			}
+			key, err := json.Marshal(m.Key)
+			if err != nil {
+				return nil, err
+			}
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.Write(key)
+			buf.WriteByte(':')
+			buf.Write(value)
+		}
+		buf.WriteByte('}')
+
+	case isArray(data):
+		var elems []json.RawMessage
+		if err := json.Unmarshal(data, &elems); err != nil {
+			return nil, err
+		}
+		buf.WriteByte('[')
+		for i, elem := range elems {
+			value, err := replace(elem, pointer+"/"+strconv.Itoa(i), values)
+			if err != nil {
+				return nil, err
+			}
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.Write(value)
+		}
+		buf.WriteByte(']')
+
+	default:
+		return data, nil
+	}
+
+	return buf.Bytes(), nil
+}
+
+// containsNested returns true if any of the pointers in values refers to a
+// value nested within the value the given pointer refers to.
+func containsNested(values map[string]json.RawMessage, pointer string) bool {
+	for p := range values {
+		if strings.HasPrefix(p, pointer+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// child is a value nested directly within a JSON object or array, along with
+// the type it will be unmarshalled into.
+type child struct {
+	token string
+	value json.RawMessage
+	typ   reflect.Type
+}
+
+// children returns the values nested within data that will be unmarshalled
+// into a part of t by encoding/json. Object members that don't correspond to a
+// struct field are omitted, as are the children of opaque values and values
+// that don't match the kind of t.
+func children(data json.RawMessage, t reflect.Type) ([]child, error) {
+	t = indirect(t)
+	if t == nil {
+		return nil, nil
+	}
+
+	var children []child
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		if !isObject(data) {
+			return nil, nil
+		}
+		members, err := Members(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range members {
+			if t.Kind() == reflect.Map {
+				children = append(children, child{m.Key, m.Value, t.Elem()})
+			} else if f := FieldByName(t, m.Key); f != nil {
+				children = append(children, child{m.Key, m.Value, f.Type})
+			}
+		}
+
+	case reflect.Slice, reflect.Array:
+		// []byte is unmarshalled from a base64 string.
+		if t.Elem().Kind() == reflect.Uint8 || !isArray(data) {
+			return nil, nil
+		}
+		var elems []json.RawMessage
+		if err := json.Unmarshal(data, &elems); err != nil {
+			return nil, err
+		}
+		for i, elem := range elems {
+			children = append(children, child{strconv.Itoa(i), elem, t.Elem()})
+		}
+	}
+
+	return children, nil
+}
+
+// Member is a single member of a JSON object.
+type Member struct {
+	Key   string
+	Value json.RawMessage
+}
+
+// Members returns the members of the given JSON object in the order they
+// appear in the document, including any duplicate keys.
+func Members(data []byte) ([]Member, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+
+	var members []Member
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := tok.(string)
+
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		members = append(members, Member{Key: key, Value: value})
+	}
+
+	return members, nil
+}
+
+// FieldByName returns the struct field that encoding/json would unmarshal the
+// given object key into, or nil if there is no such field.
+func FieldByName(t reflect.Type, key string) *reflect.StructField {
+	fields := structFields(t)
+
+	// As in encoding/json, an exact match is preferred, but the match is
+	// otherwise case insensitive.
+	for i := range fields {
+		if fields[i].name == key {
+			return &fields[i].StructField
+		}
+	}
+	for i := range fields {
+		if strings.EqualFold(fields[i].name, key) {
+			return &fields[i].StructField
+		}
+	}
+	return nil
+}
+
+type field struct {
+	reflect.StructField
+	name string
+}
+
+// structFields returns the fields encoding/json considers when unmarshalling
+// into t, including the fields promoted from embedded structs.
+func structFields(t reflect.Type) []field {
+	var fields []field
+	seen := map[string]bool{}
+
+	// Embedded structs are visited breadth first, so that shallower fields
+	// take precedence over deeper ones.
+	queue := []reflect.Type{t}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for i := 0; i < current.NumField(); i++ {
+			sf := current.Field(i)
+			tag := sf.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name := strings.Split(tag, ",")[0]
+
+			if sf.Anonymous && name == "" {
+				ft := sf.Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct {
+					queue = append(queue, ft)
+					continue
+				}
+			}
+			if sf.PkgPath != "" {
+				// Unexported, non-embedded field.
+				continue
+			}
+
+			if name == "" {
+				name = sf.Name
+			}
+			if !seen[name] {
+				seen[name] = true
+				fields = append(fields, field{StructField: sf, name: name})
+			}
+		}
+	}
+
+	return fields
+}
+
+// structType returns the struct type that values of type t are unmarshalled
+// into, or nil if t isn't a struct or pointer to one.
+func structType(t reflect.Type) reflect.Type {
+	if t = indirect(t); t != nil && t.Kind() == reflect.Struct {
+		return t
+	}
+	return nil
+}
+
+// indirect dereferences pointer types, returning nil if t is opaque.
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr && !opaque(t) {
+		t = t.Elem()
+	}
+	if opaque(t) {
+		return nil
+	}
+	return t
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// opaque returns true if the structure of values of type t cannot be inferred
+// from the type itself.
+func opaque(t reflect.Type) bool {
+	if t.Kind() == reflect.Interface {
+		return true
+	}
+
+	for _, u := range []reflect.Type{jsonUnmarshalerType, textUnmarshalerType} {
+		if t.Implements(u) || reflect.PtrTo(t).Implements(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// Escape escapes a single reference token within a JSON pointer.
+func Escape(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
+
+// Path formats a JSON pointer as a dotted path, such as "steps.0.run", for
+// presentation to users.
+func Path(pointer string) string {
+	return strings.Join(Split(pointer), ".")
+}
+
+// Split splits a JSON pointer into its unescaped reference tokens.
+func Split(pointer string) []string {
+	if pointer == "" {
+		return nil
+	}
+
+	unescaper := strings.NewReplacer("~1", "/", "~0", "~")
+	tokens := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	for i, token := range tokens {
+		tokens[i] = unescaper.Replace(token)
+	}
+	return tokens
+}
+
+func isObject(data []byte) bool { return firstByte(data) == '{' }
+func isArray(data []byte) bool  { return firstByte(data) == '[' }
+
+func firstByte(data []byte) byte {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return 0
+	}
+	return data[0]
+}
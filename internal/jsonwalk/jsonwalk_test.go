@@ -0,0 +1,194 @@
+package jsonwalk
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type opaqueValue struct{}
+
+func (*opaqueValue) UnmarshalJSON(data []byte) error {
+	if string(data) != "true" {
+		return errors.New("not true")
+	}
+	return nil
+}
+
+type Embedded struct {
+	E string
+}
+
+type target struct {
+	Embedded
+	A       string            `json:"a"`
+	B       []int             `json:"b"`
+	C       map[string]*inner `json:"c,omitempty"`
+	O       opaqueValue       `json:"o"`
+	Ignored string            `json:"-"`
+}
+
+type inner struct {
+	D bool
+}
+
+func TestLocate(t *testing.T) {
+	typ := reflect.TypeOf(target{})
+
+	for name, tc := range map[string]struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		"string for int":       {in: `{"b": [1, "2"]}`, want: "/b/1", wantErr: true},
+		"object for array":     {in: `{"b": {}}`, want: "/b", wantErr: true},
+		"nested map value":     {in: `{"c": {"x/y": {"D": true}, "z": {"d": 1}}}`, want: "/c/z/d", wantErr: true},
+		"opaque":               {in: `{"o": false}`, want: "/o", wantErr: true},
+		"embedded":             {in: `{"E": 1}`, want: "/E", wantErr: true},
+		"case insensitive":     {in: `{"A": 1}`, want: "/A", wantErr: true},
+		"root":                 {in: `[]`, want: "", wantErr: true},
+		"last duplicate fails": {in: `{"a": "x", "a": 1}`, want: "/a", wantErr: true},
+		"unknown field":        {in: `{"x": 1}`},
+		"null pointer in map":  {in: `{"c": {"x": null}}`},
+		"ignored field":        {in: `{"Ignored": 1}`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			have, err := Locate([]byte(tc.in), typ)
+			if tc.wantErr && err == nil {
+				t.Error("unexpected nil error")
+			} else if !tc.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if have != tc.want {
+				t.Errorf("unexpected pointer: have=%q want=%q", have, tc.want)
+			}
+		})
+	}
+}
+
+func TestUnknownFields(t *testing.T) {
+	have, err := UnknownFields([]byte(`{
+		"a": "x",
+		"x": 1,
+		"E": "y",
+		"Ignored": "z",
+		"c": {"k/1": {"D": true, "e": false}, "k2": null},
+		"o": {"p": 1},
+		"b": [1]
+	}`), reflect.TypeOf(&target{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"/x", "/Ignored", "/c/k~11/e"}
+	if diff := cmp.Diff(have, want); diff != "" {
+		t.Errorf("unexpected unknown fields:\n%s", diff)
+	}
+}
+
+func TestDuplicateKeys(t *testing.T) {
+	have, err := DuplicateKeys([]byte(`{
+		"a": 1,
+		"b": [{"c": 1, "c": 2}, {"c": 3}],
+		"a": {"d": {"e": 1, "e": 2, "e": 3}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"/b/0/c", "/a", "/a/d/e", "/a/d/e"}
+	if diff := cmp.Diff(have, want); diff != "" {
+		t.Errorf("unexpected duplicate keys:\n%s", diff)
+	}
+}
+
+func TestFind(t *testing.T) {
+	data := []byte(`{"a": "x", "c": {"k": {"D": true}, "l": null}, "o": true, "b": [1]}`)
+	have, err := Find(data, reflect.TypeOf(target{}), func(t reflect.Type) bool {
+		return t == reflect.TypeOf(&inner{}) || t == reflect.TypeOf(opaqueValue{})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"/c/k", "/c/l", "/o"}
+	if diff := cmp.Diff(have, want); diff != "" {
+		t.Errorf("unexpected pointers:\n%s", diff)
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	have, err := Numbers([]byte(`{"a": 1, "b": ["2", -3.5, {"c": 4e2, "d": true}], "e": null}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"/a", "/b/1", "/b/2/c"}
+	if diff := cmp.Diff(have, want); diff != "" {
+		t.Errorf("unexpected pointers:\n%s", diff)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	data := []byte(`{"z": 1, "a": [1, {"b": 2, "c": 3}], "m": {"x": 1}}`)
+	have, err := Replace(data, map[string]json.RawMessage{
+		"/z":     json.RawMessage(`"1"`),
+		"/a/1/c": json.RawMessage(`"three"`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Values without replacements within them are kept as is.
+	if want := `{"z":"1","a":[1,{"b":2,"c":"three"}],"m":{"x": 1}}`; string(have) != want {
+		t.Errorf("unexpected JSON: have=%s want=%s", have, want)
+	}
+
+	if have, err := Replace(data, nil); err != nil || string(have) != string(data) {
+		t.Errorf("unexpected result without replacements: %s (%v)", have, err)
+	}
+}
+
+func TestMembers(t *testing.T) {
+	have, err := Members([]byte(`{"b": 1, "a": {"c": [2]}, "b": "x"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Member{
+		{Key: "b", Value: json.RawMessage(`1`)},
+		{Key: "a", Value: json.RawMessage(`{"c": [2]}`)},
+		{Key: "b", Value: json.RawMessage(`"x"`)},
+	}
+	if diff := cmp.Diff(have, want); diff != "" {
+		t.Errorf("unexpected members:\n%s", diff)
+	}
+
+	if _, err := Members([]byte(`{"a": `)); err == nil {
+		t.Error("unexpected nil error")
+	}
+}
+
+func TestPointers(t *testing.T) {
+	for token, want := range map[string]string{
+		"a":     "a",
+		"a/b":   "a~1b",
+		"a~b":   "a~0b",
+		"~1":    "~01",
+		"a/~/b": "a~1~0~1b",
+	} {
+		if have := Escape(token); have != want {
+			t.Errorf("unexpected escaped token: have=%q want=%q", have, want)
+		}
+		if have := Split("/x/" + want); !cmp.Equal(have, []string{"x", token}) {
+			t.Errorf("unexpected tokens: have=%q want=%q", have, []string{"x", token})
+		}
+	}
+
+	if have := Split(""); have != nil {
+		t.Errorf("unexpected tokens for the root pointer: %q", have)
+	}
+}
@@ -0,0 +1,37 @@
+// Package literal records the types within which the yaml package keeps the
+// original text of numbers, without them having to implement an exported
+// interface.
+package literal
+
+import (
+	"reflect"
+	"sync"
+)
+
+var (
+	mu    sync.RWMutex
+	types = map[reflect.Type]struct{}{}
+)
+
+// RegisterNumbers records that numbers within YAML values of type t, or of
+// pointers to t, should keep the text they were written with, rather than be
+// normalized, when unmarshalled by the yaml package. It's intended to be
+// called from init functions.
+func RegisterNumbers(t reflect.Type) {
+	mu.Lock()
+	defer mu.Unlock()
+	types[t] = struct{}{}
+}
+
+// Numbers returns true if numbers within values of type t keep their text, as
+// registered with RegisterNumbers.
+func Numbers(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	_, ok := types[t]
+	return ok
+}
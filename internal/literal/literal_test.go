@@ -0,0 +1,30 @@
+package literal
+
+import (
+	"reflect"
+	"testing"
+)
+
+type registered struct{}
+
+type unregistered struct{}
+
+func TestNumbers(t *testing.T) {
+	RegisterNumbers(reflect.TypeOf(registered{}))
+
+	for name, tc := range map[string]struct {
+		t    reflect.Type
+		want bool
+	}{
+		"registered":            {t: reflect.TypeOf(registered{}), want: true},
+		"pointer to registered": {t: reflect.TypeOf(&registered{}), want: true},
+		"unregistered":          {t: reflect.TypeOf(unregistered{}), want: false},
+		"builtin":               {t: reflect.TypeOf(""), want: false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if have := Numbers(tc.t); have != tc.want {
+				t.Errorf("unexpected result: have=%v want=%v", have, tc.want)
+			}
+		})
+	}
+}
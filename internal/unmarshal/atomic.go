@@ -0,0 +1,108 @@
+// Package unmarshal provides unmarshalling helpers shared by the json and yaml
+// packages.
+package unmarshal
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// Atomic unmarshals the JSON input into the target, leaving the target
+// untouched if an error occurs.
+//
+// json.Unmarshal merges into any values already in the target, so the input
+// is unmarshalled into a deep copy of the target, which replaces the target
+// only on success. Values that are only reachable through unexported fields
+// aren't copied, so a custom unmarshaller that modifies them in place can
+// still affect the target.
+func Atomic(input []byte, target interface{}) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		// Let json.Unmarshal report the invalid target.
+		return json.Unmarshal(input, target)
+	}
+
+	scratch := reflect.New(v.Elem().Type())
+	deepCopy(scratch.Elem(), v.Elem(), map[pointer]reflect.Value{})
+	if err := json.Unmarshal(input, scratch.Interface()); err != nil {
+		return err
+	}
+
+	v.Elem().Set(scratch.Elem())
+	return nil
+}
+
+// pointer identifies a pointer that has already been copied, so that cycles
+// and shared values are preserved in the copy.
+type pointer struct {
+	typ  reflect.Type
+	addr uintptr
+}
+
+// deepCopy copies src into dst, which must be settable, recursing into every
+// pointer, interface, slice, and map json.Unmarshal can modify in place.
+func deepCopy(dst, src reflect.Value, seen map[pointer]reflect.Value) {
+	switch src.Kind() {
+	case reflect.Ptr:
+		if src.IsNil() {
+			return
+		}
+		key := pointer{typ: src.Type(), addr: src.Pointer()}
+		if p, ok := seen[key]; ok {
+			dst.Set(p)
+			return
+		}
+		p := reflect.New(src.Type().Elem())
+		seen[key] = p
+		deepCopy(p.Elem(), src.Elem(), seen)
+		dst.Set(p)
+
+	case reflect.Interface:
+		if src.IsNil() {
+			return
+		}
+		e := reflect.New(src.Elem().Type()).Elem()
+		deepCopy(e, src.Elem(), seen)
+		dst.Set(e)
+
+	case reflect.Slice:
+		if src.IsNil() {
+			return
+		}
+		s := reflect.MakeSlice(src.Type(), src.Len(), src.Cap())
+		for i := 0; i < src.Len(); i++ {
+			deepCopy(s.Index(i), src.Index(i), seen)
+		}
+		dst.Set(s)
+
+	case reflect.Map:
+		if src.IsNil() {
+			return
+		}
+		m := reflect.MakeMapWithSize(src.Type(), src.Len())
+		for iter := src.MapRange(); iter.Next(); {
+			e := reflect.New(src.Type().Elem()).Elem()
+			deepCopy(e, iter.Value(), seen)
+			m.SetMapIndex(iter.Key(), e)
+		}
+		dst.Set(m)
+
+	case reflect.Array:
+		for i := 0; i < src.Len(); i++ {
+			deepCopy(dst.Index(i), src.Index(i), seen)
+		}
+
+	case reflect.Struct:
+		// Copying the struct as a whole takes care of unexported fields, which
+		// json.Unmarshal can't set. Exported fields are then copied deeply.
+		dst.Set(src)
+		for i := 0; i < src.NumField(); i++ {
+			if dst.Field(i).CanSet() {
+				deepCopy(dst.Field(i), src.Field(i), seen)
+			}
+		}
+
+	default:
+		dst.Set(src)
+	}
+}
@@ -0,0 +1,100 @@
+package unmarshal
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type inner struct {
+	N int
+	S string
+}
+
+type target struct {
+	A string
+	X interface{}
+	P *inner
+	L []inner
+	M map[string]*inner
+	R [2]*inner
+}
+
+func newTarget() target {
+	return target{
+		A: "orig",
+		X: &inner{N: 1},
+		P: &inner{N: 2},
+		L: []inner{{N: 3}},
+		M: map[string]*inner{"a": {N: 4}},
+		R: [2]*inner{{N: 5}},
+	}
+}
+
+func TestAtomic(t *testing.T) {
+	for name, input := range map[string]string{
+		"interface":    `{"A":"new","X":{"S":"new","N":"str"}}`,
+		"pointer":      `{"A":"new","P":{"S":"new","N":"str"}}`,
+		"slice":        `{"A":"new","L":[{"S":"new","N":"str"}]}`,
+		"map":          `{"A":"new","M":{"a":{"S":"new"},"b":{"N":"str"}}}`,
+		"array":        `{"A":"new","R":[{"S":"new","N":"str"}]}`,
+		"invalid JSON": `{"A":"new",`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			have := newTarget()
+			if err := Atomic([]byte(input), &have); err == nil {
+				t.Fatal("unexpected nil error")
+			}
+			if diff := cmp.Diff(have, newTarget()); diff != "" {
+				t.Errorf("target was modified:\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("success", func(t *testing.T) {
+		have := newTarget()
+		x, p := have.X, have.P
+		if err := Atomic([]byte(`{"A":"new","X":{"S":"x"},"M":{"b":{"N":6}}}`), &have); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// Values are merged into the existing target, as with json.Unmarshal.
+		want := newTarget()
+		want.A = "new"
+		want.X = &inner{N: 1, S: "x"}
+		want.M["b"] = &inner{N: 6}
+		if diff := cmp.Diff(have, want); diff != "" {
+			t.Errorf("unexpected target:\n%s", diff)
+		}
+
+		// The original values aren't modified in place.
+		if diff := cmp.Diff(x, &inner{N: 1}); diff != "" {
+			t.Errorf("original interface value was modified:\n%s", diff)
+		}
+		if have.P == p {
+			t.Error("pointer was not copied")
+		}
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		type node struct {
+			Name string
+			Next *node
+		}
+		have := &node{Name: "a"}
+		have.Next = have
+
+		if err := Atomic([]byte(`{"Name":"b"}`), have); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if have.Name != "b" || have.Next.Next != have.Next {
+			t.Errorf("unexpected target: %+v", have)
+		}
+	})
+
+	t.Run("invalid target", func(t *testing.T) {
+		if err := Atomic([]byte(`{}`), target{}); err == nil {
+			t.Error("unexpected nil error")
+		}
+	})
+}
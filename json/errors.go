@@ -0,0 +1,29 @@
+package json
+
+import (
+	"fmt"
+
+	"github.com/sourcegraph/batch-change-utils/internal/jsonwalk"
+)
+
+// UnknownFieldError is returned in strict mode for each object member that
+// doesn't correspond to a field in the target.
+type UnknownFieldError struct {
+	// Pointer is the JSON pointer to the unknown member.
+	Pointer string
+}
+
+func (e *UnknownFieldError) Error() string {
+	return fmt.Sprintf("unknown field %q", jsonwalk.Path(e.Pointer))
+}
+
+// DuplicateKeyError is returned in strict mode for each object member that
+// has the same key as an earlier member of the same object.
+type DuplicateKeyError struct {
+	// Pointer is the JSON pointer to the duplicated member.
+	Pointer string
+}
+
+func (e *DuplicateKeyError) Error() string {
+	return fmt.Sprintf("duplicate key %q", jsonwalk.Path(e.Pointer))
+}
@@ -2,24 +2,106 @@ package json
 
 import (
 	"encoding/json"
+	"reflect"
 
 	"github.com/hashicorp/go-multierror"
 
+	"github.com/sourcegraph/batch-change-utils/internal/jsonwalk"
+	"github.com/sourcegraph/batch-change-utils/internal/unmarshal"
 	"github.com/sourcegraph/batch-change-utils/jsonschema"
 )
 
+// Options configures the behaviour of UnmarshalValidateWithOptions.
+type Options struct {
+	// Strict causes object members that don't correspond to a field in the
+	// target to be returned as UnknownFieldErrors, and members that repeat an
+	// earlier key in the same object to be returned as DuplicateKeyErrors.
+	// Otherwise, unknown members are ignored and the last duplicate wins.
+	Strict bool
+
+	// Atomic causes the target to be left untouched unless the input is valid
+	// and can be unmarshalled in its entirety. If validation fails, only the
+	// validation errors are returned. Otherwise, all errors are collected and
+	// the target may be partially modified.
+	Atomic bool
+}
+
 // UnmarshalValidate validates the JSON input against the provided JSON schema.
 // If the validation is successful the validated input is unmarshalled into the
 // target.
+//
+// The schema is compiled on every call: use UnmarshalValidateSchema with a
+// schema compiled by jsonschema.Compile when validating more than one input.
 func UnmarshalValidate(schema string, input []byte, target interface{}) error {
-	var errs *multierror.Error
-	if err := jsonschema.Validate(schema, input); err != nil {
+	sc, err := jsonschema.Compile(schema)
+	if err != nil {
+		return err
+	}
+
+	return UnmarshalValidateSchema(sc, input, target)
+}
+
+// UnmarshalValidateSchema validates the JSON input against the provided
+// compiled JSON schema. If the validation is successful the validated input is
+// unmarshalled into the target.
+//
+// Schema violations are returned as a jsonschema.ValidationErrors, which can
+// be retrieved from the returned error with errors.As.
+func UnmarshalValidateSchema(schema *jsonschema.Schema, input []byte, target interface{}) error {
+	return UnmarshalValidateWithOptions(schema, input, target, Options{})
+}
+
+// UnmarshalValidateWithOptions behaves like UnmarshalValidateSchema, with
+// additional checks configured by opts.
+func UnmarshalValidateWithOptions(schema *jsonschema.Schema, input []byte, target interface{}, opts Options) error {
+	errs := &multierror.Error{ErrorFormat: jsonschema.ListFormatFunc}
+	if err := schema.Validate(input); err != nil {
 		errs = multierror.Append(errs, err)
 	}
 
+	if opts.Strict {
+		errs = multierror.Append(errs, strictErrors(input, target)...)
+	}
+
+	if opts.Atomic {
+		if errs.ErrorOrNil() != nil {
+			return errs
+		}
+		if err := unmarshal.Atomic(input, target); err != nil {
+			return multierror.Append(errs, err)
+		}
+		return nil
+	}
+
 	if err := json.Unmarshal(input, target); err != nil {
 		errs = multierror.Append(errs, err)
 	}
 
 	return errs.ErrorOrNil()
 }
+
+// strictErrors returns the errors for unknown and duplicated keys in the
+// input. Malformed input is left for json.Unmarshal to report.
+func strictErrors(input []byte, target interface{}) []error {
+	var errs []error
+
+	duplicates, err := jsonwalk.DuplicateKeys(input)
+	if err != nil {
+		return nil
+	}
+	for _, pointer := range duplicates {
+		errs = append(errs, &DuplicateKeyError{Pointer: pointer})
+	}
+
+	if t := reflect.TypeOf(target); t != nil && t.Kind() == reflect.Ptr {
+		unknown, err := jsonwalk.UnknownFields(input, t.Elem())
+		if err != nil {
+			return nil
+		}
+		for _, pointer := range unknown {
+			errs = append(errs, &UnknownFieldError{Pointer: pointer})
+		}
+	}
+
+	return errs
+}
@@ -1,28 +1,32 @@
 package json
 
 import (
+	"errors"
 	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/hashicorp/go-multierror"
+
+	"github.com/sourcegraph/batch-change-utils/jsonschema"
 )
 
-func TestUnmarshalValidate(t *testing.T) {
-	type targetType struct {
-		A string
-		B int
-	}
+type targetType struct {
+	A string
+	B int
+}
 
-	schema := `{
-        "$schema": "http://json-schema.org/draft-07/schema#",
-        "$id": "https://github.com/sourcegraph/batch-change-utils/schema/test.schema.json",
-        "type": "object",
-        "properties": {
-            "a": { "type": "string" },
-            "b": { "type": "integer" }
-        }
-    }`
+const schema = `{
+    "$schema": "http://json-schema.org/draft-07/schema#",
+    "$id": "https://github.com/sourcegraph/batch-change-utils/schema/test.schema.json",
+    "type": "object",
+    "properties": {
+        "a": { "type": "string" },
+        "b": { "type": "integer" }
+    }
+}`
 
+func TestUnmarshalValidate(t *testing.T) {
 	t.Run("bad schema", func(t *testing.T) {
 		var target targetType
 		if err := UnmarshalValidate("{", []byte(""), &target); err == nil {
@@ -54,3 +58,233 @@ func TestUnmarshalValidate(t *testing.T) {
 		}
 	})
 }
+
+func TestUnmarshalValidateSchema(t *testing.T) {
+	sc, err := jsonschema.Compile(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("invalid input", func(t *testing.T) {
+		var target targetType
+		if err := UnmarshalValidateSchema(sc, []byte(`{"b": "bar"}`), &target); err == nil {
+			t.Error("unexpected nil error")
+		} else if !strings.Contains(err.Error(), "Invalid type") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("validation errors", func(t *testing.T) {
+		var target targetType
+		err := UnmarshalValidateSchema(sc, []byte(`{"b": "bar"}`), &target)
+
+		var errs jsonschema.ValidationErrors
+		if !errors.As(err, &errs) {
+			t.Fatalf("unexpected error of type %T: %v", err, err)
+		}
+		if len(errs) != 1 {
+			t.Fatalf("unexpected number of validation errors: %d", len(errs))
+		}
+		if have, want := errs[0].Pointer, "/b"; have != want {
+			t.Errorf("unexpected pointer: have=%q want=%q", have, want)
+		}
+		if have, want := errs[0].Keyword, "type"; have != want {
+			t.Errorf("unexpected keyword: have=%q want=%q", have, want)
+		}
+
+		// The validation errors must be listed alongside the unmarshalling
+		// error, rather than nested.
+		if !strings.HasPrefix(err.Error(), "2 errors occurred:\n\t* b: Invalid type. Expected: integer, given: string\n\t* ") {
+			t.Errorf("unexpected error: %q", err.Error())
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			var target targetType
+			if err := UnmarshalValidateSchema(sc, []byte(`{"a": "hello", "b": 42}`), &target); err != nil {
+				t.Errorf("unexpected non-nil error: %v", err)
+			}
+
+			if diff := cmp.Diff(target, targetType{"hello", 42}); diff != "" {
+				t.Errorf("unexpected target value:\n%s", diff)
+			}
+		}
+	})
+}
+
+func TestUnmarshalValidateWithOptions(t *testing.T) {
+	sc, err := jsonschema.Compile(`{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type nested struct {
+		C bool `json:"c"`
+	}
+	type strictTarget struct {
+		A string   `json:"a"`
+		N []nested `json:"n"`
+	}
+
+	input := []byte(`{"a": "x", "b": 1, "n": [{"c": true, "c": false, "d": 1}], "a": "y"}`)
+
+	t.Run("not strict", func(t *testing.T) {
+		var target strictTarget
+		if err := UnmarshalValidateWithOptions(sc, input, &target, Options{}); err != nil {
+			t.Errorf("unexpected non-nil error: %v", err)
+		}
+		if diff := cmp.Diff(target, strictTarget{A: "y", N: []nested{{C: false}}}); diff != "" {
+			t.Errorf("unexpected target value:\n%s", diff)
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		var target strictTarget
+		err := UnmarshalValidateWithOptions(sc, input, &target, Options{Strict: true})
+		if err == nil {
+			t.Fatal("unexpected nil error")
+		}
+
+		var have []string
+		for _, e := range err.(*multierror.Error).Errors {
+			switch e := e.(type) {
+			case *UnknownFieldError:
+				have = append(have, "unknown "+e.Pointer)
+			case *DuplicateKeyError:
+				have = append(have, "duplicate "+e.Pointer)
+			default:
+				t.Errorf("unexpected error of type %T: %v", e, e)
+			}
+		}
+		want := []string{"duplicate /n/0/c", "duplicate /a", "unknown /b", "unknown /n/0/d"}
+		if diff := cmp.Diff(have, want); diff != "" {
+			t.Errorf("unexpected errors:\n%s", diff)
+		}
+
+		for _, want := range []string{`unknown field "n.0.d"`, `duplicate key "n.0.c"`} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("error does not contain %q: %v", want, err)
+			}
+		}
+	})
+
+	t.Run("strict success", func(t *testing.T) {
+		var target strictTarget
+		if err := UnmarshalValidateWithOptions(sc, []byte(`{"a": "x", "n": [{"c": true}]}`), &target, Options{Strict: true}); err != nil {
+			t.Errorf("unexpected non-nil error: %v", err)
+		}
+	})
+
+	t.Run("strict invalid JSON", func(t *testing.T) {
+		var target strictTarget
+		if err := UnmarshalValidateWithOptions(sc, []byte(`{"a": `), &target, Options{Strict: true}); err == nil {
+			t.Error("unexpected nil error")
+		}
+	})
+}
+
+func TestUnmarshalValidateAtomic(t *testing.T) {
+	sc, err := jsonschema.Compile(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original := targetType{A: "original", B: 1}
+
+	t.Run("validation errors", func(t *testing.T) {
+		input := []byte(`{"a": 1, "b": "x"}`)
+
+		target := original
+		err := UnmarshalValidateWithOptions(sc, input, &target, Options{Atomic: true})
+		if err == nil {
+			t.Fatal("unexpected nil error")
+		}
+		if diff := cmp.Diff(target, original); diff != "" {
+			t.Errorf("target was modified:\n%s", diff)
+		}
+		if have := len(err.(*multierror.Error).Errors); have != 1 {
+			t.Errorf("unexpected number of errors: have=%d want=1: %v", have, err)
+		}
+
+		// Without Atomic, the unmarshalling errors are returned too, and the
+		// target is partially modified.
+		target = original
+		err = UnmarshalValidateWithOptions(sc, input, &target, Options{})
+		if have := len(err.(*multierror.Error).Errors); have != 2 {
+			t.Errorf("unexpected number of errors: have=%d want=2: %v", have, err)
+		}
+	})
+
+	t.Run("unmarshalling errors", func(t *testing.T) {
+		sc, err := jsonschema.Compile(`{}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		target := original
+		if err := UnmarshalValidateWithOptions(sc, []byte(`{"a": "z", "b": 1.5}`), &target, Options{Atomic: true}); err == nil {
+			t.Fatal("unexpected nil error")
+		}
+		if diff := cmp.Diff(target, original); diff != "" {
+			t.Errorf("target was modified:\n%s", diff)
+		}
+	})
+
+	t.Run("values merged in place", func(t *testing.T) {
+		sc, err := jsonschema.Compile(`{}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		type inner struct{ N int }
+		type withInterface struct {
+			A string
+			X interface{}
+		}
+
+		target := withInterface{A: "orig", X: &inner{}}
+		if err := UnmarshalValidateWithOptions(sc, []byte(`{"A":"new","X":{"N":"str"}}`), &target, Options{Atomic: true}); err == nil {
+			t.Fatal("unexpected nil error")
+		}
+		if diff := cmp.Diff(target, withInterface{A: "orig", X: &inner{}}); diff != "" {
+			t.Errorf("target was modified:\n%s", diff)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		target := original
+		if err := UnmarshalValidateWithOptions(sc, []byte(`{"b": 2}`), &target, Options{Atomic: true}); err != nil {
+			t.Fatalf("unexpected non-nil error: %v", err)
+		}
+		if diff := cmp.Diff(target, targetType{A: "original", B: 2}); diff != "" {
+			t.Errorf("unexpected target value:\n%s", diff)
+		}
+	})
+}
+
+func BenchmarkUnmarshalValidate(b *testing.B) {
+	input := []byte(`{"a": "hello", "b": 42}`)
+	for i := 0; i < b.N; i++ {
+		var target targetType
+		if err := UnmarshalValidate(schema, input, &target); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalValidateSchema(b *testing.B) {
+	sc, err := jsonschema.Compile(schema)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	input := []byte(`{"a": "hello", "b": 42}`)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var target targetType
+		if err := UnmarshalValidateSchema(sc, input, &target); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package jsonschema
+
+import (
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/xeipuuv/gojsonschema"
+
+	"github.com/sourcegraph/batch-change-utils/internal/jsonwalk"
+)
+
+// ValidationError is a single violation of a JSON schema.
+type ValidationError struct {
+	// Pointer is the JSON pointer (RFC 6901) to the value that failed
+	// validation. The root value is represented by the empty string.
+	Pointer string
+	// Keyword is the JSON schema keyword that failed validation, such as
+	// "required", "enum" or "type".
+	Keyword string
+	// Description is the human readable description of the violation.
+	Description string
+	// Value is the offending value.
+	Value interface{}
+	// Details contains keyword specific details about the violation, such as
+	// the expected and given types for "type", or the missing property for
+	// "required".
+	Details map[string]interface{}
+}
+
+func newValidationError(err gojsonschema.ResultError) *ValidationError {
+	keyword, ok := keywords[err.Type()]
+	if !ok {
+		keyword = err.Type()
+	}
+
+	var pointer string
+	if ctx := err.Context(); ctx != nil {
+		// The first element is always the root context, which is represented
+		// by the empty pointer.
+		for _, token := range strings.Split(ctx.String("\x00"), "\x00")[1:] {
+			pointer += "/" + jsonwalk.Escape(token)
+		}
+	}
+
+	return &ValidationError{
+		Pointer:     pointer,
+		Keyword:     keyword,
+		Description: err.Description(),
+		Value:       err.Value(),
+		Details:     err.Details(),
+	}
+}
+
+// Error returns the same message gojsonschema would, minus the `(root): `
+// prefix, since these errors are presented to users.
+func (e *ValidationError) Error() string {
+	if e.Pointer == "" {
+		return e.Description
+	}
+
+	return jsonwalk.Path(e.Pointer) + ": " + e.Description
+}
+
+// ValidationErrors is the error returned when an input doesn't validate
+// against a schema. Callers can use errors.As to retrieve it.
+type ValidationErrors []*ValidationError
+
+// Error formats the errors in the same way as multierror.
+func (es ValidationErrors) Error() string {
+	return multierror.ListFormatFunc(es.WrappedErrors())
+}
+
+// WrappedErrors returns the individual validation errors. It implements the
+// errwrap.Wrapper interface, in the same way multierror.Error does.
+func (es ValidationErrors) WrappedErrors() []error {
+	errs := make([]error, len(es))
+	for i, err := range es {
+		errs[i] = err
+	}
+	return errs
+}
+
+// ListFormatFunc is a multierror.ErrorFormatFunc that lists the individual
+// errors wrapped by any ValidationErrors, rather than nesting them. This keeps
+// the message of a multierror.Error containing ValidationErrors the same as
+// when each validation error is appended on its own.
+func ListFormatFunc(es []error) string {
+	flattened := make([]error, 0, len(es))
+	for _, err := range es {
+		if w, ok := err.(interface{ WrappedErrors() []error }); ok {
+			flattened = append(flattened, w.WrappedErrors()...)
+		} else {
+			flattened = append(flattened, err)
+		}
+	}
+
+	return multierror.ListFormatFunc(flattened)
+}
+
+// keywords maps gojsonschema error types to the JSON schema keywords that
+// produce them. Types not in this map are already named after their keyword.
+var keywords = map[string]string{
+	"invalid_type":                    "type",
+	"number_any_of":                   "anyOf",
+	"number_one_of":                   "oneOf",
+	"number_all_of":                   "allOf",
+	"number_not":                      "not",
+	"missing_dependency":              "dependencies",
+	"array_no_additional_items":       "additionalItems",
+	"array_min_items":                 "minItems",
+	"array_max_items":                 "maxItems",
+	"unique":                          "uniqueItems",
+	"array_min_properties":            "minProperties",
+	"array_max_properties":            "maxProperties",
+	"additional_property_not_allowed": "additionalProperties",
+	"invalid_property_pattern":        "patternProperties",
+	"invalid_property_name":           "propertyNames",
+	"string_gte":                      "minLength",
+	"string_lte":                      "maxLength",
+	"multiple_of":                     "multipleOf",
+	"number_gte":                      "minimum",
+	"number_gt":                       "exclusiveMinimum",
+	"number_lte":                      "maximum",
+	"number_lt":                       "exclusiveMaximum",
+	"condition_then":                  "then",
+	"condition_else":                  "else",
+}
@@ -0,0 +1,165 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hashicorp/go-multierror"
+)
+
+func TestValidationErrors(t *testing.T) {
+	sc, err := Compile(`{
+        "$schema": "http://json-schema.org/draft-07/schema#",
+        "type": "object",
+        "properties": {
+            "name": { "type": "string" },
+            "published": { "type": "boolean" },
+            "steps": {
+                "type": "array",
+                "items": {
+                    "type": "object",
+                    "properties": {
+                        "run": { "type": "string" }
+                    },
+                    "required": ["run"]
+                }
+            },
+            "a/b~c": { "type": "string", "enum": ["foo", "bar"] }
+        },
+        "required": ["name"],
+        "additionalProperties": false
+    }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range map[string]struct {
+		in       string
+		want     []*ValidationError
+		wantText []string
+	}{
+		"required": {
+			in: `{"name": "x", "steps": [{"run": "a"}, {}]}`,
+			want: []*ValidationError{{
+				Pointer:     "/steps/1",
+				Keyword:     "required",
+				Description: "run is required",
+				Value:       map[string]interface{}{},
+				Details:     map[string]interface{}{"context": "(root).steps.1", "field": "steps.1", "property": "run"},
+			}},
+			wantText: []string{"steps.1: run is required"},
+		},
+		"root": {
+			in: `{"foo": true}`,
+			want: []*ValidationError{
+				{
+					Pointer:     "",
+					Keyword:     "required",
+					Description: "name is required",
+					Value:       map[string]interface{}{"foo": true},
+					Details:     map[string]interface{}{"context": "(root)", "field": "(root)", "property": "name"},
+				},
+				{
+					Pointer:     "",
+					Keyword:     "additionalProperties",
+					Description: "Additional property foo is not allowed",
+					Value:       true,
+					Details:     map[string]interface{}{"context": "(root)", "field": "(root)", "property": "foo"},
+				},
+			},
+			wantText: []string{"name is required", "Additional property foo is not allowed"},
+		},
+		"type": {
+			in: `{"name": "x", "published": "yes"}`,
+			want: []*ValidationError{{
+				Pointer:     "/published",
+				Keyword:     "type",
+				Description: "Invalid type. Expected: boolean, given: string",
+				Value:       "yes",
+				Details:     map[string]interface{}{"context": "(root).published", "field": "published", "expected": "boolean", "given": "string"},
+			}},
+			wantText: []string{"published: Invalid type. Expected: boolean, given: string"},
+		},
+		"escaped enum": {
+			in: `{"name": "x", "a/b~c": "quux"}`,
+			want: []*ValidationError{{
+				Pointer:     "/a~1b~0c",
+				Keyword:     "enum",
+				Description: `a/b~c must be one of the following: "foo", "bar"`,
+				Value:       "quux",
+				Details:     map[string]interface{}{"context": "(root).a/b~c", "field": "a/b~c", "allowed": `"foo", "bar"`},
+			}},
+			wantText: []string{`a/b~c: a/b~c must be one of the following: "foo", "bar"`},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := sc.Validate([]byte(tc.in))
+
+			var have ValidationErrors
+			if !errors.As(err, &have) {
+				t.Fatalf("unexpected error of type %T: %v", err, err)
+			}
+			if diff := cmp.Diff([]*ValidationError(have), tc.want); diff != "" {
+				t.Errorf("unexpected errors:\n%s", diff)
+			}
+
+			var text []string
+			for _, e := range have {
+				text = append(text, e.Error())
+			}
+			if diff := cmp.Diff(text, tc.wantText); diff != "" {
+				t.Errorf("unexpected error text:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestValidationErrorsError(t *testing.T) {
+	errs := ValidationErrors{
+		{Pointer: "/a", Description: "foo"},
+		{Pointer: "", Description: "bar"},
+	}
+
+	// The message must be identical to the multierror message that was
+	// returned before validation errors were structured.
+	var want *multierror.Error
+	want = multierror.Append(want, errors.New("a: foo"), errors.New("bar"))
+	if have := errs.Error(); have != want.Error() {
+		t.Errorf("unexpected error: have=%q want=%q", have, want.Error())
+	}
+
+	wrapped := &multierror.Error{ErrorFormat: ListFormatFunc}
+	wrapped = multierror.Append(wrapped, errs, errors.New("baz"))
+	want = multierror.Append(want, errors.New("baz"))
+	if have := wrapped.Error(); have != want.Error() {
+		t.Errorf("unexpected wrapped error: have=%q want=%q", have, want.Error())
+	}
+
+	var have ValidationErrors
+	if !errors.As(wrapped, &have) {
+		t.Error("cannot retrieve validation errors from multierror")
+	} else if len(have) != 2 {
+		t.Errorf("unexpected number of validation errors: %d", len(have))
+	}
+}
+
+func TestValidationErrorValue(t *testing.T) {
+	sc, err := Compile(`{"type": "object", "properties": {"n": {"type": "string"}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var errs ValidationErrors
+	if err := sc.Validate([]byte(`{"n": 42}`)); !errors.As(err, &errs) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if have, want := errs[0].Value, json.Number("42"); have != want {
+		t.Errorf("unexpected value: have=%#v want=%#v", have, want)
+	}
+	if !strings.HasPrefix(errs[0].Error(), "n: ") {
+		t.Errorf("unexpected error: %v", errs[0])
+	}
+}
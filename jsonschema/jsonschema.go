@@ -1,36 +1,63 @@
 package jsonschema
 
 import (
-	"strings"
-
-	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
 	"github.com/xeipuuv/gojsonschema"
 )
 
-// Validate validates the given input against the JSON schema.
+// Schema is a compiled JSON schema. Compiling a schema is considerably more
+// expensive than validating against it, so callers that validate many inputs
+// against the same schema should compile it once and reuse the Schema.
 //
-// It returns either nil, in case the input is valid, or an error.
-func Validate(schema string, input []byte) error {
+// A Schema is safe for concurrent use by multiple goroutines.
+type Schema struct {
+	compiled *gojsonschema.Schema
+}
+
+// Compile compiles the given JSON schema.
+func Compile(schema string) (*Schema, error) {
 	sl := gojsonschema.NewSchemaLoader()
 	sc, err := sl.Compile(gojsonschema.NewStringLoader(schema))
 	if err != nil {
-		return errors.Wrap(err, "failed to compile JSON schema")
+		return nil, errors.Wrap(err, "failed to compile JSON schema")
 	}
 
-	res, err := sc.Validate(gojsonschema.NewBytesLoader(input))
+	return &Schema{compiled: sc}, nil
+}
+
+// Validate validates the given input against the schema.
+//
+// It returns either nil, in case the input is valid, or an error. If the input
+// doesn't conform to the schema, the error is a ValidationErrors.
+func (s *Schema) Validate(input []byte) error {
+	res, err := s.compiled.Validate(gojsonschema.NewBytesLoader(input))
 	if err != nil {
 		return errors.Wrap(err, "failed to validate input against schema")
 	}
 
-	var errs *multierror.Error
-	for _, err := range res.Errors() {
-		e := err.String()
-		// Remove `(root): ` from error formatting since these errors are
-		// presented to users.
-		e = strings.TrimPrefix(e, "(root): ")
-		errs = multierror.Append(errs, errors.New(e))
+	if res.Valid() {
+		return nil
+	}
+
+	errs := make(ValidationErrors, len(res.Errors()))
+	for i, err := range res.Errors() {
+		errs[i] = newValidationError(err)
+	}
+
+	return errs
+}
+
+// Validate validates the given input against the JSON schema.
+//
+// It returns either nil, in case the input is valid, or an error.
+//
+// The schema is compiled on every call: use Compile and Schema.Validate
+// instead when validating more than one input against the same schema.
+func Validate(schema string, input []byte) error {
+	sc, err := Compile(schema)
+	if err != nil {
+		return err
 	}
 
-	return errs.ErrorOrNil()
+	return sc.Validate(input)
 }
@@ -0,0 +1,125 @@
+package jsonschema
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testSchema = `{
+    "$schema": "http://json-schema.org/draft-07/schema#",
+    "$id": "https://github.com/sourcegraph/batch-change-utils/schema/test.schema.json",
+    "type": "object",
+    "properties": {
+        "a": { "type": "string" },
+        "b": { "type": "integer" }
+    },
+    "required": ["a"]
+}`
+
+func TestCompile(t *testing.T) {
+	t.Run("bad schema", func(t *testing.T) {
+		if _, err := Compile("{"); err == nil {
+			t.Error("unexpected nil error")
+		} else if !strings.Contains(err.Error(), "failed to compile JSON schema") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		if _, err := Compile(testSchema); err != nil {
+			t.Errorf("unexpected non-nil error: %v", err)
+		}
+	})
+}
+
+func TestSchemaValidate(t *testing.T) {
+	sc, err := Compile(testSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		if err := sc.Validate([]byte("{")); err == nil {
+			t.Error("unexpected nil error")
+		} else if !strings.Contains(err.Error(), "failed to validate input against schema") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("invalid input", func(t *testing.T) {
+		if err := sc.Validate([]byte(`{"b": "bar"}`)); err == nil {
+			t.Error("unexpected nil error")
+		} else {
+			for _, want := range []string{"2 errors occurred", "a is required", "b: Invalid type"} {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error does not contain %q: %v", want, err)
+				}
+			}
+			if strings.Contains(err.Error(), "(root)") {
+				t.Errorf("error contains the root context: %v", err)
+			}
+		}
+	})
+
+	t.Run("valid input", func(t *testing.T) {
+		if err := sc.Validate([]byte(`{"a": "foo", "b": 42}`)); err != nil {
+			t.Errorf("unexpected non-nil error: %v", err)
+		}
+	})
+
+	t.Run("concurrent use", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := sc.Validate([]byte(`{"a": "foo"}`)); err != nil {
+					t.Errorf("unexpected non-nil error: %v", err)
+				}
+				if err := sc.Validate([]byte(`{"b": "bar"}`)); err == nil {
+					t.Error("unexpected nil error")
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate("{", []byte(`{}`)); err == nil {
+		t.Error("unexpected nil error")
+	}
+
+	if err := Validate(testSchema, []byte(`{"b": "bar"}`)); err == nil {
+		t.Error("unexpected nil error")
+	}
+
+	if err := Validate(testSchema, []byte(`{"a": "foo"}`)); err != nil {
+		t.Errorf("unexpected non-nil error: %v", err)
+	}
+}
+
+var benchmarkInput = []byte(`{"a": "foo", "b": 42}`)
+
+func BenchmarkValidate(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if err := Validate(testSchema, benchmarkInput); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSchemaValidate(b *testing.B) {
+	sc, err := Compile(testSchema)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := sc.Validate(benchmarkInput); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
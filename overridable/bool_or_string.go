@@ -2,82 +2,121 @@ package overridable
 
 import (
 	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
 )
 
 // BoolOrString is a set of rules that either evaluate to a string or a bool.
-type BoolOrString struct {
-	rules rules
-}
+// Any string is allowed: use BoolOrStringOf to restrict them.
+type BoolOrString = BoolOrStringOf[AnyString]
 
 // FromBoolOrString creates a BoolOrString representing a static, scalar value.
 func FromBoolOrString(v interface{}) BoolOrString {
-	return BoolOrString{
-		rules: rules{simpleRule(v)},
-	}
+	return BoolOrString(From(v))
 }
 
+// AllowedStrings provides the strings that the values of a BoolOrStringOf may
+// be. It is implemented by the type used as the type parameter, the zero value
+// of which is used to call AllowedStrings, such as:
+//
+//	type publishedStates struct{}
+//
+//	func (publishedStates) AllowedStrings() []string { return []string{"draft"} }
+//
+//	type Spec struct {
+//		Published overridable.BoolOrStringOf[publishedStates]
+//	}
+type AllowedStrings interface {
+	// AllowedStrings returns the allowed strings, or nil if every string is
+	// allowed.
+	AllowedStrings() []string
+}
+
+// AnyString allows every string as the value of a BoolOrStringOf.
+type AnyString struct{}
+
+// AllowedStrings implements AllowedStrings.
+func (AnyString) AllowedStrings() []string { return nil }
+
+// BoolOrStringOf is a set of rules that either evaluate to a bool or one of the
+// strings allowed by A. Unmarshalling any other string fails. Since A is part
+// of the type, a BoolOrString can be converted to a BoolOrStringOf, and vice
+// versa.
+type BoolOrStringOf[A AllowedStrings] Overridable[interface{}]
+
 // Value returns the value for the given repository.
-func (bs *BoolOrString) Value(name string) interface{} {
-	return bs.rules.Match(name)
+func (bs *BoolOrStringOf[A]) Value(name string) interface{} {
+	return (*Overridable[interface{}])(bs).Value(name)
 }
 
 // ValueWithSuffix returns the value for the given repository and branch name.
-func (bs *BoolOrString) ValueWithSuffix(name, suffix string) interface{} {
-	return bs.rules.MatchWithSuffix(name, suffix)
+func (bs *BoolOrStringOf[A]) ValueWithSuffix(name, suffix string) interface{} {
+	return (*Overridable[interface{}])(bs).ValueWithSuffix(name, suffix)
+}
+
+// Explain explains which rules match the given repository and branch name.
+func (bs *BoolOrStringOf[A]) Explain(name, suffix string) Explanation {
+	return (*Overridable[interface{}])(bs).Explain(name, suffix)
 }
 
 // MarshalJSON encodes the BoolOrString overridable to a json representation.
-func (bs BoolOrString) MarshalJSON() ([]byte, error) {
+// A BoolOrString without rules is encoded as false.
+func (bs BoolOrStringOf[A]) MarshalJSON() ([]byte, error) {
 	if len(bs.rules) == 0 {
 		return []byte("false"), nil
 	}
-	return json.Marshal(bs.rules)
+	return Overridable[interface{}](bs).MarshalJSON()
 }
 
-// UnmarshalJSON unmarshalls a JSON value into a Publish.
-func (bs *BoolOrString) UnmarshalJSON(data []byte) error {
-	var b bool
-	if err := json.Unmarshal(data, &b); err == nil {
-		*bs = BoolOrString{rules: rules{simpleRule(b)}}
-		return nil
-	}
-	var s string
-	if err := json.Unmarshal(data, &s); err == nil {
-		*bs = BoolOrString{rules: rules{simpleRule(s)}}
-		return nil
+// MarshalYAML encodes the BoolOrString overridable to a YAML representation,
+// in the same form as MarshalJSON.
+func (bs BoolOrStringOf[A]) MarshalYAML() (interface{}, error) {
+	if len(bs.rules) == 0 {
+		return false, nil
 	}
+	return Overridable[interface{}](bs).MarshalYAML()
+}
 
-	var c complex
-	if err := json.Unmarshal(data, &c); err != nil {
-		return err
-	}
+// UnmarshalJSON unmarshalls a JSON value into a BoolOrString.
+func (bs *BoolOrStringOf[A]) UnmarshalJSON(data []byte) error {
+	return (*Overridable[interface{}])(bs).unmarshal(func(v interface{}) error { return json.Unmarshal(data, v) }, jsonRules[interface{}], checkBoolOrString[A])
+}
 
-	return bs.rules.hydrateFromComplex(c)
+// UnmarshalYAML unmarshalls a YAML value into a BoolOrString.
+func (bs *BoolOrStringOf[A]) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return (*Overridable[interface{}])(bs).unmarshal(unmarshal, yamlRules[interface{}], checkBoolOrString[A])
 }
 
-// UnmarshalYAML unmarshalls a YAML value into a Publish.
-func (bs *BoolOrString) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	var b bool
-	if err := unmarshal(&b); err == nil {
-		*bs = BoolOrString{rules: rules{simpleRule(b)}}
-		return nil
-	}
+// Equal tests two BoolOrStrings for equality, used in cmp.
+func (bs BoolOrStringOf[A]) Equal(other BoolOrStringOf[A]) bool {
+	return bs.rules.Equal(other.rules)
+}
 
-	var s string
-	if err := unmarshal(&s); err == nil {
-		*bs = BoolOrString{rules: rules{simpleRule(s)}}
+// checkBoolOrString ensures that a value is a bool or a string allowed by A.
+func checkBoolOrString[A AllowedStrings](v interface{}) error {
+	switch v := v.(type) {
+	case bool:
 		return nil
-	}
+	case string:
+		var a A
+		allowed := a.AllowedStrings()
+		if allowed == nil {
+			return nil
+		}
 
-	var c complex
-	if err := unmarshal(&c); err != nil {
-		return err
+		values := make([]string, 0, len(allowed))
+		for _, value := range allowed {
+			if value == v {
+				return nil
+			}
+			values = append(values, strconv.Quote(value))
+		}
+		sort.Strings(values)
+		return errors.Errorf("%q is not allowed: expected a bool or one of %s", v, strings.Join(values, ", "))
+	default:
+		return errors.Errorf("expected a bool or a string, got %s", describe(v))
 	}
-
-	return bs.rules.hydrateFromComplex(c)
-}
-
-// Equal tests two BoolOrStrings for equality, used in cmp.
-func (bs BoolOrString) Equal(other BoolOrString) bool {
-	return bs.rules.Equal(other.rules)
 }
@@ -6,6 +6,7 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
 )
 
 func TestBoolOrStringIs(t *testing.T) {
@@ -154,6 +155,39 @@ func TestBoolOrStringMarshalJSON(t *testing.T) {
 	}
 }
 
+func TestBoolOrStringMarshalZero(t *testing.T) {
+	for name, bs := range map[string]BoolOrString{
+		"zero value": {},
+		"empty list": {rules: rules{}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			data, err := json.Marshal(&bs)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if have, want := string(data), `false`; have != want {
+				t.Errorf("unexpected JSON: have=%q want=%q", have, want)
+			}
+
+			data, err = yaml.Marshal(&bs)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if have, want := string(data), "false\n"; have != want {
+				t.Errorf("unexpected yaml.v2 YAML: have=%q want=%q", have, want)
+			}
+
+			data, err = yamlv3.Marshal(&bs)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if have, want := string(data), "false\n"; have != want {
+				t.Errorf("unexpected yaml.v3 YAML: have=%q want=%q", have, want)
+			}
+		})
+	}
+}
+
 func TestBoolOrStringUnmarshalJSON(t *testing.T) {
 	t.Run("valid", func(t *testing.T) {
 		for name, tc := range map[string]struct {
@@ -269,6 +303,15 @@ func TestBoolOrStringYAML(t *testing.T) {
 					},
 				},
 			},
+			"rule list with strings that look like null": {
+				in: "- \"*\": \"null\"\n- github.com/sd9/*@branch-1: '~'",
+				want: BoolOrString{
+					rules: rules{
+						{pattern: allPattern, value: "null"},
+						{pattern: "github.com/sd9/*", patternSuffix: "branch-1", value: "~"},
+					},
+				},
+			},
 		} {
 			t.Run(name, func(t *testing.T) {
 				var have BoolOrString
@@ -287,6 +330,7 @@ func TestBoolOrStringYAML(t *testing.T) {
 			"empty object":    `- {}`,
 			"too many fields": `- {"foo": true, "bar": false}`,
 			"invalid glob":    `- "[": false`,
+			"invalid suffix":  `- "*@release-[": false`,
 		} {
 			t.Run(name, func(t *testing.T) {
 				var have BoolOrString
@@ -298,18 +342,116 @@ func TestBoolOrStringYAML(t *testing.T) {
 	})
 }
 
+func TestBoolOrStringInvalidValues(t *testing.T) {
+	for name, tc := range map[string]struct {
+		json, yaml string
+		index      int
+		pattern    string
+	}{
+		"number": {
+			json:    `[{"*":true},{"github.com/a/*":1}]`,
+			yaml:    "- '*': true\n- github.com/a/*: 1",
+			index:   1,
+			pattern: "github.com/a/*",
+		},
+		"object": {
+			json:    `[{"*":{"draft":true}}]`,
+			yaml:    "- '*': {draft: true}",
+			index:   0,
+			pattern: "*",
+		},
+		"list": {
+			json:    `[{"*":false},{"github.com/a/*@main":["draft"]}]`,
+			yaml:    "- '*': false\n- github.com/a/*@main: [draft]",
+			index:   1,
+			pattern: "github.com/a/*@main",
+		},
+		"null": {
+			json:    `[{"*":null}]`,
+			yaml:    "- '*': null",
+			index:   0,
+			pattern: "*",
+		},
+	} {
+		for format, unmarshal := range map[string]func() error{
+			"JSON":    func() error { var bs BoolOrString; return json.Unmarshal([]byte(tc.json), &bs) },
+			"yaml.v2": func() error { var bs BoolOrString; return yaml.Unmarshal([]byte(tc.yaml), &bs) },
+			"yaml.v3": func() error { var bs BoolOrString; return yamlv3.Unmarshal([]byte(tc.yaml), &bs) },
+		} {
+			t.Run(name+"/"+format, func(t *testing.T) {
+				err := unmarshal()
+				if e, ok := err.(errInvalidRuleValue); !ok {
+					t.Errorf("unexpected error of type %T: %v", err, err)
+				} else if e.index != tc.index || e.pattern != tc.pattern {
+					t.Errorf("unexpected rule in error: have=%d %q want=%d %q", e.index, e.pattern, tc.index, tc.pattern)
+				}
+			})
+		}
+	}
+
+	t.Run("scalar", func(t *testing.T) {
+		var bs BoolOrString
+		if err := json.Unmarshal([]byte(`1`), &bs); err == nil {
+			t.Error("unexpected nil error from JSON")
+		}
+		if err := yaml.Unmarshal([]byte(`{a: b}`), &bs); err == nil {
+			t.Error("unexpected nil error from YAML")
+		}
+	})
+}
+
+// closedOrDraft allows the strings "closed" and "draft".
+type closedOrDraft struct{}
+
+func (closedOrDraft) AllowedStrings() []string { return []string{"draft", "closed"} }
+
+func TestBoolOrStringOf(t *testing.T) {
+	t.Run("allowed", func(t *testing.T) {
+		for _, in := range []string{
+			`"draft"`,
+			`true`,
+			`[{"*":false},{"github.com/a/*":"draft"},{"github.com/b/*":"closed"}]`,
+		} {
+			var bs BoolOrStringOf[closedOrDraft]
+			if err := json.Unmarshal([]byte(in), &bs); err != nil {
+				t.Errorf("unexpected error for %s: %v", in, err)
+			}
+		}
+	})
+
+	t.Run("not allowed", func(t *testing.T) {
+		var bs BoolOrStringOf[closedOrDraft]
+		err := json.Unmarshal([]byte(`[{"*":false},{"github.com/a/*":"drfat"}]`), &bs)
+		if e, ok := err.(errInvalidRuleValue); !ok {
+			t.Errorf("unexpected error of type %T: %v", err, err)
+		} else if e.index != 1 || e.pattern != "github.com/a/*" {
+			t.Errorf("unexpected rule in error: %d %q", e.index, e.pattern)
+		} else if want := `invalid value for array entry 1 ("github.com/a/*"): "drfat" is not allowed: expected a bool or one of "closed", "draft"`; err.Error() != want {
+			t.Errorf("unexpected message: have=%q want=%q", err.Error(), want)
+		}
+
+		if err := yaml.Unmarshal([]byte(`drfat`), &bs); err == nil {
+			t.Error("unexpected nil error for scalar")
+		}
+	})
+
+	t.Run("other types are unaffected", func(t *testing.T) {
+		var bs BoolOrString
+		if err := json.Unmarshal([]byte(`"drfat"`), &bs); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
 // initBoolOrString ensures all rules are compiled.
 func initBoolOrString(r *BoolOrString) (err error) {
 	for i, rule := range r.rules {
 		if rule.compiled == nil {
-			r.rules[i], err = newRule(rule.pattern, rule.value)
+			r.rules[i], err = newRule(rule.key(), rule.value)
 			if err != nil {
 				return err
 			}
 		}
-		if rule.patternSuffix != "" {
-			r.rules[i].patternSuffix = rule.patternSuffix
-		}
 	}
 
 	return nil
@@ -6,6 +6,7 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
 )
 
 func TestBoolIs(t *testing.T) {
@@ -228,3 +229,60 @@ func initBool(b *Bool) (err error) {
 
 	return nil
 }
+
+func TestBoolInvalidRuleValues(t *testing.T) {
+	for name, tc := range map[string]struct {
+		json, yaml string
+		index      int
+		pattern    string
+	}{
+		"string": {
+			json:    `[{"*":"draft"}]`,
+			yaml:    `- "*": draft`,
+			index:   0,
+			pattern: "*",
+		},
+		"number": {
+			json:    `[{"*":true},{"github.com/a/*":1}]`,
+			yaml:    "- '*': true\n- github.com/a/*: 1",
+			index:   1,
+			pattern: "github.com/a/*",
+		},
+		"null": {
+			json:    `[{"*":true},{"github.com/a/*@main":null}]`,
+			yaml:    "- '*': true\n- github.com/a/*@main: null",
+			index:   1,
+			pattern: "github.com/a/*@main",
+		},
+		"object": {
+			json:    `[{"*":{"a":true}}]`,
+			yaml:    "- '*': {a: true}",
+			index:   0,
+			pattern: "*",
+		},
+	} {
+		for format, unmarshal := range map[string]func(*Bool) error{
+			"JSON":    func(b *Bool) error { return json.Unmarshal([]byte(tc.json), b) },
+			"yaml.v2": func(b *Bool) error { return yaml.Unmarshal([]byte(tc.yaml), b) },
+			"yaml.v3": func(b *Bool) error { return yamlv3.Unmarshal([]byte(tc.yaml), b) },
+		} {
+			t.Run(name+"/"+format, func(t *testing.T) {
+				b := FromBool(true)
+				err := unmarshal(&b)
+				if e, ok := err.(errInvalidRuleValue); !ok {
+					t.Errorf("unexpected error of type %T: %v", err, err)
+				} else if e.index != tc.index || e.pattern != tc.pattern {
+					t.Errorf("unexpected rule in error: have=%d %q want=%d %q", e.index, e.pattern, tc.index, tc.pattern)
+				}
+
+				// The existing rules are kept.
+				if diff := cmp.Diff(b, FromBool(true)); diff != "" {
+					t.Errorf("rules were modified:\n%s", diff)
+				}
+				if !b.Value("github.com/a/b") {
+					t.Error("unexpected value after failed unmarshal")
+				}
+			})
+		}
+	}
+}
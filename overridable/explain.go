@@ -0,0 +1,96 @@
+package overridable
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Explanation describes how the value of an overridable was chosen for a
+// repository and branch: which rule won, and which other rules also matched
+// but were overridden by it, since the last matching rule wins.
+type Explanation struct {
+	// Name and Suffix are the repository name and branch that were explained.
+	Name   string
+	Suffix string
+
+	// Match is the rule that provides the value, or nil if no rule matched.
+	Match *MatchedRule
+	// Overridden are the rules that matched before Match, in the order they
+	// appear in the list of rules.
+	Overridden []MatchedRule
+}
+
+// MatchedRule is a rule that matched a repository and branch.
+type MatchedRule struct {
+	// Index is the position of the rule in the list of rules, starting at 0. A
+	// single value that applies to every repository is rule 0.
+	Index int
+	// Pattern is the glob pattern for the repository name.
+	Pattern string
+	// Suffix is the glob pattern for the branch, or an empty string if the
+	// rule applies to every branch.
+	Suffix string
+	// Value is the value of the rule.
+	Value interface{}
+}
+
+// String renders the explanation as text, such as:
+//
+//	github.com/sourcegraph/src-cli@main: true (rule 1: "github.com/sourcegraph/*@main")
+//	  overrides rule 0: "*" (false)
+func (e Explanation) String() string {
+	var b strings.Builder
+
+	b.WriteString(e.Name)
+	if e.Suffix != "" {
+		b.WriteString("@" + e.Suffix)
+	}
+	if e.Match == nil {
+		b.WriteString(": no rule matched")
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, ": %s (rule %d: %q)", renderValue(e.Match.Value), e.Match.Index, e.Match.key())
+	for _, m := range e.Overridden {
+		fmt.Fprintf(&b, "\n  overrides rule %d: %q (%s)", m.Index, m.key(), renderValue(m.Value))
+	}
+	return b.String()
+}
+
+// key returns the pattern as it was written in the spec.
+func (m MatchedRule) key() string {
+	return rule{pattern: m.Pattern, patternSuffix: m.Suffix}.key()
+}
+
+// renderValue renders a rule value the way it would appear in JSON, so that
+// strings can be told apart from other values.
+func renderValue(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(data)
+}
+
+// Explain explains which rules match the given repository name and suffix,
+// using the same matching as MatchWithSuffix.
+func (r rules) Explain(name, suffix string) Explanation {
+	e := Explanation{Name: name, Suffix: suffix}
+	for i, rule := range r {
+		if !rule.matchesWithSuffix(name, suffix) {
+			continue
+		}
+
+		if e.Match != nil {
+			e.Overridden = append(e.Overridden, *e.Match)
+		}
+		e.Match = &MatchedRule{
+			Index:   i,
+			Pattern: rule.pattern,
+			Suffix:  rule.patternSuffix,
+			Value:   rule.value,
+		}
+	}
+	return e
+}
@@ -0,0 +1,99 @@
+package overridable
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExplain(t *testing.T) {
+	var published BoolOrString
+	if err := json.Unmarshal([]byte(`[
+		{"*": false},
+		{"github.com/sourcegraph/*": "draft"},
+		{"github.com/sourcegraph/*@release-*": true},
+		{"github.com/other/*": true}
+	]`), &published); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		name, suffix string
+		want         Explanation
+		text         string
+	}{
+		"overridden rules": {
+			name:   "github.com/sourcegraph/src-cli",
+			suffix: "release-3.1",
+			want: Explanation{
+				Name:   "github.com/sourcegraph/src-cli",
+				Suffix: "release-3.1",
+				Match:  &MatchedRule{Index: 2, Pattern: "github.com/sourcegraph/*", Suffix: "release-*", Value: true},
+				Overridden: []MatchedRule{
+					{Index: 0, Pattern: "*", Value: false},
+					{Index: 1, Pattern: "github.com/sourcegraph/*", Value: "draft"},
+				},
+			},
+			text: `github.com/sourcegraph/src-cli@release-3.1: true (rule 2: "github.com/sourcegraph/*@release-*")` + "\n" +
+				`  overrides rule 0: "*" (false)` + "\n" +
+				`  overrides rule 1: "github.com/sourcegraph/*" ("draft")`,
+		},
+		"suffix does not match": {
+			name:   "github.com/sourcegraph/src-cli",
+			suffix: "main",
+			want: Explanation{
+				Name:       "github.com/sourcegraph/src-cli",
+				Suffix:     "main",
+				Match:      &MatchedRule{Index: 1, Pattern: "github.com/sourcegraph/*", Value: "draft"},
+				Overridden: []MatchedRule{{Index: 0, Pattern: "*", Value: false}},
+			},
+			text: `github.com/sourcegraph/src-cli@main: "draft" (rule 1: "github.com/sourcegraph/*")` + "\n" +
+				`  overrides rule 0: "*" (false)`,
+		},
+		"no suffix": {
+			name: "github.com/foo/bar",
+			want: Explanation{
+				Name:  "github.com/foo/bar",
+				Match: &MatchedRule{Index: 0, Pattern: "*", Value: false},
+			},
+			text: `github.com/foo/bar: false (rule 0: "*")`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			have := published.Explain(tc.name, tc.suffix)
+			if diff := cmp.Diff(have, tc.want); diff != "" {
+				t.Errorf("unexpected explanation:\n%s", diff)
+			}
+			if have.Match.Value != published.ValueWithSuffix(tc.name, tc.suffix) {
+				t.Errorf("explanation disagrees with value: %v", have.Match.Value)
+			}
+			if have := have.String(); have != tc.text {
+				t.Errorf("unexpected text:\nhave=%s\nwant=%s", have, tc.text)
+			}
+		})
+	}
+
+	t.Run("no match", func(t *testing.T) {
+		var s String
+		if err := json.Unmarshal([]byte(`[{"github.com/sourcegraph/*": "a"}]`), &s); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		have := s.Explain("github.com/foo/bar", "main")
+		if diff := cmp.Diff(have, Explanation{Name: "github.com/foo/bar", Suffix: "main"}); diff != "" {
+			t.Errorf("unexpected explanation:\n%s", diff)
+		}
+		if want := "github.com/foo/bar@main: no rule matched"; have.String() != want {
+			t.Errorf("unexpected text: have=%q want=%q", have.String(), want)
+		}
+	})
+
+	t.Run("scalar", func(t *testing.T) {
+		o := From([]string{"a", "b"})
+		want := `github.com/foo/bar: ["a","b"] (rule 0: "*")`
+		if have := o.Explain("github.com/foo/bar", "").String(); have != want {
+			t.Errorf("unexpected text: have=%q want=%q", have, want)
+		}
+	})
+}
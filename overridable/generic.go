@@ -0,0 +1,226 @@
+package overridable
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Overridable represents a value of type T that can be modified on a per-repo
+// basis. It can be unmarshalled from either a single value of type T, which
+// applies to every repository, or a list of rules mapping repository patterns
+// to values of type T, in which the last matching rule wins:
+//
+//	published:
+//	  - "*": false
+//	  - github.com/sourcegraph/*: true
+//
+// T can be any type that can be unmarshalled from JSON and YAML.
+type Overridable[T any] struct {
+	rules rules
+}
+
+// From creates an Overridable representing a static, scalar value.
+func From[T any](v T) Overridable[T] {
+	return Overridable[T]{
+		rules: rules{simpleRule(v)},
+	}
+}
+
+// Value returns the value for the given repository, or the zero value of T if
+// no rule matches it.
+func (o *Overridable[T]) Value(name string) T {
+	v, _ := o.Lookup(name)
+	return v
+}
+
+// ValueWithSuffix returns the value for the given repository and branch name,
+// or the zero value of T if no rule matches them.
+func (o *Overridable[T]) ValueWithSuffix(name, suffix string) T {
+	v, _ := o.LookupWithSuffix(name, suffix)
+	return v
+}
+
+// Lookup returns the value for the given repository, and whether any rule
+// matched it.
+func (o *Overridable[T]) Lookup(name string) (T, bool) {
+	return typed[T](o.rules.Match(name))
+}
+
+// LookupWithSuffix returns the value for the given repository and branch name,
+// and whether any rule matched them.
+func (o *Overridable[T]) LookupWithSuffix(name, suffix string) (T, bool) {
+	return typed[T](o.rules.MatchWithSuffix(name, suffix))
+}
+
+// Explain explains which rules match the given repository and branch name, and
+// which one of them provides the value returned by ValueWithSuffix.
+func (o *Overridable[T]) Explain(name, suffix string) Explanation {
+	return o.rules.Explain(name, suffix)
+}
+
+// typed converts a rule value to T. ok is false if there's no value, or if the
+// value isn't a T.
+func typed[T any](v interface{}) (t T, ok bool) {
+	t, ok = v.(T)
+	return t, ok
+}
+
+// MarshalJSON encodes the Overridable to a JSON representation. An Overridable
+// without rules is encoded as the zero value of T, which is the value it has
+// for every repository.
+func (o Overridable[T]) MarshalJSON() ([]byte, error) {
+	if len(o.rules) == 0 {
+		var zero T
+		return json.Marshal(zero)
+	}
+	return json.Marshal(o.rules)
+}
+
+// MarshalYAML encodes the Overridable to a YAML representation, in the same
+// form as MarshalJSON.
+func (o Overridable[T]) MarshalYAML() (interface{}, error) {
+	if len(o.rules) == 0 {
+		var zero T
+		return zero, nil
+	}
+	return o.rules.MarshalYAML()
+}
+
+// UnmarshalJSON unmarshalls a JSON value into an Overridable. Anything other
+// than a list of rules is unmarshalled as a single value of type T.
+func (o *Overridable[T]) UnmarshalJSON(data []byte) error {
+	return o.unmarshal(func(v interface{}) error { return json.Unmarshal(data, v) }, jsonRules[T], nil)
+}
+
+// UnmarshalYAML unmarshalls a YAML value into an Overridable. As with JSON,
+// anything other than a list of rules is unmarshalled as a single value of
+// type T.
+func (o *Overridable[T]) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return o.unmarshal(unmarshal, yamlRules[T], nil)
+}
+
+// unmarshal unmarshals either a single value or a list of rules, using the
+// given function to unmarshal the underlying JSON or YAML, and decodeRules to
+// unmarshal the list of rules. If check is not nil, it is called to validate
+// each value.
+//
+// An empty list is ambiguous when T is itself a list, in which case it's
+// unmarshalled as a single value, since that's how such a value is marshalled.
+func (o *Overridable[T]) unmarshal(unmarshal func(interface{}) error, decodeRules func(func(interface{}) error) ([]map[string]*ruleValue[T], error), check func(T) error) error {
+	c, err := decodeRules(unmarshal)
+	if err == nil && len(c) > 0 {
+		return hydrate(&o.rules, c, func(v *ruleValue[T]) (interface{}, error) {
+			return v.get(check)
+		})
+	}
+
+	var all T
+	allErr := unmarshal(&all)
+	if allErr == nil && check != nil {
+		if checkErr := check(all); checkErr != nil {
+			allErr = errors.Wrap(checkErr, "invalid value")
+		}
+	}
+
+	if allErr != nil {
+		if err == nil {
+			// An empty list of rules.
+			o.rules = rules{}
+			return nil
+		}
+		return allErr
+	}
+	*o = From(all)
+	return nil
+}
+
+// jsonRules unmarshals a list of rules from JSON.
+func jsonRules[T any](unmarshal func(interface{}) error) ([]map[string]*ruleValue[T], error) {
+	var c []map[string]*ruleValue[T]
+	err := unmarshal(&c)
+	return c, err
+}
+
+// yamlRules unmarshals a list of rules from YAML. yaml.v2 doesn't call
+// unmarshallers for quoted strings that look like null, such as "null" and
+// "~", so we can't unmarshal straight into ruleValues: instead, we unmarshal
+// each value into an interface{} and convert it to a T.
+func yamlRules[T any](unmarshal func(interface{}) error) ([]map[string]*ruleValue[T], error) {
+	var raw []map[string]interface{}
+	if err := unmarshal(&raw); err != nil {
+		return nil, err
+	}
+
+	c := make([]map[string]*ruleValue[T], len(raw))
+	for i, rule := range raw {
+		c[i] = make(map[string]*ruleValue[T], len(rule))
+		for pattern, value := range rule {
+			if value == nil {
+				c[i][pattern] = nil
+				continue
+			}
+
+			v := &ruleValue[T]{}
+			v.err = convertYAML(value, &v.value)
+			c[i][pattern] = v
+		}
+	}
+	return c, nil
+}
+
+// convertYAML converts a value unmarshalled from YAML into an interface{} to a
+// T. Values that aren't already a T are marshalled back to YAML and
+// unmarshalled again, except when T is a string: as with JSON, other scalars
+// aren't converted to strings, not least since YAML may have normalized them.
+func convertYAML[T any](value interface{}, out *T) error {
+	if t, ok := value.(T); ok {
+		*out = t
+		return nil
+	}
+	if _, ok := interface{}(out).(*string); ok {
+		return errors.Errorf("expected a string, got %s", describe(value))
+	}
+
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(data, out)
+}
+
+// ruleValue is the value of a single rule, unmarshalled into a T. Errors are
+// recorded rather than returned, so that they can be reported along with the
+// index and pattern of the rule. A nil *ruleValue represents null.
+type ruleValue[T any] struct {
+	value T
+	err   error
+}
+
+func (v *ruleValue[T]) UnmarshalJSON(data []byte) error {
+	v.err = json.Unmarshal(data, &v.value)
+	return nil
+}
+
+// get returns the value, or an error if it's null, couldn't be unmarshalled
+// into a T, or fails the given check.
+func (v *ruleValue[T]) get(check func(T) error) (interface{}, error) {
+	if v == nil {
+		return nil, errors.New("value must not be null")
+	}
+	if v.err != nil {
+		return nil, v.err
+	}
+	if check != nil {
+		if err := check(v.value); err != nil {
+			return nil, err
+		}
+	}
+	return v.value, nil
+}
+
+// Equal tests two Overridables for equality, used in cmp.
+func (o Overridable[T]) Equal(other Overridable[T]) bool {
+	return o.rules.Equal(other.rules)
+}
@@ -0,0 +1,156 @@
+package overridable
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+func TestOverridableValue(t *testing.T) {
+	var o Overridable[int]
+	if err := json.Unmarshal([]byte(`[{"*":1},{"github.com/internal/*":2}]`), &o); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, want := range map[string]int{
+		"github.com/foo/bar":      1,
+		"github.com/internal/bar": 2,
+	} {
+		if have, ok := o.Lookup(name); !ok || have != want {
+			t.Errorf("unexpected value for %q: have=%d (%v) want=%d", name, have, ok, want)
+		}
+		if have := o.Value(name); have != want {
+			t.Errorf("unexpected value for %q: have=%d want=%d", name, have, want)
+		}
+	}
+
+	none := Overridable[int]{}
+	if have, ok := none.Lookup("github.com/foo/bar"); ok || have != 0 {
+		t.Errorf("unexpected value: %d (%v)", have, ok)
+	}
+	if have := none.Value("github.com/foo/bar"); have != 0 {
+		t.Errorf("unexpected value: %d", have)
+	}
+
+	suffixed := Overridable[string]{rules: rules{{pattern: allPattern, patternSuffix: "main", value: "main"}}}
+	if err := initOverridable(&suffixed); err != nil {
+		t.Fatal(err)
+	}
+	if have, ok := suffixed.LookupWithSuffix("github.com/foo/bar", "main"); !ok || have != "main" {
+		t.Errorf("unexpected value: %q (%v)", have, ok)
+	}
+	if have := suffixed.ValueWithSuffix("github.com/foo/bar", "other"); have != "" {
+		t.Errorf("unexpected value: %q", have)
+	}
+}
+
+func TestOverridableRoundTrip(t *testing.T) {
+	unmarshallers := map[string]func([]byte, interface{}) error{
+		"JSON":    json.Unmarshal,
+		"yaml.v2": yaml.Unmarshal,
+		"yaml.v3": yamlv3.Unmarshal,
+	}
+
+	for name, in := range map[string]string{
+		"scalar":   `42`,
+		"rules":    `[{"*":1},{"github.com/internal/*":2}]`,
+		"no rules": `0`,
+	} {
+		for format, unmarshal := range unmarshallers {
+			t.Run("int/"+name+"/"+format, func(t *testing.T) {
+				var have Overridable[int]
+				if err := unmarshal([]byte(in), &have); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				data, err := json.Marshal(have)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if string(data) != in {
+					t.Errorf("unexpected JSON: have=%s want=%s", data, in)
+				}
+			})
+		}
+	}
+
+	for name, in := range map[string]string{
+		"scalar": `["a","b"]`,
+		"rules":  `[{"*":["a"]},{"github.com/internal/*":["b","c"]}]`,
+		"empty":  `[]`,
+	} {
+		for format, unmarshal := range unmarshallers {
+			t.Run("list/"+name+"/"+format, func(t *testing.T) {
+				var have Overridable[[]string]
+				if err := unmarshal([]byte(in), &have); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				var again Overridable[[]string]
+				data, err := json.Marshal(have)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if err := json.Unmarshal(data, &again); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if diff := cmp.Diff(again, have); diff != "" {
+					t.Errorf("unexpected value after round trip:\n%s", diff)
+				}
+			})
+		}
+	}
+}
+
+func TestOverridableUnmarshalInvalid(t *testing.T) {
+	for name, tc := range map[string]struct {
+		json, yaml string
+	}{
+		"invalid type":       {json: `"foo"`, yaml: `foo`},
+		"invalid rule value": {json: `[{"*":1},{"bar":"baz"}]`, yaml: "- '*': 1\n- bar: baz"},
+		"invalid glob":       {json: `[{"[":1}]`, yaml: `- "[": 1`},
+		"invalid suffix":     {json: `[{"*@release-[":1}]`, yaml: `- "*@release-[": 1`},
+		"too many elements":  {json: `[{"a":1,"c":2}]`, yaml: "- a: 1\n  c: 2"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			o := From(5)
+			if err := json.Unmarshal([]byte(tc.json), &o); err == nil {
+				t.Error("unexpected nil error from JSON")
+			}
+			if err := yaml.Unmarshal([]byte(tc.yaml), &o); err == nil {
+				t.Error("unexpected nil error from YAML")
+			}
+			if have := o.Value("foo"); have != 5 {
+				t.Errorf("unexpected value after failed unmarshal: have=%d want=5", have)
+			}
+		})
+	}
+}
+
+func TestOverridableEqual(t *testing.T) {
+	a := From([]string{"a", "b"})
+	b := From([]string{"a", "b"})
+	c := From([]string{"a"})
+
+	if !a.Equal(b) {
+		t.Error("unexpected inequality")
+	}
+	if a.Equal(c) {
+		t.Error("unexpected equality")
+	}
+}
+
+func initOverridable[T any](o *Overridable[T]) (err error) {
+	for i, rule := range o.rules {
+		if rule.compiled == nil {
+			o.rules[i], err = newRule(rule.key(), rule.value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
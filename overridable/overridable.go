@@ -4,6 +4,8 @@ package overridable
 
 import (
 	"encoding/json"
+	"fmt"
+	"reflect"
 	"strings"
 
 	"github.com/gobwas/glob"
@@ -25,21 +27,28 @@ func simpleRule(v interface{}) *rule {
 	return r
 }
 
-type complex []map[string]interface{}
-
-
 type rule struct {
 	// pattern is the glob-syntax pattern, such as "a/b/ceee-*"
 	pattern string
-	// patternSuffix is an optional suffix that can be appended to the pattern with "@"
+	// patternSuffix is an optional glob-syntax pattern for the branch name that
+	// can be appended to the pattern with "@", such as "release-*"
 	patternSuffix string
 
-	compiled glob.Glob
-	value    interface{}
+	compiled       glob.Glob
+	compiledSuffix glob.Glob
+	value          interface{}
 }
 
-// newRule builds a new rule instance, ensuring that the glob pattern
-// is compiled.
+// newRule builds a new rule instance, ensuring that the glob pattern and
+// suffix are compiled.
+//
+// A suffix always matches the branch with exactly the same name. Suffixes
+// containing glob metacharacters (see globMeta) also match any branch the glob
+// matches, in which "/" is treated as a separator, so that "feature/*" only
+// matches branches directly under "feature/", and "feature/**" matches every
+// branch under it. Since git doesn't allow most metacharacters in branch
+// names, this only changes the meaning of suffixes containing braces, such as
+// "release-{1,2}", which now also matches "release-1" and "release-2".
 func newRule(pattern string, value interface{}) (*rule, error) {
 	var suffix string
 	split := strings.SplitN(pattern, "@", 2)
@@ -53,16 +62,42 @@ func newRule(pattern string, value interface{}) (*rule, error) {
 		return nil, err
 	}
 
+	var compiledSuffix glob.Glob
+	if strings.ContainsAny(suffix, globMeta) {
+		compiledSuffix, err = glob.Compile(suffix, '/')
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid branch suffix %q", suffix)
+		}
+	}
+
 	return &rule{
-		pattern:       pattern,
-		patternSuffix: suffix,
-		compiled:      compiled,
-		value:         value,
+		pattern:        pattern,
+		patternSuffix:  suffix,
+		compiled:       compiled,
+		compiledSuffix: compiledSuffix,
+		value:          value,
 	}, nil
 }
 
+// globMeta are the characters that make a suffix a glob rather than a branch
+// name.
+const globMeta = `*?[{\`
+
+// key returns the pattern as it was written in the spec, including the suffix
+// if there is one.
+func (a rule) key() string {
+	if a.patternSuffix == "" {
+		return a.pattern
+	}
+	return a.pattern + "@" + a.patternSuffix
+}
+
 func (a rule) Equal(b rule) bool {
-	return a.pattern == b.pattern && a.value == b.value
+	// Values may be of any type that can be unmarshalled, including slices and
+	// maps, which can't be compared with ==.
+	return a.pattern == b.pattern &&
+		a.patternSuffix == b.patternSuffix &&
+		reflect.DeepEqual(a.value, b.value)
 }
 
 type rules []*rule
@@ -79,52 +114,114 @@ func (r rules) Match(name string) interface{} {
 }
 
 // MatchWithSuffix matches the given repository name against all rules and the
-// suffix against provided pattern suffix, returning the rule value that matches
-// at last, or nil if none match.
+// suffix against the glob pattern suffix of each rule, returning the rule value
+// that matches at last, or nil if none match. Rules without a pattern suffix
+// match any suffix.
 func (r rules) MatchWithSuffix(name, suffix string) interface{} {
 	// We want the last match to win, so we'll iterate in reverse order.
 	for i := len(r) - 1; i >= 0; i-- {
-		if r[i].compiled.Match(name) && (r[i].patternSuffix == "" || r[i].patternSuffix == suffix) {
+		if r[i].matchesWithSuffix(name, suffix) {
 			return r[i].value
 		}
 	}
 	return nil
 }
 
+// matchesWithSuffix returns true if the rule matches the given repository name
+// and suffix.
+func (a rule) matchesWithSuffix(name, suffix string) bool {
+	if !a.compiled.Match(name) {
+		return false
+	}
+	return a.patternSuffix == "" || a.patternSuffix == suffix || (a.compiledSuffix != nil && a.compiledSuffix.Match(suffix))
+}
+
 // MarshalJSON marshalls the bool into its JSON representation, which will
 // either be a literal or an array of objects.
 func (r rules) MarshalJSON() ([]byte, error) {
-	if len(r) == 1 && r[0].pattern == allPattern {
-		return json.Marshal(r[0].value)
+	return json.Marshal(r.marshal())
+}
+
+// MarshalYAML implements the yaml.v2 and yaml.v3 marshaller interfaces, using
+// the same representation as MarshalJSON.
+func (r rules) MarshalYAML() (interface{}, error) {
+	return r.marshal(), nil
+}
+
+// marshal returns the value to be marshalled: either the value of a single
+// rule that applies to every repository and branch, or a list of rules keyed by
+// their pattern and suffix.
+func (r rules) marshal() interface{} {
+	if len(r) == 1 && r[0].pattern == allPattern && r[0].patternSuffix == "" {
+		return r[0].value
 	}
 
 	rules := []map[string]interface{}{}
 	for _, rule := range r {
 		rules = append(rules, map[string]interface{}{
-			rule.pattern: rule.value,
+			rule.key(): rule.value,
 		})
 	}
-	return json.Marshal(rules)
+	return rules
 }
 
-// hydrateFromComplex builds an array of rules out of a complex value.
-func (r *rules) hydrateFromComplex(c []map[string]interface{}) error {
-	*r = make(rules, len(c))
+// hydrate builds an array of rules out of a list of single-entry maps. convert is called
+// with the value of each rule, and returns the value to store in the rule, or
+// an error if the value is invalid. r is only replaced if every rule is valid.
+func hydrate[V any](r *rules, c []map[string]V, convert func(V) (interface{}, error)) error {
+	hydrated := make(rules, len(c))
 	for i, rule := range c {
 		if len(rule) != 1 {
 			return errors.Errorf("unexpected number of elements in the array at entry %d: %d (must be 1)", i, len(rule))
 		}
-		for pattern, value := range rule {
-			var err error
-			(*r)[i], err = newRule(pattern, value)
+		for pattern, raw := range rule {
+			value, err := convert(raw)
+			if err != nil {
+				return errInvalidRuleValue{index: i, pattern: pattern, err: err}
+			}
+
+			hydrated[i], err = newRule(pattern, value)
 			if err != nil {
 				return errors.Wrapf(err, "building rule for array entry %d", i)
 			}
 		}
 	}
+
+	*r = hydrated
 	return nil
 }
 
+type errInvalidRuleValue struct {
+	index   int
+	pattern string
+	err     error
+}
+
+func (e errInvalidRuleValue) Error() string {
+	return fmt.Sprintf("invalid value for array entry %d (%q): %v", e.index, e.pattern, e.err)
+}
+
+// describe describes the type of a value unmarshalled into an interface{}, for
+// use in error messages.
+func describe(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "a bool"
+	case string:
+		return "a string"
+	case float64, int, int64, uint64:
+		return "a number"
+	case []interface{}:
+		return "a list"
+	case map[string]interface{}, map[interface{}]interface{}:
+		return "an object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
 // Equal tests two rules for equality. Used in cmp.
 func (r rules) Equal(other rules) bool {
 	if len(r) != len(other) {
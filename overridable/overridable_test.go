@@ -2,12 +2,20 @@ package overridable
 
 import (
 	"encoding/json"
+	"math/rand"
+	"strings"
 	"testing"
+	"testing/quick"
+
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
 )
 
 func TestRuleInvalid(t *testing.T) {
-	if _, err := newRule("[", true); err == nil {
-		t.Error("unexpected nil error")
+	for _, pattern := range []string{"[", "github.com/a/*@[", "*@release-[0-9"} {
+		if _, err := newRule(pattern, true); err == nil {
+			t.Errorf("unexpected nil error for %q", pattern)
+		}
 	}
 }
 
@@ -36,6 +44,14 @@ func TestRulesMarshalJSON(t *testing.T) {
 			},
 			want: `[{"*":true},{"bar*":false},{"foo*":"draft"}]`,
 		},
+		"suffixes": {
+			in: rules{
+				{pattern: allPattern, patternSuffix: "main", value: true},
+				{pattern: "bar*", value: false},
+				{pattern: "bar*", patternSuffix: "feature-1", value: "draft"},
+			},
+			want: `[{"*@main":true},{"bar*":false},{"bar*@feature-1":"draft"}]`,
+		},
 	} {
 		t.Run(name, func(t *testing.T) {
 			data, err := json.Marshal(&tc.in)
@@ -49,6 +65,153 @@ func TestRulesMarshalJSON(t *testing.T) {
 	}
 }
 
+func TestRulesMarshalYAML(t *testing.T) {
+	in := rules{
+		{pattern: allPattern, value: false},
+		{pattern: "github.com/a/*", patternSuffix: "main", value: true},
+	}
+	want := "- '*': false\n- github.com/a/*@main: true\n"
+
+	for name, marshal := range map[string]func(interface{}) ([]byte, error){
+		"yaml.v2": yaml.Marshal,
+		"yaml.v3": yamlv3.Marshal,
+	} {
+		t.Run(name, func(t *testing.T) {
+			data, err := marshal(in)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// yaml.v3 quotes with double quotes, so we normalise before
+			// comparing.
+			if have := strings.ReplaceAll(string(data), `"`, "'"); have != want {
+				t.Errorf("unexpected YAML: have=%q want=%q", have, want)
+			}
+		})
+	}
+}
+
+func TestRulesEqual(t *testing.T) {
+	a := rules{{pattern: "github.com/a/*", patternSuffix: "main", value: true}}
+	for name, tc := range map[string]struct {
+		b    rules
+		want bool
+	}{
+		"equal":          {b: rules{{pattern: "github.com/a/*", patternSuffix: "main", value: true}}, want: true},
+		"no suffix":      {b: rules{{pattern: "github.com/a/*", value: true}}, want: false},
+		"other suffix":   {b: rules{{pattern: "github.com/a/*", patternSuffix: "other", value: true}}, want: false},
+		"other pattern":  {b: rules{{pattern: "github.com/b/*", patternSuffix: "main", value: true}}, want: false},
+		"other value":    {b: rules{{pattern: "github.com/a/*", patternSuffix: "main", value: false}}, want: false},
+		"different size": {b: rules{}, want: false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if have := a.Equal(tc.b); have != tc.want {
+				t.Errorf("unexpected equality: have=%v want=%v", have, tc.want)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	// yaml.v2 never calls unmarshallers for a quoted "null", so it can't be
+	// the value of an overridable with a single rule.
+	strs := []string{"", "draft", "true", "1", "x y"}
+	pick := func(r *rand.Rand) string { return strs[r.Intn(len(strs))] }
+
+	t.Run("Bool", func(t *testing.T) {
+		checkRoundTrip(t, func(r rules) Bool { return Bool{rules: r} }, func(r *rand.Rand) interface{} {
+			return r.Intn(2) == 0
+		})
+	})
+	t.Run("BoolOrString", func(t *testing.T) {
+		checkRoundTrip(t, func(r rules) BoolOrString { return BoolOrString{rules: r} }, func(r *rand.Rand) interface{} {
+			if r.Intn(2) == 0 {
+				return r.Intn(2) == 0
+			}
+			return pick(r)
+		})
+	})
+	t.Run("String", func(t *testing.T) {
+		checkRoundTrip(t, func(r rules) String { return String{rules: r} }, func(r *rand.Rand) interface{} {
+			return pick(r)
+		})
+	})
+	t.Run("Overridable[int]", func(t *testing.T) {
+		checkRoundTrip(t, func(r rules) Overridable[int] { return Overridable[int]{rules: r} }, func(r *rand.Rand) interface{} {
+			return r.Intn(200) - 100
+		})
+	})
+	t.Run("Overridable[[]string]", func(t *testing.T) {
+		checkRoundTrip(t, func(r rules) Overridable[[]string] { return Overridable[[]string]{rules: r} }, func(r *rand.Rand) interface{} {
+			v := []string{}
+			for i := r.Intn(3); i > 0; i-- {
+				v = append(v, pick(r))
+			}
+			return v
+		})
+	})
+}
+
+// checkRoundTrip checks that random rules, with and without suffixes, survive
+// being marshalled and unmarshalled unchanged in JSON, yaml.v2, and yaml.v3.
+// build wraps the rules in the overridable type under test, and value returns a
+// random rule value of the type it expects.
+func checkRoundTrip[O interface{ Equal(O) bool }](t *testing.T, build func(rules) O, value func(*rand.Rand) interface{}) {
+	t.Helper()
+
+	for name, format := range map[string]struct {
+		marshal   func(interface{}) ([]byte, error)
+		unmarshal func([]byte, interface{}) error
+	}{
+		"JSON":    {json.Marshal, json.Unmarshal},
+		"yaml.v2": {yaml.Marshal, yaml.Unmarshal},
+		"yaml.v3": {yamlv3.Marshal, yamlv3.Unmarshal},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := quick.Check(func(seed int64) bool {
+				in := build(randomRules(t, rand.New(rand.NewSource(seed)), value))
+
+				data, err := format.marshal(in)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				var have O
+				if err := format.unmarshal(data, &have); err != nil {
+					t.Fatalf("unexpected error unmarshalling %s: %v", data, err)
+				}
+
+				if !have.Equal(in) {
+					t.Logf("rules did not round trip through %s", data)
+					return false
+				}
+				return true
+			}, nil); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// randomRules returns at least one compiled rule, with values generated by the
+// given function. Some patterns have suffixes.
+func randomRules(t *testing.T, r *rand.Rand, value func(*rand.Rand) interface{}) rules {
+	patterns := []string{allPattern, "github.com/a/*", "github.com/a/b", "*/b"}
+	suffixes := []string{"", "", "main", "feature-1", "release-*", "feature/**"}
+
+	rs := make(rules, 1+r.Intn(4))
+	for i := range rs {
+		pattern := patterns[r.Intn(len(patterns))]
+		if suffix := suffixes[r.Intn(len(suffixes))]; suffix != "" {
+			pattern += "@" + suffix
+		}
+
+		var err error
+		if rs[i], err = newRule(pattern, value(r)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	return rs
+}
+
 func TestMatchWithSuffix(t *testing.T) {
 	type ruleInputs struct {
 		pattern string
@@ -99,6 +262,69 @@ func TestMatchWithSuffix(t *testing.T) {
 			args: []string{"repo-1000", "branch-name"},
 			want: "rule-2",
 		},
+		"glob suffix": {
+			rules: []ruleInputs{
+				{pattern: "repo*", value: "rule-1"},
+				{pattern: "repo*@release-*", value: "rule-2"},
+			},
+			args: []string{"repo-1000", "release-3.1"},
+			want: "rule-2",
+		},
+		"glob suffix without match": {
+			rules: []ruleInputs{
+				{pattern: "repo*", value: "rule-1"},
+				{pattern: "repo*@release-*", value: "rule-2"},
+			},
+			args: []string{"repo-1000", "main"},
+			want: "rule-1",
+		},
+		"single star does not cross separators": {
+			rules: []ruleInputs{
+				{pattern: "repo*", value: "rule-1"},
+				{pattern: "repo*@feature/*", value: "rule-2"},
+			},
+			args: []string{"repo-1000", "feature/a/b"},
+			want: "rule-1",
+		},
+		"double star crosses separators": {
+			rules: []ruleInputs{
+				{pattern: "repo*", value: "rule-1"},
+				{pattern: "repo*@feature/**", value: "rule-2"},
+			},
+			args: []string{"repo-1000", "feature/a/b"},
+			want: "rule-2",
+		},
+		"literal suffix with braces": {
+			rules: []ruleInputs{
+				{pattern: "repo*", value: "rule-1"},
+				{pattern: "repo*@release-{1}", value: "rule-2"},
+			},
+			args: []string{"repo-1000", "release-{1}"},
+			want: "rule-2",
+		},
+		"braces match alternatives": {
+			rules: []ruleInputs{
+				{pattern: "repo*", value: "rule-1"},
+				{pattern: "repo*@release-{1,2}", value: "rule-2"},
+			},
+			args: []string{"repo-1000", "release-2"},
+			want: "rule-2",
+		},
+		"literal suffix without metacharacters": {
+			rules: []ruleInputs{
+				{pattern: "repo*", value: "rule-1"},
+				{pattern: "repo*@release-1!", value: "rule-2"},
+			},
+			args: []string{"repo-1000", "release-1!"},
+			want: "rule-2",
+		},
+		"exact suffix is not a prefix": {
+			rules: []ruleInputs{
+				{pattern: "repo*@main", value: "rule-1"},
+			},
+			args: []string{"repo-1000", "main-2"},
+			want: nil,
+		},
 	} {
 		t.Run(name, func(t *testing.T) {
 			rs := compileInputs(t, tc.rules)
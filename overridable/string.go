@@ -0,0 +1,9 @@
+package overridable
+
+// String represents a string value that can be modified on a per-repo basis.
+type String = Overridable[string]
+
+// FromString creates a String representing a static, scalar value.
+func FromString(s string) String {
+	return From(s)
+}
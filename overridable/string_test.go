@@ -0,0 +1,109 @@
+package overridable
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gopkg.in/yaml.v2"
+)
+
+func TestStringValue(t *testing.T) {
+	s := String{rules: rules{
+		{pattern: allPattern, value: "default"},
+		{pattern: "github.com/internal/*", value: "internal"},
+		{pattern: "github.com/internal/public", value: "public"},
+	}}
+	if err := initOverridable(&s); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"github.com/foo/bar":         "default",
+		"github.com/internal/bar":    "internal",
+		"github.com/internal/public": "public",
+	} {
+		if have, ok := s.Lookup(name); !ok || have != want {
+			t.Errorf("unexpected value for %q: have=%q (%v) want=%q", name, have, ok, want)
+		}
+	}
+
+	none := String{rules: rules{{pattern: "github.com/internal/*", value: "internal"}}}
+	if err := initOverridable(&none); err != nil {
+		t.Fatal(err)
+	}
+	if have, ok := none.Lookup("github.com/foo/bar"); ok {
+		t.Errorf("unexpected value: %q", have)
+	}
+}
+
+func TestStringRoundTrip(t *testing.T) {
+	for name, in := range map[string]string{
+		"scalar": `"foo"`,
+		"rules":  `[{"*":"foo"},{"github.com/internal/*":"bar"}]`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var s String
+			if err := json.Unmarshal([]byte(in), &s); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			data, err := json.Marshal(s)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(data) != in {
+				t.Errorf("unexpected JSON: have=%s want=%s", data, in)
+			}
+
+			var yamlString String
+			if err := yaml.Unmarshal(data, &yamlString); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(yamlString, s); diff != "" {
+				t.Errorf("unexpected YAML value:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestStringNoRules(t *testing.T) {
+	var s String
+	if err := json.Unmarshal([]byte(`[]`), &s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if have, ok := s.Lookup("github.com/foo/bar"); ok {
+		t.Errorf("unexpected value: %q", have)
+	}
+
+	// As with any Overridable, a String without rules is marshalled as the
+	// zero value.
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if have, want := string(data), `""`; have != want {
+		t.Errorf("unexpected JSON: have=%s want=%s", have, want)
+	}
+}
+
+func TestStringUnmarshalInvalid(t *testing.T) {
+	for name, tc := range map[string]struct {
+		json, yaml string
+	}{
+		"invalid type":       {json: `false`, yaml: `{a: b}`},
+		"invalid rule value": {json: `[{"*":"foo"},{"bar":1}]`, yaml: "- '*': foo\n- bar: 1"},
+		"invalid glob":       {json: `[{"[":"foo"}]`, yaml: `- "[": foo`},
+		"too many elements":  {json: `[{"a":"b","c":"d"}]`, yaml: "- a: b\n  c: d"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var s String
+			if err := json.Unmarshal([]byte(tc.json), &s); err == nil {
+				t.Error("unexpected nil error from JSON")
+			}
+			if err := yaml.Unmarshal([]byte(tc.yaml), &s); err == nil {
+				t.Error("unexpected nil error from YAML")
+			}
+		})
+	}
+}
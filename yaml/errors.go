@@ -0,0 +1,324 @@
+package yaml
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+
+	"github.com/sourcegraph/batch-change-utils/internal/jsonwalk"
+	"github.com/sourcegraph/batch-change-utils/jsonschema"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// ValidationError is a schema violation that has been mapped back to its
+// location within the source YAML document.
+type ValidationError struct {
+	*jsonschema.ValidationError
+
+	// Line and Column are the 1-based position of the offending value within
+	// the YAML document, or zero if the position is unknown.
+	Line   int
+	Column int
+}
+
+func (e *ValidationError) Error() string {
+	return position(e.Line, e.Column) + e.ValidationError.Error()
+}
+
+func (e *ValidationError) Unwrap() error { return e.ValidationError }
+
+// ValidationErrors is the error returned when a YAML document doesn't
+// validate against a schema. Callers can use errors.As to retrieve it.
+type ValidationErrors []*ValidationError
+
+// Error formats the errors in the same way as multierror.
+func (es ValidationErrors) Error() string {
+	return multierror.ListFormatFunc(es.WrappedErrors())
+}
+
+// WrappedErrors returns the individual validation errors. It implements the
+// errwrap.Wrapper interface, in the same way multierror.Error does.
+func (es ValidationErrors) WrappedErrors() []error {
+	errs := make([]error, len(es))
+	for i, err := range es {
+		errs[i] = err
+	}
+	return errs
+}
+
+// As allows the underlying jsonschema.ValidationErrors to be retrieved with
+// errors.As, for callers that aren't interested in positions.
+func (es ValidationErrors) As(target interface{}) bool {
+	t, ok := target.(*jsonschema.ValidationErrors)
+	if !ok {
+		return false
+	}
+
+	*t = make(jsonschema.ValidationErrors, len(es))
+	for i, err := range es {
+		(*t)[i] = err.ValidationError
+	}
+	return true
+}
+
+// Error is an error that occurred while unmarshalling a value within a YAML
+// document, such as an error returned by a custom unmarshaller.
+type Error struct {
+	// Pointer is the JSON pointer to the value that couldn't be unmarshalled.
+	Pointer string
+
+	// Line and Column are the 1-based position of the value within the YAML
+	// document, or zero if the position is unknown.
+	Line   int
+	Column int
+
+	Err error
+}
+
+func (e *Error) Error() string {
+	return position(e.Line, e.Column) + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// UnknownFieldError is returned in strict mode for each mapping key that
+// doesn't correspond to a field in the target.
+type UnknownFieldError struct {
+	// Pointer is the JSON pointer to the unknown member.
+	Pointer string
+
+	// Line and Column are the 1-based position of the key within the YAML
+	// document, or zero if the position is unknown.
+	Line   int
+	Column int
+}
+
+func (e *UnknownFieldError) Error() string {
+	return position(e.Line, e.Column) + fmt.Sprintf("unknown field %q", jsonwalk.Path(e.Pointer))
+}
+
+// DuplicateKeyError is returned in strict mode for each mapping key that
+// repeats an earlier key in the same mapping.
+type DuplicateKeyError struct {
+	// Pointer is the JSON pointer to the duplicated member.
+	Pointer string
+
+	// Line and Column are the 1-based position of the duplicate key within
+	// the YAML document.
+	Line   int
+	Column int
+}
+
+func (e *DuplicateKeyError) Error() string {
+	return position(e.Line, e.Column) + fmt.Sprintf("duplicate key %q", jsonwalk.Path(e.Pointer))
+}
+
+func position(line, column int) string {
+	if line == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d: ", line, column)
+}
+
+// document is a parsed YAML document, used to map JSON pointers within its
+// JSON representation back to positions within the YAML source.
+type document struct {
+	root *yamlv3.Node
+}
+
+func parseDocument(input []byte) *document {
+	var root yamlv3.Node
+	if err := yamlv3.Unmarshal(input, &root); err != nil {
+		// The input is either valid YAML or fails normalization, so this
+		// shouldn't happen; if it does, we just can't provide positions.
+		return &document{}
+	}
+	return &document{root: &root}
+}
+
+// position returns the position of the value the JSON pointer refers to, or
+// zeros if it cannot be found.
+func (d *document) position(pointer string) (line, column int) {
+	if node := d.lookup(pointer); node != nil {
+		return node.Line, node.Column
+	}
+	return 0, 0
+}
+
+// keyPosition returns the position of the given key within the mapping the
+// JSON pointer refers to. If the key cannot be found, the position of the
+// mapping is returned instead.
+func (d *document) keyPosition(pointer, key string) (line, column int) {
+	node := d.lookup(pointer)
+	if node == nil {
+		return 0, 0
+	}
+	if k, _ := member(node, key); k != nil {
+		return k.Line, k.Column
+	}
+	return node.Line, node.Column
+}
+
+func (d *document) lookup(pointer string) *yamlv3.Node {
+	node := resolve(d.root)
+	for _, token := range jsonwalk.Split(pointer) {
+		if node == nil {
+			return nil
+		}
+
+		switch node.Kind {
+		case yamlv3.MappingNode:
+			_, node = member(node, token)
+		case yamlv3.SequenceNode:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(node.Content) {
+				return nil
+			}
+			node = resolve(node.Content[i])
+		default:
+			return nil
+		}
+	}
+
+	return node
+}
+
+// duplicateKeys returns an error for every mapping key within the document
+// that repeats an earlier key in the same mapping.
+func (d *document) duplicateKeys() []error {
+	var errs []error
+
+	// Aliased nodes are checked where they're defined, so we don't follow
+	// aliases here: besides avoiding duplicate errors, this also means we
+	// don't have to worry about recursive aliases.
+	var walk func(node *yamlv3.Node, pointer string)
+	walk = func(node *yamlv3.Node, pointer string) {
+		switch node.Kind {
+		case yamlv3.DocumentNode:
+			for _, child := range node.Content {
+				walk(child, pointer)
+			}
+
+		case yamlv3.MappingNode:
+			seen := make(map[string]bool, len(node.Content)/2)
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				k, v := node.Content[i], node.Content[i+1]
+				if k.Tag == "!!merge" {
+					continue
+				}
+
+				child := pointer + "/" + jsonwalk.Escape(k.Value)
+				if seen[k.Value] {
+					errs = append(errs, &DuplicateKeyError{Pointer: child, Line: k.Line, Column: k.Column})
+				}
+				seen[k.Value] = true
+				walk(v, child)
+			}
+
+		case yamlv3.SequenceNode:
+			for i, child := range node.Content {
+				walk(child, pointer+"/"+strconv.Itoa(i))
+			}
+		}
+	}
+	if d.root != nil {
+		walk(d.root, "")
+	}
+
+	return errs
+}
+
+// withoutDuplicateKeys removes every mapping key within the document that is
+// repeated later in the same mapping, so that the last value wins, and returns
+// the resulting YAML. The nodes keep their positions in the original input.
+func (d *document) withoutDuplicateKeys() ([]byte, error) {
+	// As in duplicateKeys, aliased nodes are handled where they're defined.
+	var walk func(node *yamlv3.Node)
+	walk = func(node *yamlv3.Node) {
+		switch node.Kind {
+		case yamlv3.DocumentNode, yamlv3.SequenceNode:
+			for _, child := range node.Content {
+				walk(child)
+			}
+
+		case yamlv3.MappingNode:
+			last := make(map[string]int, len(node.Content)/2)
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if k := node.Content[i]; k.Tag != "!!merge" {
+					last[k.Value] = i
+				}
+			}
+
+			content := node.Content[:0]
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				k, v := node.Content[i], node.Content[i+1]
+				if k.Tag != "!!merge" && last[k.Value] != i {
+					continue
+				}
+				content = append(content, k, v)
+				walk(v)
+			}
+			node.Content = content
+		}
+	}
+	if d.root == nil {
+		return nil, errors.New("no document to remove duplicate keys from")
+	}
+	walk(d.root)
+
+	return yamlv3.Marshal(d.root)
+}
+
+// member returns the key and value nodes for the given key within a mapping
+// node, following merge keys if required.
+func member(mapping *yamlv3.Node, key string) (k, v *yamlv3.Node) {
+	if mapping.Kind != yamlv3.MappingNode {
+		return nil, nil
+	}
+
+	var merges []*yamlv3.Node
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		k, v := mapping.Content[i], resolve(mapping.Content[i+1])
+		if k.Tag == "!!merge" {
+			merges = append(merges, v)
+		} else if k.Value == key {
+			return k, v
+		}
+	}
+
+	// Keys defined directly in the mapping take precedence over merged keys.
+	for _, merge := range merges {
+		sources := []*yamlv3.Node{merge}
+		if merge.Kind == yamlv3.SequenceNode {
+			sources = merge.Content
+		}
+		for _, source := range sources {
+			if k, v := member(resolve(source), key); k != nil {
+				return k, v
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// resolve unwraps document and alias nodes.
+func resolve(node *yamlv3.Node) *yamlv3.Node {
+	for node != nil {
+		switch node.Kind {
+		case yamlv3.DocumentNode:
+			if len(node.Content) == 0 {
+				return nil
+			}
+			node = node.Content[0]
+		case yamlv3.AliasNode:
+			node = node.Alias
+		default:
+			return node
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package yaml
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDocumentPosition(t *testing.T) {
+	doc := parseDocument([]byte(`a: 1
+b:
+  - c
+  - d: "e"
+    "f/g": h
+base: &base
+  i: j
+merged:
+  <<: *base
+  k: l
+alias: *base
+`))
+
+	for pointer, want := range map[string][2]int{
+		"":              {1, 1},
+		"/a":            {1, 4},
+		"/b":            {3, 3},
+		"/b/0":          {3, 5},
+		"/b/1/d":        {4, 8},
+		"/b/1/f~1g":     {5, 12},
+		"/merged/i":     {7, 6},
+		"/merged/k":     {10, 6},
+		"/alias/i":      {7, 6},
+		"/missing":      {0, 0},
+		"/b/2":          {0, 0},
+		"/b/foo":        {0, 0},
+		"/a/too/deep":   {0, 0},
+		"/b/1/d/nested": {0, 0},
+	} {
+		t.Run(pointer, func(t *testing.T) {
+			line, column := doc.position(pointer)
+			if have := [2]int{line, column}; have != want {
+				t.Errorf("unexpected position: have=%v want=%v", have, want)
+			}
+		})
+	}
+
+	t.Run("key", func(t *testing.T) {
+		if line, column := doc.keyPosition("/b/1", "f/g"); line != 5 || column != 5 {
+			t.Errorf("unexpected position: have=%d:%d want=5:5", line, column)
+		}
+		if line, column := doc.keyPosition("/b/1", "missing"); line != 4 || column != 5 {
+			t.Errorf("unexpected position: have=%d:%d want=4:5", line, column)
+		}
+	})
+
+	t.Run("empty document", func(t *testing.T) {
+		if line, column := parseDocument(nil).position(""); line != 0 || column != 0 {
+			t.Errorf("unexpected position: have=%d:%d want=0:0", line, column)
+		}
+	})
+}
+
+func TestErrorMessages(t *testing.T) {
+	errFoo := errors.New("foo")
+	if have, want := (&Error{Line: 4, Column: 2, Err: errFoo}).Error(), "4:2: foo"; have != want {
+		t.Errorf("unexpected error: have=%q want=%q", have, want)
+	}
+	if have, want := (&Error{Err: errFoo}).Error(), "foo"; have != want {
+		t.Errorf("unexpected error: have=%q want=%q", have, want)
+	}
+}
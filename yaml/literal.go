@@ -0,0 +1,71 @@
+package yaml
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/sourcegraph/batch-change-utils/internal/jsonwalk"
+	"github.com/sourcegraph/batch-change-utils/internal/literal"
+)
+
+// literalNumbers returns the normalized input to unmarshal into the target, in
+// which the numbers within values of types registered with the internal
+// literal package are replaced by strings containing their text in the YAML
+// document. If there are no such values, or the input can't be walked, the
+// normalized input is returned as is.
+//
+// Normalizing YAML to JSON would otherwise turn 1.10 into 1.1 and 0x1F into
+// 31 before the target sees them, whereas in an env.Environment VERSION: 1.10
+// is the string 1.10. Schema validation still sees the normalized numbers.
+func literalNumbers(parse func() *document, normalized []byte, target interface{}) []byte {
+	t := reflect.TypeOf(target)
+	if t == nil || t.Kind() != reflect.Ptr {
+		return normalized
+	}
+
+	within, err := jsonwalk.Find(normalized, t.Elem(), literal.Numbers)
+	if err != nil || len(within) == 0 {
+		return normalized
+	}
+
+	numbers, err := jsonwalk.Numbers(normalized)
+	if err != nil {
+		return normalized
+	}
+
+	doc := parse()
+	values := map[string]json.RawMessage{}
+	for _, pointer := range numbers {
+		if !nestedWithin(pointer, within) {
+			continue
+		}
+		node := doc.lookup(pointer)
+		if node == nil {
+			continue
+		}
+		if tag := node.ShortTag(); tag != "!!int" && tag != "!!float" {
+			continue
+		}
+		if values[pointer], err = json.Marshal(node.Value); err != nil {
+			return normalized
+		}
+	}
+
+	data, err := jsonwalk.Replace(normalized, values)
+	if err != nil {
+		return normalized
+	}
+	return data
+}
+
+// nestedWithin returns true if the JSON pointer refers to one of the values
+// the other pointers refer to, or a value nested within one of them.
+func nestedWithin(pointer string, others []string) bool {
+	for _, other := range others {
+		if pointer == other || strings.HasPrefix(pointer, other+"/") {
+			return true
+		}
+	}
+	return false
+}
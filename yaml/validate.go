@@ -2,33 +2,191 @@ package yaml
 
 import (
 	"encoding/json"
+	"reflect"
+	"strings"
 
 	"github.com/ghodss/yaml"
 	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
 
+	"github.com/sourcegraph/batch-change-utils/internal/jsonwalk"
+	"github.com/sourcegraph/batch-change-utils/internal/unmarshal"
 	"github.com/sourcegraph/batch-change-utils/jsonschema"
 
 	yamlv3 "gopkg.in/yaml.v3"
 )
 
+// Options configures the behaviour of UnmarshalValidateWithOptions.
+type Options struct {
+	// Strict causes mapping keys that don't correspond to a field in the
+	// target to be returned as UnknownFieldErrors, and keys that repeat an
+	// earlier key in the same mapping to be returned as DuplicateKeyErrors,
+	// along with any other errors, for which the last duplicate wins.
+	// Otherwise, unknown keys are ignored, and duplicate keys result in a
+	// single, untyped error when the input is normalized.
+	Strict bool
+
+	// Atomic causes the target to be left untouched unless the input is valid
+	// and can be unmarshalled in its entirety. If validation fails, only the
+	// validation errors are returned. Otherwise, all errors are collected and
+	// the target may be partially modified.
+	Atomic bool
+}
+
 // UnmarshalValidate validates the input, which can be YAML or JSON, against
 // the provided JSON schema. If the validation is successful the validated
 // input is unmarshalled into the target.
+//
+// The schema is compiled on every call: use UnmarshalValidateSchema with a
+// schema compiled by jsonschema.Compile when validating more than one input.
 func UnmarshalValidate(schema string, input []byte, target interface{}) error {
+	sc, err := jsonschema.Compile(schema)
+	if err != nil {
+		return err
+	}
+
+	return UnmarshalValidateSchema(sc, input, target)
+}
+
+// UnmarshalValidateSchema validates the input, which can be YAML or JSON,
+// against the provided compiled JSON schema. If the validation is successful
+// the validated input is unmarshalled into the target.
+//
+// Schema violations are returned as a ValidationErrors, and errors
+// unmarshalling individual values as an *Error, both of which can be
+// retrieved from the returned error with errors.As. Both include the position
+// of the offending value within the input.
+func UnmarshalValidateSchema(schema *jsonschema.Schema, input []byte, target interface{}) error {
+	return UnmarshalValidateWithOptions(schema, input, target, Options{})
+}
+
+// UnmarshalValidateWithOptions behaves like UnmarshalValidateSchema, with
+// additional checks configured by opts.
+func UnmarshalValidateWithOptions(schema *jsonschema.Schema, input []byte, target interface{}, opts Options) error {
+	// The YAML node tree is only needed to report errors and check for
+	// duplicate keys, so we only parse it when required.
+	var doc *document
+	parse := func() *document {
+		if doc == nil {
+			doc = parseDocument(input)
+		}
+		return doc
+	}
+
+	errs := &multierror.Error{ErrorFormat: jsonschema.ListFormatFunc}
+
+	// Duplicate keys would cause normalization to fail, so we have to check
+	// for them first. As in JSON, the last duplicate wins, so that the
+	// remaining checks can still be made.
+	if opts.Strict {
+		if duplicates := parse().duplicateKeys(); len(duplicates) > 0 {
+			errs = multierror.Append(errs, duplicates...)
+
+			deduplicated, err := parse().withoutDuplicateKeys()
+			if err != nil {
+				return errs
+			}
+			input = deduplicated
+		}
+	}
+
 	normalized, err := yaml.YAMLToJSONCustom(input, yamlv3.Unmarshal)
 	if err != nil {
-		return errors.Wrapf(err, "failed to normalize JSON")
+		err = errors.Wrapf(err, "failed to normalize JSON")
+		if len(errs.Errors) > 0 {
+			return multierror.Append(errs, err)
+		}
+		return err
 	}
 
-	var errs *multierror.Error
-	if err := jsonschema.Validate(schema, normalized); err != nil {
-		errs = multierror.Append(errs, err)
+	if err := schema.Validate(normalized); err != nil {
+		errs = multierror.Append(errs, locateValidationErrors(parse(), err))
+	}
+
+	if opts.Strict {
+		errs = multierror.Append(errs, unknownFieldErrors(parse(), normalized, target)...)
+	}
+
+	data := literalNumbers(parse, normalized, target)
+
+	if opts.Atomic {
+		if errs.ErrorOrNil() != nil {
+			return errs
+		}
+		if err := unmarshal.Atomic(data, target); err != nil {
+			return multierror.Append(errs, locateUnmarshalError(parse(), data, target, err))
+		}
+		return nil
 	}
 
-	if err := json.Unmarshal(normalized, target); err != nil {
-		errs = multierror.Append(errs, err)
+	if err := json.Unmarshal(data, target); err != nil {
+		errs = multierror.Append(errs, locateUnmarshalError(parse(), data, target, err))
 	}
 
 	return errs.ErrorOrNil()
 }
+
+// locateValidationErrors adds positions to the schema violations within err.
+func locateValidationErrors(doc *document, err error) error {
+	var ves jsonschema.ValidationErrors
+	if !errors.As(err, &ves) {
+		return err
+	}
+
+	located := make(ValidationErrors, len(ves))
+	for i, ve := range ves {
+		located[i] = &ValidationError{ValidationError: ve}
+
+		// Violations of additionalProperties are reported against the object,
+		// but it's far more useful to point at the property itself.
+		if property, ok := ve.Details["property"].(string); ok && ve.Keyword == "additionalProperties" {
+			located[i].Line, located[i].Column = doc.keyPosition(ve.Pointer, property)
+		} else {
+			located[i].Line, located[i].Column = doc.position(ve.Pointer)
+		}
+	}
+
+	return located
+}
+
+// locateUnmarshalError finds the value within the normalized input that
+// caused json.Unmarshal to fail, and adds its position to err.
+func locateUnmarshalError(doc *document, normalized []byte, target interface{}, err error) error {
+	t := reflect.TypeOf(target)
+	if t == nil || t.Kind() != reflect.Ptr {
+		return err
+	}
+
+	pointer, lerr := jsonwalk.Locate(normalized, t.Elem())
+	if lerr == nil {
+		return err
+	}
+
+	line, column := doc.position(pointer)
+	return &Error{Pointer: pointer, Line: line, Column: column, Err: err}
+}
+
+// unknownFieldErrors returns an error for every mapping key within the
+// normalized input that doesn't correspond to a field in the target.
+func unknownFieldErrors(doc *document, normalized []byte, target interface{}) []error {
+	t := reflect.TypeOf(target)
+	if t == nil || t.Kind() != reflect.Ptr {
+		return nil
+	}
+
+	unknown, err := jsonwalk.UnknownFields(normalized, t.Elem())
+	if err != nil {
+		return nil
+	}
+
+	errs := make([]error, len(unknown))
+	for i, pointer := range unknown {
+		e := &UnknownFieldError{Pointer: pointer}
+		if slash := strings.LastIndex(pointer, "/"); slash >= 0 {
+			key := jsonwalk.Split(pointer[slash:])[0]
+			e.Line, e.Column = doc.keyPosition(pointer[:slash], key)
+		}
+		errs[i] = e
+	}
+	return errs
+}
@@ -1,28 +1,35 @@
 package yaml
 
 import (
+	"errors"
+	"fmt"
 	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/hashicorp/go-multierror"
+
+	"github.com/sourcegraph/batch-change-utils/env"
+	"github.com/sourcegraph/batch-change-utils/jsonschema"
+	"github.com/sourcegraph/batch-change-utils/overridable"
 )
 
-func TestUnmarshalValidate(t *testing.T) {
-	type targetType struct {
-		A string
-		B int
-	}
+type targetType struct {
+	A string
+	B int
+}
 
-	schema := `{
-        "$schema": "http://json-schema.org/draft-07/schema#",
-        "$id": "https://github.com/sourcegraph/batch-change-utils/schema/test.schema.json",
-        "type": "object",
-        "properties": {
-            "a": { "type": "string" },
-            "b": { "type": "integer" }
-        }
-    }`
+const schema = `{
+    "$schema": "http://json-schema.org/draft-07/schema#",
+    "$id": "https://github.com/sourcegraph/batch-change-utils/schema/test.schema.json",
+    "type": "object",
+    "properties": {
+        "a": { "type": "string" },
+        "b": { "type": "integer" }
+    }
+}`
 
+func TestUnmarshalValidate(t *testing.T) {
 	t.Run("bad schema", func(t *testing.T) {
 		var target targetType
 		if err := UnmarshalValidate("{", []byte(""), &target); err == nil {
@@ -66,3 +73,523 @@ func TestUnmarshalValidate(t *testing.T) {
 		}
 	})
 }
+
+func TestUnmarshalValidateSchema(t *testing.T) {
+	sc, err := jsonschema.Compile(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("invalid input", func(t *testing.T) {
+		var target targetType
+		if err := UnmarshalValidateSchema(sc, []byte(`{"b": "bar"}`), &target); err == nil {
+			t.Error("unexpected nil error")
+		} else if !strings.Contains(err.Error(), "Invalid type") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("validation errors", func(t *testing.T) {
+		var target targetType
+		err := UnmarshalValidateSchema(sc, []byte("b: bar"), &target)
+
+		var errs jsonschema.ValidationErrors
+		if !errors.As(err, &errs) {
+			t.Fatalf("unexpected error of type %T: %v", err, err)
+		}
+		if len(errs) != 1 {
+			t.Fatalf("unexpected number of validation errors: %d", len(errs))
+		}
+		if have, want := errs[0].Pointer, "/b"; have != want {
+			t.Errorf("unexpected pointer: have=%q want=%q", have, want)
+		}
+		if have, want := errs[0].Keyword, "type"; have != want {
+			t.Errorf("unexpected keyword: have=%q want=%q", have, want)
+		}
+
+		// The validation errors must be listed alongside the unmarshalling
+		// error, rather than nested.
+		if !strings.HasPrefix(err.Error(), "2 errors occurred:\n\t* 1:4: b: Invalid type. Expected: integer, given: string\n\t* ") {
+			t.Errorf("unexpected error: %q", err.Error())
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			var target targetType
+			if err := UnmarshalValidateSchema(sc, []byte("a: hello\nb: 42\n"), &target); err != nil {
+				t.Errorf("unexpected non-nil error: %v", err)
+			}
+
+			if diff := cmp.Diff(target, targetType{"hello", 42}); diff != "" {
+				t.Errorf("unexpected target value:\n%s", diff)
+			}
+		}
+	})
+}
+
+func TestUnmarshalValidatePositions(t *testing.T) {
+	sc, err := jsonschema.Compile(`{
+        "$schema": "http://json-schema.org/draft-07/schema#",
+        "type": "object",
+        "properties": {
+            "name": { "type": "string" },
+            "published": { "type": "boolean" },
+            "steps": {
+                "type": "array",
+                "items": {
+                    "type": "object",
+                    "properties": {
+                        "run": { "type": "string" },
+                        "env": {}
+                    },
+                    "required": ["run"],
+                    "additionalProperties": false
+                }
+            }
+        }
+    }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type step struct {
+		Run string          `json:"run"`
+		Env env.Environment `json:"env"`
+	}
+	type spec struct {
+		Name      string           `json:"name"`
+		Published overridable.Bool `json:"published"`
+		Steps     []step           `json:"steps"`
+	}
+
+	t.Run("validation errors", func(t *testing.T) {
+		input := `name: foo
+defaults: &defaults
+  run: 42
+steps:
+  - run: echo
+    command: echo
+  - env: {}
+  - <<: *defaults
+`
+		var target spec
+		err := UnmarshalValidateSchema(sc, []byte(input), &target)
+
+		var errs ValidationErrors
+		if !errors.As(err, &errs) {
+			t.Fatalf("unexpected error of type %T: %v", err, err)
+		}
+
+		type position struct {
+			Pointer      string
+			Line, Column int
+		}
+		var have []position
+		for _, e := range errs {
+			have = append(have, position{e.Pointer, e.Line, e.Column})
+		}
+		want := []position{
+			{"/steps/0", 6, 5},
+			{"/steps/1", 7, 5},
+			{"/steps/2/run", 3, 8},
+		}
+		if diff := cmp.Diff(have, want); diff != "" {
+			t.Errorf("unexpected positions:\n%s", diff)
+		}
+
+		if have, want := errs[2].Error(), "3:8: steps.2.run: Invalid type. Expected: string, given: integer"; have != want {
+			t.Errorf("unexpected error: have=%q want=%q", have, want)
+		}
+	})
+
+	t.Run("custom unmarshallers", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			in          string
+			wantPointer string
+			wantLine    int
+			wantColumn  int
+		}{
+			"environment": {
+				in: `name: foo
+steps:
+  - run: echo
+  - run: echo
+    env:
+      - FOO: bar
+      - 42
+`,
+				wantPointer: "/steps/1/env",
+				wantLine:    6,
+				wantColumn:  7,
+			},
+			"overridable": {
+				in: `name: foo
+published:
+  - "*": true
+  - "[": false
+`,
+				wantPointer: "/published",
+				wantLine:    3,
+				wantColumn:  3,
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				var target spec
+				err := UnmarshalValidate(`{}`, []byte(tc.in), &target)
+
+				var e *Error
+				if !errors.As(err, &e) {
+					t.Fatalf("unexpected error of type %T: %v", err, err)
+				}
+				if e.Pointer != tc.wantPointer || e.Line != tc.wantLine || e.Column != tc.wantColumn {
+					t.Errorf("unexpected position: have=%s:%d:%d want=%s:%d:%d", e.Pointer, e.Line, e.Column, tc.wantPointer, tc.wantLine, tc.wantColumn)
+				}
+			})
+		}
+	})
+
+	t.Run("type errors", func(t *testing.T) {
+		var target spec
+		err := UnmarshalValidate(`{}`, []byte("steps:\n  - run: [1, 2]\n"), &target)
+
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Fatalf("unexpected error of type %T: %v", err, err)
+		}
+		if have, want := e.Pointer, "/steps/0/run"; have != want {
+			t.Errorf("unexpected pointer: have=%q want=%q", have, want)
+		}
+		if !strings.HasPrefix(e.Error(), "2:10: ") {
+			t.Errorf("unexpected error: %v", e)
+		}
+	})
+}
+
+func TestUnmarshalValidateWithOptions(t *testing.T) {
+	sc, err := jsonschema.Compile(`{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type nested struct {
+		C bool `json:"c"`
+	}
+	type strictTarget struct {
+		A string   `json:"a"`
+		N []nested `json:"n"`
+	}
+
+	t.Run("unknown fields", func(t *testing.T) {
+		input := `a: x
+b: 1
+n:
+  - c: true
+    d: 1
+`
+		var target strictTarget
+		if err := UnmarshalValidateWithOptions(sc, []byte(input), &target, Options{}); err != nil {
+			t.Errorf("unexpected non-nil error: %v", err)
+		}
+
+		err := UnmarshalValidateWithOptions(sc, []byte(input), &target, Options{Strict: true})
+		if err == nil {
+			t.Fatal("unexpected nil error")
+		}
+
+		var have []UnknownFieldError
+		for _, e := range err.(*multierror.Error).Errors {
+			if ue, ok := e.(*UnknownFieldError); ok {
+				have = append(have, *ue)
+			} else {
+				t.Errorf("unexpected error of type %T: %v", e, e)
+			}
+		}
+		want := []UnknownFieldError{
+			{Pointer: "/b", Line: 2, Column: 1},
+			{Pointer: "/n/0/d", Line: 5, Column: 5},
+		}
+		if diff := cmp.Diff(have, want); diff != "" {
+			t.Errorf("unexpected errors:\n%s", diff)
+		}
+		if !strings.Contains(err.Error(), `5:5: unknown field "n.0.d"`) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("duplicate keys", func(t *testing.T) {
+		input := `a: x
+n:
+  - c: true
+    c: false
+a: y
+`
+		var target strictTarget
+		err := UnmarshalValidateWithOptions(sc, []byte(input), &target, Options{Strict: true})
+		if err == nil {
+			t.Fatal("unexpected nil error")
+		}
+
+		var have []DuplicateKeyError
+		for _, e := range err.(*multierror.Error).Errors {
+			if de, ok := e.(*DuplicateKeyError); ok {
+				have = append(have, *de)
+			} else {
+				t.Errorf("unexpected error of type %T: %v", e, e)
+			}
+		}
+		want := []DuplicateKeyError{
+			{Pointer: "/n/0/c", Line: 4, Column: 5},
+			{Pointer: "/a", Line: 5, Column: 1},
+		}
+		if diff := cmp.Diff(have, want); diff != "" {
+			t.Errorf("unexpected errors:\n%s", diff)
+		}
+	})
+
+	t.Run("all errors", func(t *testing.T) {
+		sc, err := jsonschema.Compile(`{"properties":{"a":{"type":"string"}}}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		input := `a: x
+b: 1
+n:
+  - c: true
+    c: false
+    d: 1
+a: 2
+`
+		var target strictTarget
+		err = UnmarshalValidateWithOptions(sc, []byte(input), &target, Options{Strict: true})
+		if err == nil {
+			t.Fatal("unexpected nil error")
+		}
+
+		var have []string
+		for _, e := range err.(*multierror.Error).Errors {
+			switch e := e.(type) {
+			case *DuplicateKeyError:
+				have = append(have, fmt.Sprintf("duplicate %s %d:%d", e.Pointer, e.Line, e.Column))
+			case ValidationErrors:
+				for _, ve := range e {
+					have = append(have, fmt.Sprintf("schema %s %d:%d", ve.Pointer, ve.Line, ve.Column))
+				}
+			case *UnknownFieldError:
+				have = append(have, fmt.Sprintf("unknown %s %d:%d", e.Pointer, e.Line, e.Column))
+			case *Error:
+				have = append(have, fmt.Sprintf("unmarshal %s %d:%d", e.Pointer, e.Line, e.Column))
+			default:
+				t.Errorf("unexpected error of type %T: %v", e, e)
+			}
+		}
+		want := []string{
+			"duplicate /n/0/c 5:5",
+			"duplicate /a 7:1",
+			"schema /a 7:4",
+			"unknown /b 2:1",
+			"unknown /n/0/d 6:5",
+			"unmarshal /a 7:4",
+		}
+		if diff := cmp.Diff(have, want); diff != "" {
+			t.Errorf("unexpected errors:\n%s", diff)
+		}
+
+		// The errors are listed in the same way as the other errors.
+		if !strings.HasPrefix(err.Error(), "6 errors occurred:\n\t* 5:5: duplicate key") {
+			t.Errorf("unexpected message: %v", err)
+		}
+	})
+
+	t.Run("strict success", func(t *testing.T) {
+		var target strictTarget
+		if err := UnmarshalValidateWithOptions(sc, []byte("a: x\nn:\n  - c: true\n"), &target, Options{Strict: true}); err != nil {
+			t.Errorf("unexpected non-nil error: %v", err)
+		}
+		if diff := cmp.Diff(target, strictTarget{A: "x", N: []nested{{C: true}}}); diff != "" {
+			t.Errorf("unexpected target value:\n%s", diff)
+		}
+	})
+}
+
+func TestUnmarshalValidateAtomic(t *testing.T) {
+	sc, err := jsonschema.Compile(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original := targetType{A: "original", B: 1}
+
+	t.Run("validation errors", func(t *testing.T) {
+		input := []byte("a: 1\nb: x\n")
+
+		target := original
+		err := UnmarshalValidateWithOptions(sc, input, &target, Options{Atomic: true})
+		if err == nil {
+			t.Fatal("unexpected nil error")
+		}
+		if diff := cmp.Diff(target, original); diff != "" {
+			t.Errorf("target was modified:\n%s", diff)
+		}
+		if have := len(err.(*multierror.Error).Errors); have != 1 {
+			t.Errorf("unexpected number of errors: have=%d want=1: %v", have, err)
+		}
+
+		// Without Atomic, the unmarshalling errors are returned too, and the
+		// target is partially modified.
+		target = original
+		err = UnmarshalValidateWithOptions(sc, input, &target, Options{})
+		if have := len(err.(*multierror.Error).Errors); have != 2 {
+			t.Errorf("unexpected number of errors: have=%d want=2: %v", have, err)
+		}
+	})
+
+	t.Run("unmarshalling errors", func(t *testing.T) {
+		sc, err := jsonschema.Compile(`{}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		target := original
+		if err := UnmarshalValidateWithOptions(sc, []byte("a: z\nb: 1.5\n"), &target, Options{Atomic: true}); err == nil {
+			t.Fatal("unexpected nil error")
+		}
+		if diff := cmp.Diff(target, original); diff != "" {
+			t.Errorf("target was modified:\n%s", diff)
+		}
+	})
+
+	t.Run("values merged in place", func(t *testing.T) {
+		sc, err := jsonschema.Compile(`{}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		type inner struct{ N int }
+		type withInterface struct {
+			A string
+			X interface{}
+		}
+
+		target := withInterface{A: "orig", X: &inner{}}
+		if err := UnmarshalValidateWithOptions(sc, []byte("A: new\nX:\n  N: str\n"), &target, Options{Atomic: true}); err == nil {
+			t.Fatal("unexpected nil error")
+		}
+		if diff := cmp.Diff(target, withInterface{A: "orig", X: &inner{}}); diff != "" {
+			t.Errorf("target was modified:\n%s", diff)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		target := original
+		if err := UnmarshalValidateWithOptions(sc, []byte("b: 2\n"), &target, Options{Atomic: true}); err != nil {
+			t.Fatalf("unexpected non-nil error: %v", err)
+		}
+		if diff := cmp.Diff(target, targetType{A: "original", B: 2}); diff != "" {
+			t.Errorf("unexpected target value:\n%s", diff)
+		}
+	})
+}
+
+func TestUnmarshalValidateLiteralNumbers(t *testing.T) {
+	sc, err := jsonschema.Compile(`{
+		"type": "object",
+		"properties": {
+			"count": { "type": "integer" },
+			"env": { "type": ["object", "array"] },
+			"overridable": { "type": ["object", "array"] }
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type spec struct {
+		Count       int                         `json:"count"`
+		Env         env.Environment             `json:"env"`
+		Overridable *env.OverridableEnvironment `json:"overridable"`
+	}
+
+	input := `count: 0x1F
+env:
+  VERSION: 1.10
+  HEX: &hex 0x1F
+  PORT: 8080
+  EXP: 1e3
+  ALIAS: *hex
+  TEXT: "1.10"
+overridable:
+  - name: DEFAULT
+    default: 2.50
+  - NPM:
+      - "*": 1.0
+`
+
+	for name, opts := range map[string]Options{
+		"default": {},
+		"atomic":  {Atomic: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var have spec
+			if err := UnmarshalValidateWithOptions(sc, []byte(input), &have, opts); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// Numbers outside of environments are still normalized.
+			if have.Count != 31 {
+				t.Errorf("unexpected count: %d", have.Count)
+			}
+
+			resolved, err := have.Env.Resolve(nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want := map[string]string{
+				"VERSION": "1.10",
+				"HEX":     "0x1F",
+				"PORT":    "8080",
+				"EXP":     "1e3",
+				"ALIAS":   "0x1F",
+				"TEXT":    "1.10",
+			}
+			if diff := cmp.Diff(resolved, want); diff != "" {
+				t.Errorf("unexpected environment:\n%s", diff)
+			}
+
+			resolved, err = have.Overridable.ForRepo("github.com/a/b").Resolve(nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(resolved, map[string]string{"DEFAULT": "2.50", "NPM": "1.0"}); diff != "" {
+				t.Errorf("unexpected overridable environment:\n%s", diff)
+			}
+		})
+	}
+}
+
+func BenchmarkUnmarshalValidate(b *testing.B) {
+	input := []byte("a: hello\nb: 42\n")
+	for i := 0; i < b.N; i++ {
+		var target targetType
+		if err := UnmarshalValidate(schema, input, &target); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalValidateSchema(b *testing.B) {
+	sc, err := jsonschema.Compile(schema)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	input := []byte("a: hello\nb: 42\n")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var target targetType
+		if err := UnmarshalValidateSchema(sc, input, &target); err != nil {
+			b.Fatal(err)
+		}
+	}
+}